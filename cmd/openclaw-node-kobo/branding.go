@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/openclaw/openclaw-node-kobo/internal/canvas"
+)
+
+// maxLogoBytes and maxLogoSide bound a pushed logo, which stays decoded.
+const (
+	maxLogoBytes = 256 << 10
+	maxLogoSide  = 1024
+)
+
+// branding is a node.theme payload: config theme fields plus a base64 logo.
+type branding struct {
+	Theme canvas.Theme
+	Logo  image.Image
+}
+
+// decodeBranding validates a node.theme payload and applies it on top of base.
+func decodeBranding(base canvas.Theme, payload json.RawMessage) (branding, error) {
+	var fields struct {
+		Logo []byte `json:"logo"`
+		Font string `json:"font"`
+	}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return branding{}, err
+	}
+	if !canvas.HasFont(fields.Font) {
+		return branding{}, fmt.Errorf("unknown font %q", fields.Font)
+	}
+	theme, err := overlayTheme(base, payload)
+	if err != nil {
+		return branding{}, err
+	}
+	result := branding{Theme: theme}
+	if len(fields.Logo) == 0 {
+		return result, nil
+	}
+	if len(fields.Logo) > maxLogoBytes {
+		return branding{}, fmt.Errorf("logo is %d bytes, limit %d", len(fields.Logo), maxLogoBytes)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(fields.Logo))
+	if err != nil {
+		return branding{}, fmt.Errorf("decode logo: %w", err)
+	}
+	if config.Width > maxLogoSide || config.Height > maxLogoSide {
+		return branding{}, fmt.Errorf("logo is %dx%d, limit %dx%d", config.Width, config.Height, maxLogoSide, maxLogoSide)
+	}
+	if result.Logo, _, err = image.Decode(bytes.NewReader(fields.Logo)); err != nil {
+		return branding{}, fmt.Errorf("decode logo: %w", err)
+	}
+	return result, nil
+}
+
+// applyBranding applies a node.theme payload and caches it at path, if set.
+func applyBranding(handler *canvas.Handler, base canvas.Theme, payload json.RawMessage, path string) error {
+	brand, err := decodeBranding(base, payload)
+	if err != nil {
+		return err
+	}
+	handler.SetTheme(brand.Theme)
+	handler.SetLogo(brand.Logo)
+	if path == "" {
+		return nil
+	}
+	if err := os.WriteFile(path, payload, 0o600); err != nil {
+		return fmt.Errorf("save branding: %w", err)
+	}
+	return nil
+}
+
+// loadBranding applies the branding cached at path, if any.
+func loadBranding(handler *canvas.Handler, base canvas.Theme, path string) error {
+	payload, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return applyBranding(handler, base, payload, "")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openclaw/openclaw-node-kobo/internal/canvas"
+)
+
+const (
+	crashCountFile         = "crash-count"
+	defaultSafeModeCrashes = 3
+	// crashStableAfter is how long a run lasts before it counts as clean.
+	crashStableAfter = 10 * time.Minute
+)
+
+// recordStart bumps the crash counter at path and returns its previous value.
+func recordStart(path string) (int, error) {
+	crashes := 0
+	if data, err := os.ReadFile(path); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && n > 0 {
+			crashes = n
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return crashes, err
+	}
+	return crashes, os.WriteFile(path, []byte(strconv.Itoa(crashes+1)), 0o600)
+}
+
+// clearCrashes marks the run as clean, resetting the crash counter.
+func clearCrashes(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// safeModeFor reports whether crashes reach a nonzero threshold.
+func safeModeFor(crashes, threshold int) bool {
+	return threshold > 0 && crashes >= threshold
+}
+
+// safeModeScreen explains on the device why it is not behaving normally.
+func safeModeScreen(crashes int) canvas.SleepScreen {
+	lines := []string{
+		"Safe mode",
+		fmt.Sprintf("The node crashed %d times in a row.", crashes),
+		"Suspend, touch, custom fonts, and branding are off.",
+		"Check the logs, then restart the node to leave safe mode.",
+	}
+	components := make([]canvas.A2UIComponent, len(lines))
+	for i, line := range lines {
+		components[i] = canvas.A2UIComponent{Type: "text", Text: line, X: 20, Y: 20 + 30*i, Height: 30}
+	}
+	return canvas.SleepScreen{Components: components}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordStart_SafeModePastThreshold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", crashCountFile)
+	for want := 0; want < 4; want++ {
+		crashes, err := recordStart(path)
+		if err != nil {
+			t.Fatalf("record start: %v", err)
+		}
+		if crashes != want {
+			t.Fatalf("expected %d earlier crashes, got %d", want, crashes)
+		}
+		if got := safeModeFor(crashes, 3); got != (want >= 3) {
+			t.Fatalf("after %d crashes: expected safe mode %v", crashes, want >= 3)
+		}
+	}
+	if safeModeFor(10, 0) {
+		t.Fatalf("expected a zero threshold to disable safe mode")
+	}
+
+	if err := clearCrashes(path); err != nil {
+		t.Fatalf("clear crashes: %v", err)
+	}
+	if crashes, err := recordStart(path); err != nil || crashes != 0 {
+		t.Fatalf("expected a clean exit to reset the counter, got %d, %v", crashes, err)
+	}
+
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("write counter: %v", err)
+	}
+	if crashes, err := recordStart(path); err != nil || crashes != 0 {
+		t.Fatalf("expected an unreadable counter to count as no crashes, got %d, %v", crashes, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	discoveryTimeout    = 30 * time.Second
+	discoveryRetryDelay = 5 * time.Second
+	// maxDiscoveryBytes bounds the discovery document, which only names a
+	// gateway.
+	maxDiscoveryBytes = 64 << 10
+)
+
+// discoveredGateway is the document a discovery endpoint serves, with the
+// same fields as the config.
+type discoveredGateway struct {
+	Gateway     string `json:"gateway"`
+	GatewayPort int    `json:"gatewayPort,omitempty"`
+	GatewayTLS  bool   `json:"gatewayTLS,omitempty"`
+	GatewayPath string `json:"gatewayPath,omitempty"`
+}
+
+// discoverGateway fetches the gateway address from a discovery endpoint.
+func discoverGateway(ctx context.Context, client *http.Client, url string) (discoveredGateway, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return discoveredGateway{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return discoveredGateway{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return discoveredGateway{}, fmt.Errorf("discovery endpoint returned %s", resp.Status)
+	}
+	var found discoveredGateway
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDiscoveryBytes)).Decode(&found); err != nil {
+		return discoveredGateway{}, fmt.Errorf("decode discovery document: %w", err)
+	}
+	if found.Gateway == "" {
+		return discoveredGateway{}, errors.New("discovery document names no gateway")
+	}
+	return found, nil
+}
+
+// waitForGateway retries discovery until it succeeds or ctx is done, since
+// at boot the network may not be up yet.
+func waitForGateway(ctx context.Context, client *http.Client, url string) (discoveredGateway, error) {
+	for {
+		found, err := discoverGateway(ctx, client, url)
+		if err == nil {
+			return found, nil
+		}
+		log.Warn().Err(err).Str("url", url).Msg("gateway discovery failed, retrying")
+		if err := sleepContext(ctx, discoveryRetryDelay); err != nil {
+			return discoveredGateway{}, err
+		}
+	}
+}
+
+// applyDiscovered fills in the gateway fields the config leaves unset.
+func applyDiscovered(cfg *FileConfig, found discoveredGateway) {
+	if cfg.Gateway == "" {
+		cfg.Gateway = found.Gateway
+	}
+	if cfg.GatewayPort == 0 {
+		cfg.GatewayPort = found.GatewayPort
+	}
+	if cfg.GatewayPath == "" {
+		cfg.GatewayPath = found.GatewayPath
+	}
+	cfg.GatewayTLS = cfg.GatewayTLS || found.GatewayTLS
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiscoverGateway(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openclaw-gateway":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"gateway":"gw.example.ts.net","gatewayPort":8443,"gatewayTLS":true}`))
+		case "/empty":
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	found, err := discoverGateway(context.Background(), server.Client(), server.URL+"/.well-known/openclaw-gateway")
+	if err != nil {
+		t.Fatalf("discover gateway: %v", err)
+	}
+	cfg := FileConfig{GatewayPath: "/node"}
+	applyDiscovered(&cfg, found)
+	if cfg.Gateway != "gw.example.ts.net" || cfg.GatewayPort != 8443 || !cfg.GatewayTLS || cfg.GatewayPath != "/node" {
+		t.Fatalf("expected discovered gateway with the configured path kept, got %+v", cfg)
+	}
+
+	for _, path := range []string{"/empty", "/missing"} {
+		if _, err := discoverGateway(context.Background(), server.Client(), server.URL+path); err == nil {
+			t.Fatalf("expected discovery from %s to fail", path)
+		}
+	}
+}
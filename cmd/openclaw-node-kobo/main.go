@@ -1,17 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -25,24 +33,73 @@ import (
 )
 
 type FileConfig struct {
-	Gateway        string `json:"gateway"`
-	GatewayPort    int    `json:"gatewayPort,omitempty"`
-	GatewayTLS     bool   `json:"gatewayTLS,omitempty"`
-	GatewayPath    string `json:"gatewayPath,omitempty"`
-	Name           string `json:"name"`
-	StateDir       string `json:"stateDir,omitempty"`
-	TouchDevice    string `json:"touchDevice,omitempty"`
-	Framebuffer    string `json:"framebuffer,omitempty"`
-	LogLevel       string `json:"logLevel,omitempty"`
-	HTTPUserAgent  string `json:"httpUserAgent,omitempty"`
-	IdleTimeoutMin *int   `json:"idleTimeoutMin,omitempty"`
-	SuspendEnabled *bool  `json:"suspendEnabled,omitempty"`
-}
-
-var version = "dev"
+	Gateway             string              `json:"gateway"`
+	GatewayPort         int                 `json:"gatewayPort,omitempty"`
+	GatewayTLS          bool                `json:"gatewayTLS,omitempty"`
+	GatewayPath         string              `json:"gatewayPath,omitempty"`
+	AlternateGateways   []string            `json:"alternateGateways,omitempty"`
+	DiscoveryURL        string              `json:"discoveryUrl,omitempty"`
+	Name                string              `json:"name"`
+	StateDir            string              `json:"stateDir,omitempty"`
+	TouchDevice         deviceList          `json:"touchDevice,omitempty"`
+	PalmRejectionSize   int                 `json:"palmRejectionSize,omitempty"`
+	KeyRepeatDelayMs    int                 `json:"keyRepeatDelayMs,omitempty"`
+	KeyRepeatIntervalMs int                 `json:"keyRepeatIntervalMs,omitempty"`
+	Framebuffer         string              `json:"framebuffer,omitempty"`
+	LogLevel            string              `json:"logLevel,omitempty"`
+	HTTPUserAgent       string              `json:"httpUserAgent,omitempty"`
+	IdleTimeoutMin      json.RawMessage     `json:"idleTimeoutMin,omitempty"`
+	SuspendEnabled      *bool               `json:"suspendEnabled,omitempty"`
+	ActionEvent         string              `json:"actionEvent,omitempty"`
+	ActionFailNoticeMs  int                 `json:"actionFailureNoticeMs,omitempty"`
+	ScreenID            string              `json:"screenId,omitempty"`
+	VersionFile         string              `json:"versionFile,omitempty"`
+	InstanceID          string              `json:"instanceId,omitempty"`
+	HandshakeTimeoutSec int                 `json:"handshakeTimeoutSec,omitempty"`
+	ConnectTimeoutSec   int                 `json:"connectTimeoutSec,omitempty"`
+	CloseTimeoutMs      int                 `json:"closeTimeoutMs,omitempty"`
+	MinPingIntervalSec  int                 `json:"minPingIntervalSec,omitempty"`
+	MaxPingIntervalSec  int                 `json:"maxPingIntervalSec,omitempty"`
+	KeepaliveEvent      string              `json:"keepaliveEvent,omitempty"`
+	InvokeBatchMs       int                 `json:"invokeBatchMs,omitempty"`
+	MaxResultBytes      int                 `json:"maxResultBytes,omitempty"`
+	Theme               json.RawMessage     `json:"theme,omitempty"`
+	Palette             map[string]uint8    `json:"palette,omitempty"`
+	RenderBudgetMs      int                 `json:"renderBudgetMs,omitempty"`
+	RenderWatchdogMs    int                 `json:"renderWatchdogMs,omitempty"`
+	MinPresentMs        int                 `json:"minPresentIntervalMs,omitempty"`
+	ReopenOnRenderStall bool                `json:"reopenOnRenderStall,omitempty"`
+	SnapshotMaxBytes    *int                `json:"snapshotMaxBytes,omitempty"`
+	DeghostThreshold    float64             `json:"deghostThreshold,omitempty"`
+	FlashFullRefresh    bool                `json:"flashFullRefresh,omitempty"`
+	Dither              bool                `json:"dither,omitempty"`
+	DitherLevels        int                 `json:"ditherLevels,omitempty"`
+	IdleRefreshMin      int                 `json:"idleRefreshMin,omitempty"`
+	FBOpenAttempts      int                 `json:"framebufferOpenAttempts,omitempty"`
+	FBOpenRetryMs       int                 `json:"framebufferOpenRetryMs,omitempty"`
+	SleepScreen         json.RawMessage     `json:"sleepScreen,omitempty"`
+	SleepImage          string              `json:"sleepImage,omitempty"`
+	ClearOnExit         bool                `json:"clearOnExit,omitempty"`
+	ExitScreen          json.RawMessage     `json:"exitScreen,omitempty"`
+	ExitImage           string              `json:"exitImage,omitempty"`
+	ScopeCommands       map[string][]string `json:"scopeCommands,omitempty"`
+	OmitDeviceInfo      bool                `json:"omitDeviceInfo,omitempty"`
+	TokenClearReasons   []string            `json:"tokenClearReasons,omitempty"`
+	SafeModeCrashes     *int                `json:"safeModeAfterCrashes,omitempty"`
+	Fonts               map[string]string   `json:"fonts,omitempty"`
+	SleepCountdownSec   int                 `json:"sleepCountdownSec,omitempty"`
+	ResumeSettleMs      int                 `json:"resumeSettleMs,omitempty"`
+	HeartbeatSec        *int                `json:"heartbeatSec,omitempty"`
+	DoNotDisturb        string              `json:"doNotDisturb,omitempty"`
+}
+
+var (
+	version = "dev"
+	commit  = ""
+)
 
 func main() {
-	cfgPath := flag.String("config", "config.json", "path to config file")
+	cfgPath := flag.String("config", "config.json", "path to config file, or - to read it from stdin")
 	gatewayHost := flag.String("gateway", "", "gateway hostname")
 	gatewayPort := flag.Int("gateway-port", 0, "gateway port")
 	gatewayTLS := flag.Bool("gateway-tls", false, "use TLS for gateway")
@@ -51,11 +108,23 @@ func main() {
 	gatewayPassword := flag.String("gateway-password", "", "gateway auth password")
 	name := flag.String("name", "", "node name")
 	stateDir := flag.String("state-dir", "", "tsnet state directory")
-	touchDevice := flag.String("touch-device", "", "touch input device path")
+	touchDevice := flag.String("touch-device", "", "touch input device path, or comma-separated paths")
 	framebuffer := flag.String("framebuffer", "/dev/fb0", "framebuffer device path")
 	logLevel := flag.String("log-level", "info", "log level")
+	showIdentity := flag.Bool("print-identity", false, "print the device identity for pairing, creating it if needed, and exit")
 	flag.Parse()
 
+	if *showIdentity {
+		path := filepath.Join(filepath.Dir(*cfgPath), "device.json")
+		identity, err := gateway.LoadOrCreateIdentity(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load device identity: %v\n", err)
+			os.Exit(1)
+		}
+		printIdentity(os.Stdout, path, identity)
+		return
+	}
+
 	cfg, err := loadConfig(*cfgPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
@@ -64,6 +133,7 @@ func main() {
 
 	applyOverrides(&cfg, *gatewayHost, *gatewayPort, *gatewayTLS, *gatewayPath, *name, *stateDir, *touchDevice, *framebuffer, *logLevel)
 	setupLogger(cfg.LogLevel)
+	log.Info().Str("version", version).Str("commit", commit).Msg("starting openclaw-node-kobo")
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
@@ -71,15 +141,6 @@ func main() {
 	if cfg.StateDir == "" {
 		cfg.StateDir = filepath.Join(filepath.Dir(*cfgPath), "tsnet-state")
 	}
-	if cfg.GatewayPath == "" {
-		cfg.GatewayPath = "/ws"
-	}
-	if cfg.GatewayPort == 0 {
-		cfg.GatewayPort = 443
-		if !cfg.GatewayTLS {
-			cfg.GatewayPort = 80
-		}
-	}
 	if cfg.Framebuffer == "" {
 		cfg.Framebuffer = "/dev/fb0"
 	}
@@ -87,17 +148,50 @@ func main() {
 		fmt.Fprintln(os.Stderr, "config requires name")
 		os.Exit(1)
 	}
-	if cfg.Gateway == "" {
-		fmt.Fprintln(os.Stderr, "config requires gateway")
+	if cfg.Gateway == "" && cfg.DiscoveryURL == "" {
+		fmt.Fprintln(os.Stderr, "config requires gateway or discoveryUrl")
+		os.Exit(1)
+	}
+	if err := preflight(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
+	crashPath := filepath.Join(cfg.StateDir, crashCountFile)
+	crashes, err := recordStart(crashPath)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to update crash counter")
+	}
+	safeModeCrashes := defaultSafeModeCrashes
+	if cfg.SafeModeCrashes != nil {
+		safeModeCrashes = *cfg.SafeModeCrashes
+	}
+	safeMode := safeModeFor(crashes, safeModeCrashes)
+	if safeMode {
+		log.Warn().Int("crashes", crashes).Msg("crashed repeatedly, starting in safe mode")
+		// Also drops the touch cap from the registration.
+		cfg.TouchDevice = nil
+	}
+	resetCrashes := func() {
+		if err := clearCrashes(crashPath); err != nil {
+			log.Warn().Err(err).Msg("failed to reset crash counter")
+		}
+	}
+	// Deferred first, so only a run that finishes its shutdown counts as clean.
+	defer resetCrashes()
+	stableRun := time.AfterFunc(crashStableAfter, resetCrashes)
+	defer stableRun.Stop()
+
 	identityPath := filepath.Join(filepath.Dir(*cfgPath), "device.json")
-	identity, err := gateway.LoadOrCreateIdentity(identityPath)
+	identity, persistent, err := loadIdentity(identityPath)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to load device identity")
 	}
 	deviceTokenPath := filepath.Join(filepath.Dir(*cfgPath), "device-token.json")
+	if !persistent {
+		// A saved token belongs to whatever identity was saved alongside it.
+		deviceTokenPath = ""
+	}
 
 	tail := tailnet.New(tailnet.Config{
 		Hostname: cfg.Name,
@@ -108,7 +202,33 @@ func main() {
 		_ = tail.Close()
 	}()
 
-	fb, err := eink.Open(cfg.Framebuffer)
+	if cfg.Gateway == "" {
+		// Discovery goes over the tailnet, like the gateway connection.
+		httpClient := &http.Client{Transport: &http.Transport{DialContext: tail.DialContext}, Timeout: discoveryTimeout}
+		found, err := waitForGateway(ctx, httpClient, cfg.DiscoveryURL)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("gateway discovery did not complete")
+		}
+		log.Info().Str("gateway", found.Gateway).Msg("discovered gateway")
+		applyDiscovered(&cfg, found)
+	}
+	if cfg.GatewayPath == "" {
+		cfg.GatewayPath = "/ws"
+	}
+	if cfg.GatewayPort == 0 {
+		cfg.GatewayPort = 443
+		if !cfg.GatewayTLS {
+			cfg.GatewayPort = 80
+		}
+	}
+
+	fb, err := openFramebuffer(ctx, cfg, eink.Open, sleepContext)
+	if ctx.Err() != nil {
+		return
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to open framebuffer")
 	}
@@ -119,56 +239,202 @@ func main() {
 	renderer := canvas.NewRenderer(fb.Width, fb.Height)
 
 	wsURL := gatewayURL(cfg.GatewayTLS, cfg.Gateway, cfg.GatewayPort, cfg.GatewayPath)
+	var alternateURLs []string
+	for _, host := range cfg.AlternateGateways {
+		alternateURLs = append(alternateURLs, gatewayURL(cfg.GatewayTLS, host, cfg.GatewayPort, cfg.GatewayPath))
+	}
 	var handler *canvas.Handler
+	// resuming is set while OnResume brings the node back up, through its
+	// full refresh.
+	var resuming atomic.Bool
 	powerManager := newPowerManager(cfg, *cfgPath, log.Logger)
+	policyPath := filepath.Join(filepath.Dir(*cfgPath), "power-policy.json")
+	brandingPath := filepath.Join(filepath.Dir(*cfgPath), "branding.json")
+	theme, err := loadTheme(cfg.Theme)
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid theme config, using defaults")
+	}
+	if policy, err := power.LoadPolicy(policyPath); err != nil {
+		log.Warn().Err(err).Msg("ignoring saved power policy")
+	} else if err := powerManager.ApplyPolicy(policy); err != nil {
+		log.Warn().Err(err).Msg("ignoring invalid saved power policy")
+	}
 	var client *gateway.Client
-	registration := buildRegistration(cfg.Name, identity)
+	registration := buildRegistration(cfg.Name, cfg.InstanceID, identity)
+	registration.Caps = nodeCaps(cfg)
+	versionFile := cfg.VersionFile
+	if versionFile == "" {
+		versionFile = defaultKoboVersionPath
+	}
+	applyModelIdentifier(&registration, versionFile)
+	status := &nodeStatus{
+		power:         powerManager,
+		model:         registration.Client.ModelIdentifier,
+		started:       time.Now(),
+		powerSupplies: defaultPowerSupplies,
+		safeMode:      safeMode,
+	}
 	client = gateway.New(gateway.Config{
-		URL:             wsURL,
-		Header:          http.Header{"User-Agent": {userAgent(cfg)}},
-		Dialer:          tail.DialContext,
-		Logger:          log.Logger,
-		Register:        registration,
-		AuthToken:       *gatewayToken,
-		AuthPassword:    *gatewayPassword,
-		Identity:        identity,
-		DeviceTokenPath: deviceTokenPath,
+		URL:               wsURL,
+		AlternateURLs:     alternateURLs,
+		Header:            http.Header{"User-Agent": {buildUserAgent(cfg, identity)}},
+		Dialer:            tail.DialContext,
+		Logger:            log.Logger,
+		Register:          registration,
+		AuthToken:         *gatewayToken,
+		AuthPassword:      *gatewayPassword,
+		Identity:          identity,
+		DeviceTokenPath:   deviceTokenPath,
+		ScopeCommands:     cfg.ScopeCommands,
+		OmitDeviceInfo:    cfg.OmitDeviceInfo,
+		TokenClearReasons: cfg.TokenClearReasons,
+		HandshakeTimeout:  time.Duration(cfg.HandshakeTimeoutSec) * time.Second,
+		ConnectTimeout:    time.Duration(cfg.ConnectTimeoutSec) * time.Second,
+		CloseTimeout:      time.Duration(cfg.CloseTimeoutMs) * time.Millisecond,
+		MinPingInterval:   time.Duration(cfg.MinPingIntervalSec) * time.Second,
+		MaxPingInterval:   time.Duration(cfg.MaxPingIntervalSec) * time.Second,
+		KeepaliveEvent:    cfg.KeepaliveEvent,
+		InvokeBatchWindow: time.Duration(cfg.InvokeBatchMs) * time.Millisecond,
+		MaxResultBytes:    cfg.MaxResultBytes,
+		HeartbeatInterval: heartbeatInterval(cfg),
+		Heartbeat: func() interface{} {
+			return status.payload()
+		},
+		OnTokenCleared: func(reason string) {
+			log.Warn().Str("reason", reason).Msg("device token cleared, re-pairing required")
+		},
+		OnRegistered: func(ctx context.Context) error {
+			handler.MarkReady()
+			// Waking refreshes the screen itself once the panel settles.
+			if resuming.Load() {
+				return nil
+			}
+			refreshed, err := handler.RefreshIfPartial()
+			if refreshed {
+				log.Debug().Msg("cleaned partial updates after reconnecting")
+			}
+			return err
+		},
+		OnConfig: func(ctx context.Context, payload json.RawMessage) error {
+			return applyPowerPolicy(powerManager, payload, policyPath)
+		},
+		OnTheme: func(ctx context.Context, payload json.RawMessage) error {
+			if safeMode {
+				return errors.New("branding is off in safe mode")
+			}
+			return applyBranding(handler, theme, payload, brandingPath)
+		},
 		OnInvoke: func(ctx context.Context, req gateway.InvokeRequestParams) (interface{}, error) {
-			if handler == nil {
-				return nil, errors.New("handler not ready")
+			if req.Command == statusCommand {
+				return status.payload(), nil
 			}
-			return handler.HandleInvokeRequest(ctx, canvas.InvokeRequest{Command: req.Command, Args: req.Args})
+			return handler.HandleInvokeRequest(ctx, canvas.InvokeRequest{Command: req.Command, Args: req.Args, Params: req.Params, Progress: req.Progress, TraceID: req.TraceID})
 		},
 	})
 	handler = canvas.NewHandler(fb, renderer, client, log.Logger)
+	defer handler.Close()
+	status.handler, status.client = handler, client
+	handler.HoldUntilReady()
 	handler.SetIdleResetter(powerManager.ResetIdle)
 	handler.SetCommandProcessing(powerManager.SetCommandProcessing)
+	handler.SetRendering(powerManager.SetRendering)
+	handler.SetActionEvent(cfg.ActionEvent)
+	handler.SetActionFailureNotice(time.Duration(cfg.ActionFailNoticeMs) * time.Millisecond)
+	handler.SetRenderBudget(time.Duration(cfg.RenderBudgetMs) * time.Millisecond)
+	handler.SetPresentInterval(time.Duration(cfg.MinPresentMs) * time.Millisecond)
+	if cfg.SnapshotMaxBytes != nil {
+		handler.SetSnapshotLimit(*cfg.SnapshotMaxBytes)
+	}
+	handler.SetDeghostThreshold(cfg.DeghostThreshold)
+	handler.SetFlashFullRefresh(cfg.FlashFullRefresh)
+	handler.SetDither(cfg.Dither, cfg.DitherLevels)
+	handler.SetKeyRepeat(time.Duration(cfg.KeyRepeatDelayMs)*time.Millisecond, time.Duration(cfg.KeyRepeatIntervalMs)*time.Millisecond)
+	handler.SetRenderWatchdog(time.Duration(cfg.RenderWatchdogMs)*time.Millisecond, func() {
+		if !cfg.ReopenOnRenderStall {
+			return
+		}
+		reopened, err := eink.Open(cfg.Framebuffer)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to reopen framebuffer")
+			return
+		}
+		handler.ReplaceFramebuffer(reopened)
+	})
+	handler.SetTheme(theme)
+	palette := canvas.NewPalette(cfg.Palette)
+	handler.SetPalette(palette)
+	if !safeMode {
+		// Skipped in safe mode, in case a bad font or logo is the crash.
+		loadFonts(cfg.Fonts, filepath.Dir(*cfgPath))
+		if err := loadBranding(handler, theme, brandingPath); err != nil {
+			log.Warn().Err(err).Msg("ignoring saved branding")
+		}
+	}
+	handler.SetActionContext(actionContext(cfg, identity))
+	sleepScreen, err := loadSleepScreen(cfg.SleepScreen, cfg.SleepImage, palette, filepath.Dir(*cfgPath))
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid sleep screen config, ignoring")
+	}
+	exitScreen, err := loadSleepScreen(cfg.ExitScreen, cfg.ExitImage, palette, filepath.Dir(*cfgPath))
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid exit screen config, ignoring")
+	}
+	if cfg.ClearOnExit || exitScreen != nil {
+		// Runs before the framebuffer is closed, since defers run in reverse.
+		defer func() {
+			if err := clearOnExit(handler, exitScreen); err != nil {
+				log.Warn().Err(err).Msg("failed to clear screen on exit")
+			}
+		}()
+	}
 
 	powerManager.OnResume = func() {
+		resuming.Store(true)
+		defer resuming.Store(false)
+		resumedAt := time.Now()
 		powerManager.SetWiFiConnecting(true)
 		defer powerManager.SetWiFiConnecting(false)
 
-		enableCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
-		if err := runScript(enableCtx, filepath.Join(filepath.Dir(*cfgPath), "enable-wifi.sh")); err != nil {
-			log.Warn().Err(err).Msg("failed to enable wifi")
+		enable := func(ctx context.Context) error {
+			return runScript(ctx, filepath.Join(filepath.Dir(*cfgPath), "enable-wifi.sh"))
 		}
-		cancel()
-		waitCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
-		defer cancel()
-		if err := waitForIP(waitCtx, wifiInterface()); err != nil {
-			log.Warn().Err(err).Msg("wifi did not acquire IP")
+		waitIP := func(ctx context.Context) error {
+			return waitForIP(ctx, wifiInterface())
+		}
+		if err := bringUpWiFi(ctx, enable, waitIP, wifiUpAttempts, wifiRetryBackoff); err != nil {
+			log.Warn().Err(err).Msg("wifi did not come up")
+		} else {
+			powerManager.RecordTimeToIP(time.Since(resumedAt))
 		}
 		tailCtx, cancelTail := context.WithTimeout(ctx, 30*time.Second)
 		defer cancelTail()
 		if err := tail.Up(tailCtx); err != nil {
 			log.Warn().Err(err).Msg("tailscale did not become ready")
 		}
-		if err := handler.FullRefresh(); err != nil {
+		settle := time.Duration(cfg.ResumeSettleMs) * time.Millisecond
+		if err := settleThenRefresh(ctx, settle, time.Since(resumedAt), sleepContext, handler.FullRefresh); err != nil {
 			log.Warn().Err(err).Msg("failed full refresh after wake")
 		}
 	}
 
+	powerManager.OnIdleWarning = func(remaining time.Duration) {
+		if err := handler.ShowSleepCountdown(remaining); err != nil {
+			log.Warn().Err(err).Msg("failed to show sleep countdown")
+		}
+	}
+	powerManager.OnIdleWarningCleared = func() {
+		if err := handler.ClearSleepCountdown(); err != nil {
+			log.Warn().Err(err).Msg("failed to clear sleep countdown")
+		}
+	}
+
 	powerManager.OnSuspend = func() {
+		handler.Close()
+		if sleepScreen != nil {
+			if err := handler.ShowSleepScreen(*sleepScreen); err != nil {
+				log.Warn().Err(err).Msg("failed to show sleep screen")
+			}
+		}
 		disableCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		if err := runScript(disableCtx, filepath.Join(filepath.Dir(*cfgPath), "disable-wifi.sh")); err != nil {
 			log.Warn().Err(err).Msg("failed to disable wifi")
@@ -176,10 +442,16 @@ func main() {
 		cancel()
 	}
 
-	if cfg.TouchDevice != "" {
-		go startTouchLoop(ctx, cfg.TouchDevice, handler, powerManager, log.Logger, cancel)
-	}
-	if powerManager.SuspendEnabled && powerManager.IdleTimeout > 0 {
+	if safeMode {
+		// Without touch or suspend the node stays reachable for recovery.
+		if err := handler.ShowSleepScreen(safeModeScreen(crashes)); err != nil {
+			log.Warn().Err(err).Msg("failed to show safe mode screen")
+		}
+	} else {
+		if len(cfg.TouchDevice) > 0 {
+			go startTouchLoop(ctx, cfg.TouchDevice, cfg.PalmRejectionSize, handler, powerManager, log.Logger, cancel)
+		}
+		// Run even with idle suspend off, since a pushed policy may turn it on.
 		go func() {
 			if err := powerManager.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
 				log.Warn().Err(err).Msg("power manager exited")
@@ -187,12 +459,74 @@ func main() {
 		}()
 	}
 
+	if cfg.IdleRefreshMin > 0 {
+		go handler.RunIdleRefresh(ctx, time.Duration(cfg.IdleRefreshMin)*time.Minute)
+	}
+
+	go forceReconnectOnSignal(ctx, client)
+
 	if err := client.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
 		log.Fatal().Err(err).Msg("gateway client exited")
 	}
 }
 
+// forceReconnectOnSignal drops the gateway connection on SIGUSR2 so an
+// operator can force an immediate reconnect while debugging connectivity.
+func forceReconnectOnSignal(ctx context.Context, client *gateway.Client) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if client.ForceReconnect() {
+				log.Info().Msg("forcing gateway reconnect")
+			} else {
+				log.Info().Msg("no gateway connection to drop")
+			}
+		}
+	}
+}
+
+// configStdin is the --config value that reads the config from stdin, for
+// init systems that cannot write a config file. Relative paths in it then
+// resolve against the working directory.
+const configStdin = "-"
+
+// loadIdentity loads or creates the device identity. If the config dir is
+// read-only, as on some locked-down Kobos, it falls back to an ephemeral
+// identity so the node still runs; persistent reports which one it got.
+func loadIdentity(path string) (*gateway.DeviceIdentity, bool, error) {
+	identity, err := gateway.LoadOrCreateIdentity(path)
+	if err == nil {
+		return identity, true, nil
+	}
+	if !errors.Is(err, os.ErrPermission) && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.EROFS) {
+		return nil, false, err
+	}
+	log.Warn().Err(err).Str("path", path).Msg("device identity cannot be saved; using an ephemeral identity, the gateway will see a new device after every restart and may require pairing again")
+	identity, err = gateway.NewEphemeralIdentity()
+	if err != nil {
+		return nil, false, err
+	}
+	return identity, false, nil
+}
+
+// printIdentity writes what an operator needs to approve the device on the
+// gateway, one "key: value" per line.
+func printIdentity(w io.Writer, path string, identity *gateway.DeviceIdentity) {
+	fmt.Fprintf(w, "identityFile: %s\n", path)
+	fmt.Fprintf(w, "deviceId: %s\n", identity.DeviceID)
+	fmt.Fprintf(w, "publicKey: %s\n", identity.PublicKeyRawBase64Url())
+	fmt.Fprintf(w, "fingerprint: %s\n", identity.Fingerprint())
+}
+
 func loadConfig(path string) (FileConfig, error) {
+	if path == configStdin {
+		return readConfig(os.Stdin)
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -200,13 +534,62 @@ func loadConfig(path string) (FileConfig, error) {
 		}
 		return FileConfig{}, err
 	}
+	return readConfig(bytes.NewReader(data))
+}
+
+// readConfig parses a config from r. Empty input gives the defaults, like a
+// missing config file.
+func readConfig(r io.Reader) (FileConfig, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return FileConfig{}, err
+	}
 	var cfg FileConfig
+	if len(bytes.TrimSpace(data)) == 0 {
+		return cfg, nil
+	}
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return FileConfig{}, err
 	}
 	return cfg, nil
 }
 
+// preflight opens the framebuffer and touch device the way the node will
+// use them, so permission problems fail at startup with a hint instead of
+// deep inside the render or input goroutines.
+func preflight(cfg FileConfig) error {
+	var errs []error
+	check := func(kind, path string, flag int) error {
+		f, err := os.OpenFile(path, flag, 0)
+		if err != nil {
+			return deviceError(kind, err)
+		}
+		return f.Close()
+	}
+	// A missing framebuffer may not have been created yet at boot, so it is
+	// left to openFramebuffer, which waits for it.
+	if err := check("framebuffer", cfg.Framebuffer, os.O_RDWR); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, err)
+	}
+	for _, device := range cfg.TouchDevice {
+		if err := check("touch device", device, os.O_RDONLY); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func deviceError(kind string, err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%s not found: %w (check the %s path in config)", kind, err, kind)
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%s not accessible: %w (run as root, or as a user with access to the device, e.g. in the video or input group)", kind, err)
+	default:
+		return fmt.Errorf("%s unusable: %w", kind, err)
+	}
+}
+
 func applyOverrides(cfg *FileConfig, gatewayHost string, gatewayPort int, gatewayTLS bool, gatewayPath, name, stateDir, touchDevice, framebuffer, logLevel string) {
 	if gatewayHost != "" {
 		cfg.Gateway = gatewayHost
@@ -224,7 +607,7 @@ func applyOverrides(cfg *FileConfig, gatewayHost string, gatewayPort int, gatewa
 		cfg.StateDir = stateDir
 	}
 	if touchDevice != "" {
-		cfg.TouchDevice = touchDevice
+		cfg.TouchDevice = strings.Split(touchDevice, ",")
 	}
 	if framebuffer != "" {
 		cfg.Framebuffer = framebuffer
@@ -242,16 +625,149 @@ func setupLogger(level string) {
 	}
 }
 
-func buildRegistration(name string, identity *gateway.DeviceIdentity) gateway.NodeRegistration {
+func buildRegistration(name, instanceID string, identity *gateway.DeviceIdentity) gateway.NodeRegistration {
 	registration := gateway.DefaultRegistration()
 	registration.Client.DisplayName = name
-	registration.Client.Version = version
-	if identity != nil {
+	registration.Client.Version = buildVersion()
+	if instanceID != "" {
+		registration.Client.InstanceID = instanceID
+	} else if identity != nil {
 		registration.Client.InstanceID = identity.DeviceID
 	}
 	return registration
 }
 
+// nodeCaps lists the features this device actually offers, so the gateway
+// knows, for example, whether anyone can tap what it renders.
+func nodeCaps(cfg FileConfig) []string {
+	caps := []string{"canvas", "snapshot", canvas.MsgpackCap}
+	if len(cfg.TouchDevice) > 0 {
+		caps = append(caps, "touch")
+	}
+	return caps
+}
+
+func loadTheme(raw json.RawMessage) (canvas.Theme, error) {
+	return overlayTheme(canvas.DefaultTheme(), raw)
+}
+
+// overlayTheme sets the theme fields present in raw on top of theme.
+func overlayTheme(theme canvas.Theme, raw json.RawMessage) (canvas.Theme, error) {
+	if len(raw) == 0 {
+		return theme, nil
+	}
+	base := theme
+	if theme.AccentGray != nil {
+		// Unmarshal writes through the pointer, which base shares.
+		accent := *theme.AccentGray
+		theme.AccentGray = &accent
+	}
+	if err := json.Unmarshal(raw, &theme); err != nil {
+		return base, err
+	}
+	if theme.StrokeWidth < 0 {
+		theme.StrokeWidth = 0
+	}
+	if theme.Padding < 0 {
+		theme.Padding = 0
+	}
+	return theme, nil
+}
+
+// loadSleepScreen builds the screen shown while suspended from an A2UI push
+// and/or an image path relative to baseDir, showing the branding logo when
+// there is no image. It returns nil if neither is set.
+func loadSleepScreen(raw json.RawMessage, imagePath string, palette canvas.Palette, baseDir string) (*canvas.SleepScreen, error) {
+	if len(raw) == 0 && imagePath == "" {
+		return nil, nil
+	}
+	screen := canvas.SleepScreen{Logo: imagePath == ""}
+	if len(raw) > 0 {
+		push, err := canvas.DecodeA2UIPush(raw, palette)
+		if err != nil {
+			return nil, err
+		}
+		screen.Components = push.Components
+	}
+	if imagePath != "" {
+		if !filepath.IsAbs(imagePath) {
+			imagePath = filepath.Join(baseDir, imagePath)
+		}
+		file, err := os.Open(imagePath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		img, _, err := image.Decode(file)
+		if err != nil {
+			return nil, fmt.Errorf("decode sleep image: %w", err)
+		}
+		screen.Image = img
+	}
+	return &screen, nil
+}
+
+// clearOnExit draws screen, or a blank page if nil, with a full refresh so
+// the panel does not keep showing a stale UI once the node has exited.
+func clearOnExit(handler *canvas.Handler, screen *canvas.SleepScreen) error {
+	if screen == nil {
+		screen = &canvas.SleepScreen{}
+	}
+	return handler.ShowSleepScreen(*screen)
+}
+
+func heartbeatInterval(cfg FileConfig) time.Duration {
+	if cfg.HeartbeatSec == nil {
+		return time.Minute
+	}
+	return time.Duration(*cfg.HeartbeatSec) * time.Second
+}
+
+func loadFonts(fonts map[string]string, baseDir string) {
+	for name, path := range fonts {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = canvas.RegisterFont(name, data, 0)
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("font", name).Str("path", path).Msg("failed to load font")
+		}
+	}
+}
+
+func actionContext(cfg FileConfig, identity *gateway.DeviceIdentity) map[string]interface{} {
+	values := map[string]interface{}{}
+	if identity != nil && identity.DeviceID != "" {
+		values["deviceId"] = identity.DeviceID
+	}
+	if cfg.ScreenID != "" {
+		values["screenId"] = cfg.ScreenID
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+func applyModelIdentifier(registration *gateway.NodeRegistration, versionFile string) {
+	model, err := readModelIdentifier(versionFile)
+	if err != nil {
+		log.Debug().Err(err).Str("path", versionFile).Msg("kobo model not detected")
+		return
+	}
+	registration.Client.ModelIdentifier = model
+}
+
+func buildVersion() string {
+	if commit == "" {
+		return version
+	}
+	return version + "+" + commit
+}
+
 func gatewayURL(tls bool, host string, port int, path string) string {
 	scheme := "ws"
 	if tls {
@@ -260,43 +776,150 @@ func gatewayURL(tls bool, host string, port int, path string) string {
 	return fmt.Sprintf("%s://%s:%d%s", scheme, host, port, path)
 }
 
-func userAgent(cfg FileConfig) string {
+// deviceIDSuffixLen is how much of the device id the user agent carries:
+// enough to tell nodes apart in gateway logs without the full key hash.
+const deviceIDSuffixLen = 8
+
+// buildUserAgent identifies the node to the gateway, e.g.
+// "openclaw-node-kobo/1.2.3 (kobo-glo-hd; fw 4.38.21908; device 1a2b3c4d)".
+// It is sent on every connect, including reconnects.
+func buildUserAgent(cfg FileConfig, identity *gateway.DeviceIdentity) string {
 	if cfg.HTTPUserAgent != "" {
 		return cfg.HTTPUserAgent
 	}
-	return "openclaw-node-kobo/0.1"
+	versionFile := cfg.VersionFile
+	if versionFile == "" {
+		versionFile = defaultKoboVersionPath
+	}
+	var details []string
+	if model, err := readModelIdentifier(versionFile); err == nil {
+		details = append(details, model)
+	}
+	if firmware, err := readFirmwareVersion(versionFile); err == nil {
+		details = append(details, "fw "+firmware)
+	}
+	if identity != nil && identity.DeviceID != "" {
+		id := identity.DeviceID
+		if len(id) > deviceIDSuffixLen {
+			id = id[len(id)-deviceIDSuffixLen:]
+		}
+		details = append(details, "device "+id)
+	}
+	agent := "openclaw-node-kobo/" + buildVersion()
+	if len(details) > 0 {
+		agent += " (" + strings.Join(details, "; ") + ")"
+	}
+	return agent
+}
+
+// deviceList is a single device path or a list of them in config.
+type deviceList []string
+
+func (d *deviceList) UnmarshalJSON(data []byte) error {
+	var path string
+	if json.Unmarshal(data, &path) == nil {
+		*d = nil
+		if path != "" {
+			*d = deviceList{path}
+		}
+		return nil
+	}
+	var paths []string
+	if err := json.Unmarshal(data, &paths); err != nil {
+		return fmt.Errorf("touchDevice must be a path or a list of paths: %w", err)
+	}
+	*d = paths
+	return nil
+}
+
+// inputRestartDelay is how long a failed input device waits before it is
+// reopened.
+const inputRestartDelay = 5 * time.Second
+
+// mergeInputs reads every device in paths and fans their events into one
+// touch and one power channel, so buttons on a separate device reach the
+// same loop as the touchscreen. Each device is reopened on its own,
+// restartDelay after it fails, without disturbing the others.
+func mergeInputs(ctx context.Context, paths []string, palmRejectionSize int, open func(string) (*eink.InputDevice, error), restartDelay time.Duration, logger zerolog.Logger) (<-chan eink.TouchEvent, <-chan eink.PowerEvent) {
+	touchCh := make(chan eink.TouchEvent, 16)
+	powerCh := make(chan eink.PowerEvent, 4)
+	for _, path := range paths {
+		go func() {
+			for {
+				if err := forwardInput(ctx, path, palmRejectionSize, open, touchCh, powerCh); err != nil {
+					logger.Warn().Err(err).Str("device", path).Msg("input device failed, reopening")
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(restartDelay):
+				}
+			}
+		}()
+	}
+	return touchCh, powerCh
 }
 
-func startTouchLoop(ctx context.Context, device string, handler *canvas.Handler, powerManager *power.Manager, logger zerolog.Logger, cancel context.CancelFunc) {
-	input, err := eink.OpenInputDevice(device)
+func forwardInput(ctx context.Context, path string, palmRejectionSize int, open func(string) (*eink.InputDevice, error), touchOut chan<- eink.TouchEvent, powerOut chan<- eink.PowerEvent) error {
+	input, err := open(path)
 	if err != nil {
-		logger.Warn().Err(err).Msg("failed to open touch device")
-		return
+		return err
 	}
 	defer func() {
 		_ = input.Close()
 	}()
+	input.PalmRejection = palmRejectionSize
 	touchCh, powerCh, errCh := input.ReadEvents()
+	for touchCh != nil || powerCh != nil {
+		select {
+		case <-ctx.Done():
+			return nil
+		case touch, ok := <-touchCh:
+			if !ok {
+				touchCh = nil
+				continue
+			}
+			select {
+			case touchOut <- touch:
+			case <-ctx.Done():
+				return nil
+			}
+		case powerEvent, ok := <-powerCh:
+			if !ok {
+				powerCh = nil
+				continue
+			}
+			select {
+			case powerOut <- powerEvent:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+	return errors.New("input device closed")
+}
+
+func startTouchLoop(ctx context.Context, devices []string, palmRejectionSize int, handler *canvas.Handler, powerManager *power.Manager, logger zerolog.Logger, cancel context.CancelFunc) {
+	touchCh, powerCh := mergeInputs(ctx, devices, palmRejectionSize, eink.OpenInputDevice, inputRestartDelay, logger)
 
 	var powerDownAt time.Time
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case touch, ok := <-touchCh:
-			if !ok {
-				return
-			}
+		case touch := <-touchCh:
 			if powerManager != nil {
 				powerManager.ResetIdle()
 			}
 			if touch.Down {
 				handler.HandleTouch(ctx, touch.X, touch.Y)
+			} else {
+				handler.HandleTouchRelease()
 			}
-		case powerEvent, ok := <-powerCh:
-			if !ok {
-				return
-			}
+		case powerEvent := <-powerCh:
 			if powerEvent.Pressed {
 				powerDownAt = powerEvent.At
 			} else if !powerDownAt.IsZero() {
@@ -309,35 +932,75 @@ func startTouchLoop(ctx context.Context, device string, handler *canvas.Handler,
 					if powerManager == nil {
 						continue
 					}
-					if err := powerManager.Suspend(); err != nil && !errors.Is(err, power.ErrSuspendBlocked) {
+					if err := powerManager.Suspend(ctx); err != nil && !errors.Is(err, power.ErrSuspendBlocked) && !errors.Is(err, context.Canceled) {
 						logger.Warn().Err(err).Msg("failed to suspend")
 					}
 				}
 			}
-		case err, ok := <-errCh:
-			if ok {
-				logger.Warn().Err(err).Msg("input error")
-			}
-			return
 		}
 	}
 }
 
+const (
+	defaultIdleTimeoutMin = 5
+	maxIdleTimeoutMin     = 24 * 60
+	idleTimeoutNever      = "never"
+)
+
+// idleTimeout parses idleTimeoutMin: a number of minutes, or 0 or "never"
+// to turn off idle suspend. Values over a day are clamped, reported by
+// clamped, since a node that should never sleep is better served by "never".
+func idleTimeout(raw json.RawMessage) (timeout time.Duration, clamped bool, err error) {
+	if len(raw) == 0 {
+		return defaultIdleTimeoutMin * time.Minute, false, nil
+	}
+	var never string
+	if json.Unmarshal(raw, &never) == nil {
+		if never != idleTimeoutNever {
+			return 0, false, fmt.Errorf("idleTimeoutMin must be a number of minutes or %q, got %q", idleTimeoutNever, never)
+		}
+		return 0, false, nil
+	}
+	var minutes int
+	if err := json.Unmarshal(raw, &minutes); err != nil {
+		return 0, false, fmt.Errorf("idleTimeoutMin must be a number of minutes or %q: %w", idleTimeoutNever, err)
+	}
+	if minutes < 0 {
+		return 0, false, fmt.Errorf("idleTimeoutMin must not be negative, got %d", minutes)
+	}
+	if minutes > maxIdleTimeoutMin {
+		return maxIdleTimeoutMin * time.Minute, true, nil
+	}
+	return time.Duration(minutes) * time.Minute, false, nil
+}
+
 func newPowerManager(cfg FileConfig, cfgPath string, logger zerolog.Logger) *power.Manager {
-	idleTimeoutMin := 5
-	if cfg.IdleTimeoutMin != nil {
-		idleTimeoutMin = *cfg.IdleTimeoutMin
+	timeout, clamped, err := idleTimeout(cfg.IdleTimeoutMin)
+	if err != nil {
+		logger.Warn().Err(err).Int("defaultMin", defaultIdleTimeoutMin).Msg("ignoring invalid idleTimeoutMin")
+		timeout = defaultIdleTimeoutMin * time.Minute
+	} else if clamped {
+		logger.Warn().Int("maxMin", maxIdleTimeoutMin).Msg("idleTimeoutMin too large, clamping")
 	}
 	suspendEnabled := true
 	if cfg.SuspendEnabled != nil {
 		suspendEnabled = *cfg.SuspendEnabled
 	}
 	manager := &power.Manager{
-		IdleTimeout:    time.Duration(idleTimeoutMin) * time.Minute,
+		IdleTimeout:    timeout,
 		SuspendEnabled: suspendEnabled,
+		IdleWarning:    time.Duration(cfg.SleepCountdownSec) * time.Second,
 	}
-	if idleTimeoutMin <= 0 {
-		manager.IdleTimeout = 0
+	if suspendEnabled && timeout == 0 {
+		logger.Info().Msg("idle suspend disabled; the power button still suspends")
+	}
+	if cfg.DoNotDisturb != "" {
+		window, err := power.ParseWindow(cfg.DoNotDisturb)
+		if err != nil {
+			logger.Warn().Err(err).Msg("ignoring invalid doNotDisturb window")
+		} else {
+			manager.DoNotDisturb = &window
+		}
 	}
 	if !suspendEnabled {
 		logger.Info().Msg("suspend disabled by config")
@@ -345,6 +1008,56 @@ func newPowerManager(cfg FileConfig, cfgPath string, logger zerolog.Logger) *pow
 	return manager
 }
 
+// applyPowerPolicy merges a node.config power policy into the saved one.
+func applyPowerPolicy(manager *power.Manager, payload json.RawMessage, path string) error {
+	var config struct {
+		Power *power.Policy `json:"power"`
+	}
+	if err := json.Unmarshal(payload, &config); err != nil {
+		return err
+	}
+	if config.Power == nil {
+		return nil
+	}
+	if err := config.Power.Validate(); err != nil {
+		return err
+	}
+	saved, err := power.LoadPolicy(path)
+	if err != nil {
+		saved = power.Policy{}
+	}
+	policy := saved.Merge(*config.Power)
+	if err := manager.ApplyPolicy(policy); err != nil {
+		return err
+	}
+	if err := power.SavePolicy(path, policy); err != nil {
+		return fmt.Errorf("save power policy: %w", err)
+	}
+	return nil
+}
+
+// settleThenRefresh runs refresh once settle has passed since wake, elapsed
+// ago, since a panel controller that has just woken may drop updates.
+func settleThenRefresh(ctx context.Context, settle, elapsed time.Duration, wait func(context.Context, time.Duration) error, refresh func() error) error {
+	if remaining := settle - elapsed; remaining > 0 {
+		if err := wait(ctx, remaining); err != nil {
+			return err
+		}
+	}
+	return refresh()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 func wifiInterface() string {
 	if _, err := os.Stat("/sys/class/net/wlan0"); err == nil {
 		return "wlan0"
@@ -352,6 +1065,84 @@ func wifiInterface() string {
 	return "eth0"
 }
 
+const (
+	wifiUpAttempts      = 4
+	wifiRetryBackoff    = 2 * time.Second
+	wifiRetryMaxBackoff = 30 * time.Second
+)
+
+// bringUpWiFi runs the enable hook and waits for an IP, retrying both with
+// jittered exponential backoff since WiFi on these devices is often flaky.
+func bringUpWiFi(ctx context.Context, enable, waitIP func(context.Context) error, attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			delay := backoff/2 + rand.N(backoff/2+1)
+			log.Info().Int("attempt", attempt).Dur("delay", delay).Msg("retrying wifi enable")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			backoff = min(backoff*2, wifiRetryMaxBackoff)
+		}
+		enableCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		err = enable(enableCtx)
+		cancel()
+		if err != nil {
+			log.Warn().Err(err).Int("attempt", attempt).Msg("failed to enable wifi")
+			continue
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		err = waitIP(waitCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Msg("wifi did not acquire IP")
+	}
+	return err
+}
+
+const (
+	defaultFBOpenAttempts = 10
+	defaultFBOpenRetry    = 500 * time.Millisecond
+	fbOpenMaxBackoff      = 5 * time.Second
+)
+
+// openFramebuffer opens the configured framebuffer, waiting for it to appear.
+func openFramebuffer(ctx context.Context, cfg FileConfig, open func(string) (*eink.Framebuffer, error), wait func(context.Context, time.Duration) error) (*eink.Framebuffer, error) {
+	attempts := defaultFBOpenAttempts
+	if cfg.FBOpenAttempts > 0 {
+		attempts = cfg.FBOpenAttempts
+	}
+	retry := defaultFBOpenRetry
+	if cfg.FBOpenRetryMs > 0 {
+		retry = time.Duration(cfg.FBOpenRetryMs) * time.Millisecond
+	}
+	fb, err := openWithRetry(ctx, open, cfg.Framebuffer, attempts, retry, wait)
+	if err != nil && ctx.Err() == nil {
+		return nil, deviceError("framebuffer", err)
+	}
+	return fb, err
+}
+
+// openWithRetry opens the framebuffer at path, retrying with exponential
+// backoff since at early boot the driver may not have created it yet.
+func openWithRetry(ctx context.Context, open func(string) (*eink.Framebuffer, error), path string, attempts int, backoff time.Duration, wait func(context.Context, time.Duration) error) (*eink.Framebuffer, error) {
+	for attempt := 1; ; attempt++ {
+		fb, err := open(path)
+		if err == nil || attempt >= attempts {
+			return fb, err
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Dur("delay", backoff).Msg("framebuffer not ready, retrying")
+		if err := wait(ctx, backoff); err != nil {
+			return nil, err
+		}
+		backoff = min(backoff*2, fbOpenMaxBackoff)
+	}
+}
+
 func waitForIP(ctx context.Context, ifaceName string) error {
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
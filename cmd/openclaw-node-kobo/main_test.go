@@ -1,15 +1,640 @@
 package main
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/openclaw/openclaw-node-kobo/internal/canvas"
+	"github.com/openclaw/openclaw-node-kobo/internal/eink"
 	"github.com/openclaw/openclaw-node-kobo/internal/gateway"
+	"github.com/openclaw/openclaw-node-kobo/internal/power"
+	"github.com/rs/zerolog"
 )
 
 func TestDefaultRegistration_InstanceIDSetFromIdentity(t *testing.T) {
 	identity := &gateway.DeviceIdentity{DeviceID: "device-123"}
-	reg := buildRegistration("node-name", identity)
+	reg := buildRegistration("node-name", "", identity)
 	if reg.Client.InstanceID != "device-123" {
 		t.Fatalf("expected instance id from identity, got %q", reg.Client.InstanceID)
 	}
 }
+
+func TestBuildRegistration_InstanceIDOverride(t *testing.T) {
+	identity := &gateway.DeviceIdentity{DeviceID: "device-123"}
+	reg := buildRegistration("node-name", "kitchen-kobo", identity)
+	if reg.Client.InstanceID != "kitchen-kobo" {
+		t.Fatalf("expected configured instance id, got %q", reg.Client.InstanceID)
+	}
+	reg = buildRegistration("node-name", "", identity)
+	if reg.Client.InstanceID != "device-123" {
+		t.Fatalf("expected identity fallback, got %q", reg.Client.InstanceID)
+	}
+}
+
+func TestBuildRegistration_UsesInjectedVersion(t *testing.T) {
+	prevVersion, prevCommit := version, commit
+	t.Cleanup(func() {
+		version, commit = prevVersion, prevCommit
+	})
+	version, commit = "1.2.3", "abc1234"
+	reg := buildRegistration("node-name", "", nil)
+	if reg.Client.Version != "1.2.3+abc1234" {
+		t.Fatalf("expected injected version, got %q", reg.Client.Version)
+	}
+	if reg.Client.Version == gateway.DefaultRegistration().Client.Version {
+		t.Fatalf("expected version to differ from hard-coded default")
+	}
+	commit = ""
+	if got := buildRegistration("node-name", "", nil).Client.Version; got != "1.2.3" {
+		t.Fatalf("expected bare version without commit, got %q", got)
+	}
+	missing := filepath.Join(t.TempDir(), "version")
+	if got := buildUserAgent(FileConfig{VersionFile: missing}, nil); got != "openclaw-node-kobo/1.2.3" {
+		t.Fatalf("expected versioned user agent, got %q", got)
+	}
+}
+
+func TestBuildUserAgent_IncludesDeviceInfo(t *testing.T) {
+	prevVersion, prevCommit := version, commit
+	t.Cleanup(func() {
+		version, commit = prevVersion, prevCommit
+	})
+	version, commit = "1.2.3", ""
+	path := filepath.Join(t.TempDir(), "version")
+	content := "N418170012345,4.1.15,4.38.21908,4.1.15,4.1.15,00000000-0000-0000-0000-000000000371\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write version file: %v", err)
+	}
+	identity := &gateway.DeviceIdentity{DeviceID: "0123456789abcdef"}
+
+	got := buildUserAgent(FileConfig{VersionFile: path}, identity)
+	want := "openclaw-node-kobo/1.2.3 (kobo-glo-hd; fw 4.38.21908; device 89abcdef)"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got := buildUserAgent(FileConfig{VersionFile: path, HTTPUserAgent: "custom/1"}, identity); got != "custom/1" {
+		t.Fatalf("expected configured user agent to win, got %q", got)
+	}
+}
+
+func TestActionContext_IncludesDeviceAndScreen(t *testing.T) {
+	identity := &gateway.DeviceIdentity{DeviceID: "device-123"}
+	ctx := actionContext(FileConfig{ScreenID: "kitchen"}, identity)
+	if ctx["deviceId"] != "device-123" || ctx["screenId"] != "kitchen" {
+		t.Fatalf("unexpected action context: %v", ctx)
+	}
+	if actionContext(FileConfig{}, nil) != nil {
+		t.Fatalf("expected nil context without identity or screen")
+	}
+}
+
+func TestReadConfig_FromReader(t *testing.T) {
+	cfg, err := readConfig(strings.NewReader(`{"gateway":"gw.example.ts.net","name":"kobo","gatewayPort":8443,"omitDeviceInfo":true}`))
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	if cfg.Gateway != "gw.example.ts.net" || cfg.Name != "kobo" || cfg.GatewayPort != 8443 || !cfg.OmitDeviceInfo {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if cfg, err := readConfig(strings.NewReader("\n")); err != nil || cfg.Gateway != "" {
+		t.Fatalf("expected empty input to give defaults, got %+v, %v", cfg, err)
+	}
+	if _, err := readConfig(strings.NewReader("{")); err == nil {
+		t.Fatalf("expected error for malformed config")
+	}
+}
+
+func TestPreflight_ReportsUnusableDevices(t *testing.T) {
+	dir := t.TempDir()
+	fbPath := filepath.Join(dir, "fb0")
+	if err := os.WriteFile(fbPath, nil, 0o600); err != nil {
+		t.Fatalf("write framebuffer: %v", err)
+	}
+	touchPath := filepath.Join(dir, "event1")
+	if err := os.WriteFile(touchPath, nil, 0o600); err != nil {
+		t.Fatalf("write touch device: %v", err)
+	}
+	if err := preflight(FileConfig{Framebuffer: fbPath, TouchDevice: deviceList{touchPath}}); err != nil {
+		t.Fatalf("expected accessible devices to pass, got %v", err)
+	}
+
+	// A missing framebuffer is waited for when it is opened.
+	missing := filepath.Join(dir, "missing")
+	if err := preflight(FileConfig{Framebuffer: missing, TouchDevice: deviceList{touchPath}}); err != nil {
+		t.Fatalf("expected a missing framebuffer to pass preflight, got %v", err)
+	}
+
+	// A directory cannot be opened for writing even as root.
+	err := preflight(FileConfig{Framebuffer: dir, TouchDevice: deviceList{missing}})
+	if err == nil || !strings.Contains(err.Error(), "framebuffer unusable") || !strings.Contains(err.Error(), "touch device not found") {
+		t.Fatalf("expected both devices reported, got %v", err)
+	}
+
+	// Permission errors can't be provoked when tests run as root.
+	err = deviceError("touch device", &os.PathError{Op: "open", Path: touchPath, Err: os.ErrPermission})
+	if !errors.Is(err, os.ErrPermission) || !strings.Contains(err.Error(), "run as root") {
+		t.Fatalf("expected permission hint, got %v", err)
+	}
+}
+
+func TestLoadIdentity_FallsBackWhenNotWritable(t *testing.T) {
+	dir := t.TempDir()
+	// Writing into a directory that does not exist fails the way a
+	// read-only state dir does, even for root.
+	identity, persistent, err := loadIdentity(filepath.Join(dir, "missing", "device.json"))
+	if err != nil {
+		t.Fatalf("expected fallback identity, got %v", err)
+	}
+	if persistent || identity == nil || identity.DeviceID == "" || identity.Sign("payload") == "" {
+		t.Fatalf("expected usable ephemeral identity, got %+v persistent=%v", identity, persistent)
+	}
+
+	path := filepath.Join(dir, "device.json")
+	saved, persistent, err := loadIdentity(path)
+	if err != nil || !persistent {
+		t.Fatalf("expected saved identity, got persistent=%v err=%v", persistent, err)
+	}
+	if saved.DeviceID == identity.DeviceID {
+		t.Fatalf("expected a fresh identity to be saved")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("write identity: %v", err)
+	}
+	if _, _, err := loadIdentity(path); err == nil {
+		t.Fatalf("expected corrupted identity to stay an error")
+	}
+}
+
+func TestPrintIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.json")
+	identity, err := gateway.LoadOrCreateIdentity(path)
+	if err != nil {
+		t.Fatalf("create identity: %v", err)
+	}
+	var out strings.Builder
+	printIdentity(&out, path, identity)
+	for _, want := range []string{
+		"deviceId: " + identity.DeviceID + "\n",
+		"publicKey: " + identity.PublicKeyRawBase64Url() + "\n",
+		"fingerprint: SHA256:",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+	reloaded, err := gateway.LoadOrCreateIdentity(path)
+	if err != nil || reloaded.Fingerprint() != identity.Fingerprint() {
+		t.Fatalf("expected stable fingerprint across loads, got %v", err)
+	}
+}
+
+func TestNodeCaps_TouchOnlyWithTouchDevice(t *testing.T) {
+	caps := nodeCaps(FileConfig{TouchDevice: deviceList{"/dev/input/event1"}})
+	if !slices.Contains(caps, "touch") || !slices.Contains(caps, "canvas") || !slices.Contains(caps, "snapshot") {
+		t.Fatalf("expected canvas, snapshot, and touch caps, got %v", caps)
+	}
+	caps = nodeCaps(FileConfig{})
+	if slices.Contains(caps, "touch") || !slices.Contains(caps, "canvas") {
+		t.Fatalf("expected no touch cap without a touch device, got %v", caps)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    time.Duration
+		clamped bool
+	}{
+		{raw: "", want: 5 * time.Minute},
+		{raw: "0", want: 0},
+		{raw: `"never"`, want: 0},
+		{raw: "15", want: 15 * time.Minute},
+		{raw: "100000", want: 24 * time.Hour, clamped: true},
+	}
+	for _, tc := range cases {
+		got, clamped, err := idleTimeout(json.RawMessage(tc.raw))
+		if err != nil || got != tc.want || clamped != tc.clamped {
+			t.Fatalf("idleTimeout(%q) = %v, %v, %v; want %v, %v", tc.raw, got, clamped, err, tc.want, tc.clamped)
+		}
+	}
+	for _, raw := range []string{"-1", `"soon"`, "true"} {
+		if _, _, err := idleTimeout(json.RawMessage(raw)); err == nil {
+			t.Fatalf("expected error for idleTimeoutMin %s", raw)
+		}
+	}
+}
+
+func TestApplyPowerPolicy_PersistsAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "power-policy.json")
+	manager := newPowerManager(FileConfig{}, "config.json", zerolog.Nop())
+	if err := applyPowerPolicy(manager, json.RawMessage(`{"power":{"idleTimeoutMin":20,"doNotDisturb":"22:00-06:00"}}`), path); err != nil {
+		t.Fatalf("apply policy: %v", err)
+	}
+	if err := applyPowerPolicy(manager, json.RawMessage(`{"power":{"suspendEnabled":false}}`), path); err != nil {
+		t.Fatalf("apply policy: %v", err)
+	}
+	if err := applyPowerPolicy(manager, json.RawMessage(`{"power":{"idleTimeoutMin":-5}}`), path); err == nil {
+		t.Fatalf("expected invalid policy rejected")
+	}
+	want := manager.Snapshot()
+	if want.IdleTimeoutMs != (20*time.Minute).Milliseconds() || want.SuspendEnabled || manager.DoNotDisturb == nil {
+		t.Fatalf("expected pushed policy applied, got %+v", want)
+	}
+
+	restarted := newPowerManager(FileConfig{}, "config.json", zerolog.Nop())
+	policy, err := power.LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("load policy: %v", err)
+	}
+	if err := restarted.ApplyPolicy(policy); err != nil {
+		t.Fatalf("apply saved policy: %v", err)
+	}
+	got := restarted.Snapshot()
+	if got.IdleTimeoutMs != want.IdleTimeoutMs || got.SuspendEnabled != want.SuspendEnabled || restarted.DoNotDisturb == nil {
+		t.Fatalf("expected saved policy restored, got %+v want %+v", got, want)
+	}
+}
+
+func TestLoadTheme_PartialOverride(t *testing.T) {
+	theme, err := loadTheme(json.RawMessage(`{"fillGray":200,"padding":6}`))
+	if err != nil {
+		t.Fatalf("load theme: %v", err)
+	}
+	if theme.FillGray != 200 || theme.Padding != 6 {
+		t.Fatalf("expected overrides applied, got %+v", theme)
+	}
+	defaults := canvas.DefaultTheme()
+	if theme.StrokeGray != defaults.StrokeGray || theme.TextGray != defaults.TextGray {
+		t.Fatalf("expected unspecified fields to keep defaults, got %+v", theme)
+	}
+	if _, err := loadTheme(json.RawMessage(`{"fillGray":"dark"}`)); err == nil {
+		t.Fatalf("expected invalid theme error")
+	}
+}
+
+func TestLoadSleepScreen(t *testing.T) {
+	if screen, err := loadSleepScreen(nil, "", nil, t.TempDir()); err != nil || screen != nil {
+		t.Fatalf("expected no sleep screen when unset, got %+v, %v", screen, err)
+	}
+
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, "sleep.png"))
+	if err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := png.Encode(file, image.NewGray(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatalf("encode image: %v", err)
+	}
+	_ = file.Close()
+
+	raw := json.RawMessage(`{"components":[{"type":"text","text":"Asleep"}]}`)
+	screen, err := loadSleepScreen(raw, "sleep.png", nil, dir)
+	if err != nil {
+		t.Fatalf("load sleep screen: %v", err)
+	}
+	if len(screen.Components) != 1 || screen.Components[0].Text != "Asleep" {
+		t.Fatalf("unexpected components %+v", screen.Components)
+	}
+	if screen.Image == nil || screen.Image.Bounds().Dx() != 4 || screen.Image.Bounds().Dy() != 3 {
+		t.Fatalf("expected sleep image loaded relative to config dir")
+	}
+
+	if _, err := loadSleepScreen(nil, "missing.png", nil, dir); err == nil {
+		t.Fatalf("expected error for missing image")
+	}
+}
+
+func TestClearOnExit(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(40, 30)
+	handler := canvas.NewHandler(fb, canvas.NewRenderer(40, 30), nil, zerolog.Nop())
+	args := json.RawMessage(`{"components":[{"type":"box","x":0,"y":0,"width":40,"height":30,"style":{"fillGray":0}}]}`)
+	if _, err := handler.HandleInvokeRequest(context.Background(), canvas.InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	lastRefresh := func() string {
+		t.Helper()
+		state, err := handler.HandleInvokeRequest(context.Background(), canvas.InvokeRequest{Command: "canvas.state"})
+		if err != nil {
+			t.Fatalf("state: %v", err)
+		}
+		return state.(canvas.DisplayState).LastRefresh
+	}
+	pixel := func(x, y int) uint8 {
+		t.Helper()
+		img, err := fb.ReadGray()
+		if err != nil {
+			t.Fatalf("read fb: %v", err)
+		}
+		return img.GrayAt(x, y).Y
+	}
+
+	if err := clearOnExit(handler, nil); err != nil {
+		t.Fatalf("clear on exit: %v", err)
+	}
+	if got := pixel(20, 15); got != 255 || lastRefresh() != "full" {
+		t.Fatalf("expected a white screen with a full refresh, got %d %q", got, lastRefresh())
+	}
+
+	off := image.NewGray(image.Rect(0, 0, 10, 10))
+	if err := clearOnExit(handler, &canvas.SleepScreen{Image: off}); err != nil {
+		t.Fatalf("clear on exit with image: %v", err)
+	}
+	if center, corner := pixel(20, 15), pixel(0, 0); center != 0 || corner != 255 || lastRefresh() != "full" {
+		t.Fatalf("expected centered powered-off image, got center %d corner %d", center, corner)
+	}
+}
+
+type failingReader struct{ err error }
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestMergeInputs_FansInDevicesAndReopensFailed(t *testing.T) {
+	touchR, touchW := io.Pipe()
+	buttonR, buttonW := io.Pipe()
+	var mu sync.Mutex
+	opens := map[string]int{}
+	open := func(path string) (*eink.InputDevice, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		opens[path]++
+		switch {
+		case path == "touch" && opens[path] == 1:
+			return eink.NewInputDevice(touchR), nil
+		case path == "buttons" && opens[path] == 1:
+			// The button device fails at first and must be reopened on its own.
+			return eink.NewInputDevice(io.NopCloser(failingReader{err: errors.New("device unplugged")})), nil
+		case path == "buttons" && opens[path] == 2:
+			return eink.NewInputDevice(buttonR), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	touchCh, powerCh := mergeInputs(ctx, []string{"touch", "buttons"}, 0, open, time.Millisecond, zerolog.Nop())
+
+	write := func(w io.Writer, events ...eink.InputEvent) {
+		for _, ev := range events {
+			if err := binary.Write(w, binary.LittleEndian, ev); err != nil {
+				t.Errorf("write event: %v", err)
+			}
+		}
+	}
+	go write(touchW,
+		eink.InputEvent{Type: eink.EVAbs, Code: eink.ABSX, Value: 12},
+		eink.InputEvent{Type: eink.EVAbs, Code: eink.ABSY, Value: 34},
+		eink.InputEvent{Type: eink.EVKey, Code: eink.BTNTouch, Value: 1},
+		eink.InputEvent{Type: eink.EVSyn},
+	)
+	go write(buttonW, eink.InputEvent{Type: eink.EVKey, Code: eink.KEYPower, Value: 1})
+
+	select {
+	case touch := <-touchCh:
+		if touch.X != 12 || touch.Y != 34 || !touch.Down {
+			t.Fatalf("unexpected touch %+v", touch)
+		}
+	case <-ctx.Done():
+		t.Fatalf("touch from first device not received")
+	}
+	select {
+	case powerEvent := <-powerCh:
+		if !powerEvent.Pressed {
+			t.Fatalf("expected power press, got %+v", powerEvent)
+		}
+	case <-ctx.Done():
+		t.Fatalf("power press from reopened second device not received")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if opens["touch"] != 1 || opens["buttons"] != 2 {
+		t.Fatalf("expected only the failed device reopened, got %v", opens)
+	}
+}
+
+func TestDeviceList_AcceptsPathOrList(t *testing.T) {
+	cfg, err := readConfig(strings.NewReader(`{"touchDevice":"/dev/input/event1"}`))
+	if err != nil || !slices.Equal(cfg.TouchDevice, []string{"/dev/input/event1"}) {
+		t.Fatalf("expected single device, got %v, %v", cfg.TouchDevice, err)
+	}
+	cfg, err = readConfig(strings.NewReader(`{"touchDevice":["/dev/input/event1","/dev/input/event0"]}`))
+	if err != nil || !slices.Equal(cfg.TouchDevice, []string{"/dev/input/event1", "/dev/input/event0"}) {
+		t.Fatalf("expected both devices, got %v, %v", cfg.TouchDevice, err)
+	}
+	if _, err := readConfig(strings.NewReader(`{"touchDevice":1}`)); err == nil {
+		t.Fatalf("expected error for invalid touchDevice")
+	}
+}
+
+func TestSettleThenRefresh_WaitsOutSettleDelay(t *testing.T) {
+	var events []string
+	wait := func(ctx context.Context, d time.Duration) error {
+		events = append(events, "wait "+d.String())
+		return nil
+	}
+	refresh := func() error {
+		events = append(events, "refresh")
+		return nil
+	}
+	// WiFi took 200ms of the 500ms settle delay, so only the rest is waited.
+	if err := settleThenRefresh(context.Background(), 500*time.Millisecond, 200*time.Millisecond, wait, refresh); err != nil {
+		t.Fatalf("settle: %v", err)
+	}
+	if !slices.Equal(events, []string{"wait 300ms", "refresh"}) {
+		t.Fatalf("expected refresh after the remaining settle delay, got %v", events)
+	}
+
+	events = nil
+	if err := settleThenRefresh(context.Background(), 500*time.Millisecond, time.Second, wait, refresh); err != nil || !slices.Equal(events, []string{"refresh"}) {
+		t.Fatalf("expected immediate refresh once settled, got %v, %v", events, err)
+	}
+
+	events = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := settleThenRefresh(ctx, time.Second, 0, sleepContext, refresh); !errors.Is(err, context.Canceled) || len(events) != 0 {
+		t.Fatalf("expected shutdown to skip the refresh, got %v, %v", events, err)
+	}
+}
+
+func TestBringUpWiFi_RetriesFailedEnable(t *testing.T) {
+	runs := 0
+	connected := false
+	enable := func(ctx context.Context) error {
+		runs++
+		if runs == 1 {
+			return errors.New("wpa_supplicant not ready")
+		}
+		connected = true
+		return nil
+	}
+	waitIP := func(ctx context.Context) error {
+		if !connected {
+			return context.DeadlineExceeded
+		}
+		return nil
+	}
+	if err := bringUpWiFi(context.Background(), enable, waitIP, 3, time.Millisecond); err != nil {
+		t.Fatalf("expected retry to recover, got %v", err)
+	}
+	if runs != 2 {
+		t.Fatalf("expected enable to run twice, ran %d times", runs)
+	}
+
+	runs = 0
+	failing := func(ctx context.Context) error {
+		runs++
+		return errors.New("no wifi")
+	}
+	if err := bringUpWiFi(context.Background(), failing, waitIP, 3, time.Millisecond); err == nil || runs != 3 {
+		t.Fatalf("expected failure after 3 attempts, got %v after %d", err, runs)
+	}
+}
+
+func TestOpenWithRetry_WaitsForFramebuffer(t *testing.T) {
+	opens := 0
+	open := func(path string) (*eink.Framebuffer, error) {
+		opens++
+		if opens < 3 {
+			return nil, os.ErrNotExist
+		}
+		return eink.NewFramebufferFromBuffer(4, 4), nil
+	}
+	var waits []time.Duration
+	wait := func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	fb, err := openWithRetry(context.Background(), open, "/dev/fb0", 5, 100*time.Millisecond, wait)
+	if err != nil || fb == nil {
+		t.Fatalf("expected the third attempt to open, got %v", err)
+	}
+	if opens != 3 || !slices.Equal(waits, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}) {
+		t.Fatalf("expected 3 opens with doubling backoff, got %d opens, waits %v", opens, waits)
+	}
+
+	opens = 0
+	if _, err := openWithRetry(context.Background(), open, "/dev/fb0", 2, time.Millisecond, wait); !errors.Is(err, os.ErrNotExist) || opens != 2 {
+		t.Fatalf("expected failure after 2 attempts, got %v after %d", err, opens)
+	}
+}
+
+func TestOpenFramebuffer_WaitsForMissingDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fb0")
+	cfg := FileConfig{Framebuffer: path, FBOpenAttempts: 5, FBOpenRetryMs: 10}
+	if err := preflight(cfg); err != nil {
+		t.Fatalf("expected preflight to leave a missing framebuffer to the retry, got %v", err)
+	}
+	// The driver creates the device while the node waits.
+	waits := 0
+	wait := func(ctx context.Context, d time.Duration) error {
+		waits++
+		if waits == 2 {
+			return os.WriteFile(path, nil, 0o600)
+		}
+		return nil
+	}
+	open := func(p string) (*eink.Framebuffer, error) {
+		if _, err := os.Stat(p); err != nil {
+			return nil, err
+		}
+		return eink.NewFramebufferFromBuffer(4, 4), nil
+	}
+	if fb, err := openFramebuffer(context.Background(), cfg, open, wait); err != nil || fb == nil || waits != 2 {
+		t.Fatalf("expected the framebuffer opened once it appeared, got %v after %d waits", err, waits)
+	}
+
+	cfg.Framebuffer = filepath.Join(t.TempDir(), "never")
+	if _, err := openFramebuffer(context.Background(), cfg, open, func(context.Context, time.Duration) error { return nil }); !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), "framebuffer not found") {
+		t.Fatalf("expected a framebuffer that never appears reported, got %v", err)
+	}
+}
+
+func TestApplyBranding_ChangesStylingAndRendersLogo(t *testing.T) {
+	logo := image.NewGray(image.Rect(0, 0, 6, 6))
+	var encoded strings.Builder
+	if err := png.Encode(&encoded, logo); err != nil {
+		t.Fatalf("encode logo: %v", err)
+	}
+	payload, err := json.Marshal(map[string]interface{}{"accentGray": 40, "fillGray": 200, "logo": []byte(encoded.String())})
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "branding.json")
+	splash := canvas.SleepScreen{Components: []canvas.A2UIComponent{
+		{Type: "button", X: 0, Y: 0, Width: 20, Height: 30},
+		{Type: "logo", X: 20, Y: 0, Width: 20, Height: 30},
+	}}
+	check := func(handler *canvas.Handler, fb *eink.Framebuffer) {
+		t.Helper()
+		if err := handler.ShowSleepScreen(splash); err != nil {
+			t.Fatalf("show splash: %v", err)
+		}
+		img, err := fb.ReadGray()
+		if err != nil {
+			t.Fatalf("read fb: %v", err)
+		}
+		if got := img.GrayAt(0, 15).Y; got != 40 {
+			t.Fatalf("expected button stroked with accent gray 40, got %d", got)
+		}
+		if got := img.GrayAt(10, 15).Y; got != 200 {
+			t.Fatalf("expected pushed fill gray 200, got %d", got)
+		}
+		if logoPixel, beside := img.GrayAt(30, 15).Y, img.GrayAt(22, 15).Y; logoPixel != 0 || beside != 255 {
+			t.Fatalf("expected logo centered in its component, got %d with %d beside it", logoPixel, beside)
+		}
+
+		// A configured sleep screen without an image shows the logo.
+		sleep, err := loadSleepScreen(json.RawMessage(`{"components":[{"type":"text","text":"z","x":0,"y":0,"width":5,"height":5}]}`), "", nil, t.TempDir())
+		if err != nil {
+			t.Fatalf("load sleep screen: %v", err)
+		}
+		if err := handler.ShowSleepScreen(*sleep); err != nil {
+			t.Fatalf("show sleep screen: %v", err)
+		}
+		img, err = fb.ReadGray()
+		if err != nil {
+			t.Fatalf("read fb: %v", err)
+		}
+		if logoPixel, beside := img.GrayAt(20, 15).Y, img.GrayAt(10, 15).Y; logoPixel != 0 || beside != 255 {
+			t.Fatalf("expected logo centered on the sleep screen, got %d with %d beside it", logoPixel, beside)
+		}
+	}
+
+	fb := eink.NewFramebufferFromBuffer(40, 30)
+	handler := canvas.NewHandler(fb, canvas.NewRenderer(40, 30), nil, zerolog.Nop())
+	if err := applyBranding(handler, canvas.DefaultTheme(), payload, path); err != nil {
+		t.Fatalf("apply branding: %v", err)
+	}
+	check(handler, fb)
+
+	// A restarted node applies the cached branding.
+	fb = eink.NewFramebufferFromBuffer(40, 30)
+	handler = canvas.NewHandler(fb, canvas.NewRenderer(40, 30), nil, zerolog.Nop())
+	if err := loadBranding(handler, canvas.DefaultTheme(), path); err != nil {
+		t.Fatalf("load branding: %v", err)
+	}
+	check(handler, fb)
+
+	for _, invalid := range []string{`{"font":"missing"}`, `{"logo":"bm90IGFuIGltYWdl"}`} {
+		if err := applyBranding(handler, canvas.DefaultTheme(), json.RawMessage(invalid), path); err == nil {
+			t.Fatalf("expected %s to be rejected", invalid)
+		}
+	}
+	if cached, err := os.ReadFile(path); err != nil || string(cached) != string(payload) {
+		t.Fatalf("expected rejected branding not to replace the cache")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const defaultKoboVersionPath = "/mnt/onboard/.kobo/version"
+
+// koboModels maps product codes to models, as listed in KOReader's Kobo
+// device table. Codes not listed report as kobo-<code>.
+var koboModels = map[string]string{
+	"310": "kobo-touch",
+	"320": "kobo-touch-c",
+	"330": "kobo-glo",
+	"340": "kobo-mini",
+	"350": "kobo-aura-hd",
+	"360": "kobo-aura",
+	"370": "kobo-aura-h2o",
+	"371": "kobo-glo-hd",
+	"372": "kobo-touch-2",
+	"373": "kobo-aura-one",
+	"374": "kobo-aura-h2o-2",
+	"375": "kobo-aura-2",
+	"376": "kobo-clara-hd",
+	"377": "kobo-forma",
+	"378": "kobo-aura-h2o-2-r2",
+	"379": "kobo-aura-2-r2",
+	"380": "kobo-forma-32gb",
+	"381": "kobo-aura-one-le",
+	"382": "kobo-nia",
+	"383": "kobo-sage",
+	"384": "kobo-libra-h2o",
+	"386": "kobo-clara-2e",
+	"387": "kobo-elipsa",
+	"388": "kobo-libra-2",
+	"389": "kobo-elipsa-2e",
+}
+
+// readModelIdentifier parses the Kobo version file, whose last comma-separated
+// field is a device id ending in the three digit product code.
+func readModelIdentifier(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Split(strings.TrimSpace(string(data)), ",")
+	deviceID := strings.TrimSpace(fields[len(fields)-1])
+	if len(deviceID) < 3 {
+		return "", errors.New("kobo version file missing device id")
+	}
+	code := deviceID[len(deviceID)-3:]
+	if model, ok := koboModels[code]; ok {
+		return model, nil
+	}
+	return fmt.Sprintf("kobo-%s", code), nil
+}
+
+// readFirmwareVersion returns the firmware version, the third field of the
+// Kobo version file.
+func readFirmwareVersion(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Split(strings.TrimSpace(string(data)), ",")
+	if len(fields) < 3 || strings.TrimSpace(fields[2]) == "" {
+		return "", errors.New("kobo version file missing firmware version")
+	}
+	return strings.TrimSpace(fields[2]), nil
+}
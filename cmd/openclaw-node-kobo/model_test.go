@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadModelIdentifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "version")
+	content := "N418170012345,4.1.15,4.38.21908,4.1.15,4.1.15,00000000-0000-0000-0000-000000000371\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write version file: %v", err)
+	}
+	model, err := readModelIdentifier(path)
+	if err != nil {
+		t.Fatalf("read model: %v", err)
+	}
+	if model != "kobo-glo-hd" {
+		t.Fatalf("expected kobo-glo-hd, got %q", model)
+	}
+}
+
+func TestReadModelIdentifier_LibraModels(t *testing.T) {
+	for code, want := range map[string]string{"381": "kobo-aura-one-le", "384": "kobo-libra-h2o", "388": "kobo-libra-2"} {
+		path := filepath.Join(t.TempDir(), "version")
+		if err := os.WriteFile(path, []byte("serial,1,2,3,4,00000000-0000-0000-0000-000000000"+code), 0o644); err != nil {
+			t.Fatalf("write version file: %v", err)
+		}
+		if model, err := readModelIdentifier(path); err != nil || model != want {
+			t.Fatalf("code %s: expected %q, got %q (%v)", code, want, model, err)
+		}
+	}
+}
+
+func TestReadModelIdentifier_UnknownCode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "version")
+	if err := os.WriteFile(path, []byte("serial,1,2,3,4,00000000-0000-0000-0000-000000000999"), 0o644); err != nil {
+		t.Fatalf("write version file: %v", err)
+	}
+	model, err := readModelIdentifier(path)
+	if err != nil {
+		t.Fatalf("read model: %v", err)
+	}
+	if model != "kobo-999" {
+		t.Fatalf("expected kobo-999, got %q", model)
+	}
+}
+
+func TestBuildRegistration_SetsModelIdentifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "version")
+	if err := os.WriteFile(path, []byte("serial,1,2,3,4,00000000-0000-0000-0000-000000000371"), 0o644); err != nil {
+		t.Fatalf("write version file: %v", err)
+	}
+	reg := buildRegistration("node-name", "", nil)
+	applyModelIdentifier(&reg, path)
+	if reg.Client.ModelIdentifier != "kobo-glo-hd" {
+		t.Fatalf("expected model identifier set, got %q", reg.Client.ModelIdentifier)
+	}
+	reg = buildRegistration("node-name", "", nil)
+	applyModelIdentifier(&reg, filepath.Join(t.TempDir(), "missing"))
+	if reg.Client.ModelIdentifier != "" {
+		t.Fatalf("expected no model identifier when file missing")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openclaw/openclaw-node-kobo/internal/canvas"
+	"github.com/openclaw/openclaw-node-kobo/internal/gateway"
+	"github.com/openclaw/openclaw-node-kobo/internal/power"
+)
+
+const (
+	statusCommand        = "status.get"
+	defaultPowerSupplies = "/sys/class/power_supply"
+)
+
+// nodeStatus gathers the node's state for heartbeats and status.get, so the
+// gateway gets the same picture whether it waits or asks.
+type nodeStatus struct {
+	power         *power.Manager
+	handler       *canvas.Handler
+	client        *gateway.Client
+	model         string
+	started       time.Time
+	powerSupplies string
+	safeMode      bool
+}
+
+type connectionStatus struct {
+	Connected     bool   `json:"connected"`
+	NodeID        string `json:"nodeId,omitempty"`
+	UnknownFrames uint64 `json:"unknownFrames,omitempty"`
+}
+
+type batteryStatus struct {
+	Percent int    `json:"percent"`
+	Status  string `json:"status,omitempty"`
+}
+
+func (s *nodeStatus) payload() map[string]interface{} {
+	payload := map[string]interface{}{
+		"version":  buildVersion(),
+		"uptimeMs": time.Since(s.started).Milliseconds(),
+		"power":    s.power.Snapshot(),
+	}
+	if s.model != "" {
+		payload["model"] = s.model
+	}
+	if s.safeMode {
+		payload["safeMode"] = true
+	}
+	if s.handler != nil {
+		payload["render"] = s.handler.RenderHealth()
+		payload["display"] = s.handler.State()
+	}
+	if s.client != nil {
+		payload["connection"] = connectionStatus{Connected: s.client.Connected(), NodeID: s.client.NodeID(), UnknownFrames: s.client.UnknownFrames()}
+	}
+	if battery, ok := readBattery(s.powerSupplies); ok {
+		payload["battery"] = battery
+	}
+	return payload
+}
+
+// readBattery reads the first supply of type Battery under dir, as the
+// kernel exposes it in sysfs.
+func readBattery(dir string) (batteryStatus, bool) {
+	supplies, err := os.ReadDir(dir)
+	if err != nil {
+		return batteryStatus{}, false
+	}
+	read := func(supply, name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, supply, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+	for _, supply := range supplies {
+		if read(supply.Name(), "type") != "Battery" {
+			continue
+		}
+		percent, err := strconv.Atoi(read(supply.Name(), "capacity"))
+		if err != nil {
+			continue
+		}
+		return batteryStatus{Percent: percent, Status: read(supply.Name(), "status")}, true
+	}
+	return batteryStatus{}, false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openclaw/openclaw-node-kobo/internal/canvas"
+	"github.com/openclaw/openclaw-node-kobo/internal/eink"
+	"github.com/openclaw/openclaw-node-kobo/internal/gateway"
+	"github.com/openclaw/openclaw-node-kobo/internal/power"
+	"github.com/rs/zerolog"
+)
+
+func writeSupply(t *testing.T, dir, name string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for file, value := range files {
+		if err := os.WriteFile(filepath.Join(dir, name, file), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", file, err)
+		}
+	}
+}
+
+func TestNodeStatusPayload(t *testing.T) {
+	supplies := t.TempDir()
+	writeSupply(t, supplies, "usb", map[string]string{"type": "USB", "online": "1"})
+	writeSupply(t, supplies, "mc13892_bat", map[string]string{"type": "Battery", "capacity": "87", "status": "Discharging"})
+
+	handler := canvas.NewHandler(eink.NewFramebufferFromBuffer(40, 30), canvas.NewRenderer(40, 30), nil, zerolog.Nop())
+	status := &nodeStatus{
+		power:         &power.Manager{IdleTimeout: 5 * time.Minute, SuspendEnabled: true},
+		handler:       handler,
+		client:        gateway.New(gateway.Config{Logger: zerolog.Nop()}),
+		model:         "kobo-clara-hd",
+		started:       time.Now().Add(-time.Minute),
+		powerSupplies: supplies,
+	}
+	encoded, err := json.Marshal(status.payload())
+	if err != nil {
+		t.Fatalf("marshal status: %v", err)
+	}
+	var payload struct {
+		Version    string               `json:"version"`
+		UptimeMs   int64                `json:"uptimeMs"`
+		Model      string               `json:"model"`
+		Power      power.Snapshot       `json:"power"`
+		Render     *canvas.RenderHealth `json:"render"`
+		Display    canvas.DisplayState  `json:"display"`
+		Connection *connectionStatus    `json:"connection"`
+		Battery    *batteryStatus       `json:"battery"`
+	}
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		t.Fatalf("unmarshal status: %v", err)
+	}
+	if payload.Version == "" || payload.UptimeMs < time.Minute.Milliseconds() || payload.Model != "kobo-clara-hd" {
+		t.Fatalf("unexpected identity fields %s", encoded)
+	}
+	if !payload.Power.SuspendEnabled || payload.Render == nil || payload.Display.Width != 40 {
+		t.Fatalf("expected power, render, and display state, got %s", encoded)
+	}
+	if payload.Connection == nil || payload.Connection.Connected {
+		t.Fatalf("expected disconnected connection status, got %s", encoded)
+	}
+	if payload.Battery == nil || payload.Battery.Percent != 87 || payload.Battery.Status != "Discharging" {
+		t.Fatalf("expected battery from sysfs, got %s", encoded)
+	}
+
+	status.powerSupplies = filepath.Join(supplies, "missing")
+	if _, ok := status.payload()["battery"]; ok {
+		t.Fatalf("expected battery omitted when not reported")
+	}
+}
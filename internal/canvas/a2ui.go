@@ -3,20 +3,47 @@ package canvas
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"image"
+	"slices"
 	"strings"
 	"sync"
 )
 
+// Action types a component's action may have. They are forwarded as is in
+// action events; key is for on-screen keyboard keys.
+const (
+	ActionTap       = "tap"
+	ActionDoubleTap = "doubletap"
+	ActionLongPress = "longpress"
+	ActionSubmit    = "submit"
+	ActionKey       = "key"
+)
+
+var actionTypes = []string{ActionTap, ActionDoubleTap, ActionLongPress, ActionSubmit, ActionKey}
+
 type A2UIAction struct {
 	Type    string          `json:"type"`
 	Payload json.RawMessage `json:"payload,omitempty"`
+	// Repeat re-sends the action while the touch is held, like a keyboard
+	// key, e.g. for backspace on an on-screen keyboard.
+	Repeat bool `json:"repeat,omitempty"`
 }
 
 type A2UIStyle struct {
-	FillGray   *uint8 `json:"fillGray,omitempty"`
-	StrokeGray *uint8 `json:"strokeGray,omitempty"`
+	FillGray    *uint8 `json:"fillGray,omitempty"`
+	StrokeGray  *uint8 `json:"strokeGray,omitempty"`
+	StrokeStyle string `json:"strokeStyle,omitempty"`
+	StrokeWidth *int   `json:"strokeWidth,omitempty"`
+	TextGray    *uint8 `json:"textGray,omitempty"`
+	// RowGray and AltRowGray shade the even and odd rows of a striped list.
+	RowGray    *uint8 `json:"rowGray,omitempty"`
+	AltRowGray *uint8 `json:"altRowGray,omitempty"`
+
+	names map[string]string // palette names by gray key, until resolved
 }
 
 type A2UIComponent struct {
@@ -28,21 +55,51 @@ type A2UIComponent struct {
 	Height   int             `json:"height,omitempty"`
 	Text     string          `json:"text,omitempty"`
 	FontSize float64         `json:"fontSize,omitempty"`
+	Font     string          `json:"font,omitempty"`
 	Align    string          `json:"align,omitempty"`
+	Dir      string          `json:"dir,omitempty"`
+	Marquee  bool            `json:"marquee,omitempty"`
+	Striped  bool            `json:"striped,omitempty"`
+	Badge    string          `json:"badge,omitempty"`
 	Padding  int             `json:"padding,omitempty"`
-	Action   *A2UIAction      `json:"action,omitempty"`
-	Style    *A2UIStyle       `json:"style,omitempty"`
+	ZIndex   int             `json:"zIndex,omitempty"`
+	Disabled bool            `json:"disabled,omitempty"`
+	Action   *A2UIAction     `json:"action,omitempty"`
+	Style    *A2UIStyle      `json:"style,omitempty"`
 	Children []A2UIComponent `json:"children,omitempty"`
+	// HitPadding grows the touch area beyond the drawn rect on every side.
+	HitPadding int `json:"hitPadding,omitempty"`
+	// Src is an image component's base64 PNG or JPEG.
+	Src string `json:"src,omitempty"`
+
+	// decoded is Src, decoded when the push is.
+	decoded image.Image
 }
 
+// Refresh hints a push can carry to pick the e-ink update used to present it.
+const (
+	RefreshFast = "fast"
+	RefreshFull = "full"
+	RefreshAuto = "auto"
+)
+
 type A2UIPush struct {
-	Components []A2UIComponent `json:"components"`
-	Replace    bool            `json:"replace,omitempty"`
+	Components  []A2UIComponent `json:"components"`
+	Replace     bool            `json:"replace,omitempty"`
+	RefreshHint string          `json:"refreshHint,omitempty"`
+	// Screen, if set, applies the push to that named screen rather than to
+	// what is displayed, unless that screen is the one being shown.
+	Screen string `json:"screen,omitempty"`
 }
 
+// A2UIState holds the displayed components plus named screens that can be
+// swapped in locally. Showing a screen copies it into the displayed
+// components, so later unnamed pushes edit the display, not the screen.
 type A2UIState struct {
 	mu         sync.Mutex
 	components []A2UIComponent
+	screens    map[string][]A2UIComponent
+	current    string
 }
 
 func NewA2UIState() *A2UIState {
@@ -52,19 +109,69 @@ func NewA2UIState() *A2UIState {
 func (s *A2UIState) Reset() {
 	s.mu.Lock()
 	s.components = nil
+	s.screens = nil
+	s.current = ""
 	s.mu.Unlock()
 }
 
 func (s *A2UIState) ApplyPush(push A2UIPush) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if push.Screen != "" {
+		screen := s.screens[push.Screen]
+		if push.Replace {
+			screen = nil
+		}
+		s.setScreenLocked(push.Screen, append(append([]A2UIComponent{}, screen...), push.Components...))
+		return
+	}
 	if push.Replace {
 		s.components = append([]A2UIComponent{}, push.Components...)
+		s.current = ""
 		return
 	}
 	s.components = append(s.components, push.Components...)
 }
 
+// SetScreen stores components under name, updating the display too if that
+// screen is being shown.
+func (s *A2UIState) SetScreen(name string, components []A2UIComponent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setScreenLocked(name, append([]A2UIComponent{}, components...))
+}
+
+func (s *A2UIState) setScreenLocked(name string, components []A2UIComponent) {
+	if s.screens == nil {
+		s.screens = make(map[string][]A2UIComponent)
+	}
+	s.screens[name] = components
+	if s.current == name {
+		s.components = append([]A2UIComponent{}, components...)
+	}
+}
+
+// ShowScreen makes the named screen the displayed components. It reports
+// false if no such screen was stored.
+func (s *A2UIState) ShowScreen(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	screen, ok := s.screens[name]
+	if !ok {
+		return false
+	}
+	s.components = append([]A2UIComponent{}, screen...)
+	s.current = name
+	return true
+}
+
+// CurrentScreen returns the name of the screen last shown, if any.
+func (s *A2UIState) CurrentScreen() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
 func (s *A2UIState) Components() []A2UIComponent {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -73,19 +180,89 @@ func (s *A2UIState) Components() []A2UIComponent {
 	return out
 }
 
-func DecodeA2UIPush(data []byte) (A2UIPush, error) {
+// DecodeA2UIPush decodes a push, resolving style palette names against
+// palette, or the default palette if it is nil.
+func DecodeA2UIPush(data []byte, palette Palette) (A2UIPush, error) {
 	var push A2UIPush
-	if err := json.Unmarshal(data, &push); err == nil && len(push.Components) > 0 {
-		return push, nil
+	if err := json.Unmarshal(data, &push); err != nil || len(push.Components) == 0 {
+		var comp A2UIComponent
+		if err := json.Unmarshal(data, &comp); err != nil || comp.Type == "" {
+			return A2UIPush{}, errors.New("invalid A2UI payload")
+		}
+		push = A2UIPush{Components: []A2UIComponent{comp}}
+	}
+	if palette == nil {
+		palette = NewPalette(nil)
+	}
+	if err := resolvePalette(push.Components, palette); err != nil {
+		return A2UIPush{}, err
+	}
+	if err := validateActions(push.Components); err != nil {
+		return A2UIPush{}, err
+	}
+	if err := decodeImages(push.Components); err != nil {
+		return A2UIPush{}, err
+	}
+	return push, nil
+}
+
+// validateActions rejects actions of unknown types, which would otherwise
+// only show up as events the agent does not understand.
+func validateActions(components []A2UIComponent) error {
+	for _, comp := range components {
+		if comp.Action != nil && !slices.Contains(actionTypes, comp.Action.Type) {
+			return fmt.Errorf("invalid A2UI action type %q on %s component (want one of %s)", comp.Action.Type, comp.Type, strings.Join(actionTypes, ", "))
+		}
+		if err := validateActions(comp.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxImageSide bounds an image component, which is kept decoded in memory
+// for as long as it is on screen.
+const maxImageSide = 2048
+
+// decodeImages decodes the src of every image component up front, so a bad
+// image fails the push instead of drawing nothing.
+func decodeImages(components []A2UIComponent) error {
+	for i := range components {
+		comp := &components[i]
+		if comp.Type == "image" {
+			img, err := decodeImageSrc(comp.Src)
+			if err != nil {
+				return fmt.Errorf("image component %q: %w", comp.ID, err)
+			}
+			comp.decoded = img
+		}
+		if err := decodeImages(comp.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeImageSrc(src string) (image.Image, error) {
+	data, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image data: %w", err)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	if config.Width > maxImageSide || config.Height > maxImageSide {
+		return nil, fmt.Errorf("image is %dx%d, limit %dx%d", config.Width, config.Height, maxImageSide, maxImageSide)
 	}
-	var comp A2UIComponent
-	if err := json.Unmarshal(data, &comp); err == nil && comp.Type != "" {
-		return A2UIPush{Components: []A2UIComponent{comp}}, nil
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
 	}
-	return A2UIPush{}, errors.New("invalid A2UI payload")
+	return img, nil
 }
 
-func DecodeA2UIJSONL(data []byte) ([]A2UIPush, error) {
+func DecodeA2UIJSONL(data []byte, palette Palette) ([]A2UIPush, error) {
 	scanner := bufio.NewScanner(bytes.NewReader(data))
 	var pushes []A2UIPush
 	for scanner.Scan() {
@@ -93,7 +270,7 @@ func DecodeA2UIJSONL(data []byte) ([]A2UIPush, error) {
 		if line == "" {
 			continue
 		}
-		push, err := DecodeA2UIPush([]byte(line))
+		push, err := DecodeA2UIPush([]byte(line), palette)
 		if err != nil {
 			return nil, err
 		}
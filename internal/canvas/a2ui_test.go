@@ -1,10 +1,16 @@
 package canvas
 
-import "testing"
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
 
 func TestDecodeA2UIPush(t *testing.T) {
 	payload := []byte(`{"components":[{"type":"text","text":"hi"}]}`)
-	push, err := DecodeA2UIPush(payload)
+	push, err := DecodeA2UIPush(payload, nil)
 	if err != nil {
 		t.Fatalf("decode push: %v", err)
 	}
@@ -13,9 +19,28 @@ func TestDecodeA2UIPush(t *testing.T) {
 	}
 }
 
+func TestDecodeA2UIPushRejectsUnknownActionType(t *testing.T) {
+	for _, payload := range []string{
+		`{"components":[{"type":"button","action":{"type":"swipe"}}]}`,
+		`{"type":"button","action":{"payload":{"id":1}}}`,
+		`{"components":[{"type":"list","children":[{"type":"button","action":{"type":"Tap"}}]}]}`,
+	} {
+		_, err := DecodeA2UIPush([]byte(payload), nil)
+		if err == nil || !strings.Contains(err.Error(), "invalid A2UI action type") {
+			t.Fatalf("expected %s rejected, got %v", payload, err)
+		}
+	}
+	for _, actionType := range actionTypes {
+		payload := `{"components":[{"type":"button","action":{"type":"` + actionType + `"}}]}`
+		if _, err := DecodeA2UIPush([]byte(payload), nil); err != nil {
+			t.Fatalf("expected %s accepted, got %v", actionType, err)
+		}
+	}
+}
+
 func TestDecodeA2UIJSONL(t *testing.T) {
 	payload := []byte("{\"type\":\"text\",\"text\":\"hi\"}\n{\"components\":[{\"type\":\"box\"}]}")
-	pushes, err := DecodeA2UIJSONL(payload)
+	pushes, err := DecodeA2UIJSONL(payload, nil)
 	if err != nil {
 		t.Fatalf("decode jsonl: %v", err)
 	}
@@ -37,3 +62,129 @@ func TestA2UIStateApply(t *testing.T) {
 		t.Fatalf("expected reset to clear")
 	}
 }
+
+func TestA2UIStateScreens(t *testing.T) {
+	state := NewA2UIState()
+	state.SetScreen("home", []A2UIComponent{{Type: "text", Text: "home"}})
+	state.ApplyPush(A2UIPush{Screen: "detail", Components: []A2UIComponent{{Type: "text", Text: "one"}}})
+	if len(state.Components()) != 0 {
+		t.Fatalf("expected screens not to touch the display until shown")
+	}
+	if state.ShowScreen("missing") {
+		t.Fatalf("expected unknown screen to fail")
+	}
+	if !state.ShowScreen("detail") || state.CurrentScreen() != "detail" {
+		t.Fatalf("expected detail shown")
+	}
+	state.ApplyPush(A2UIPush{Screen: "detail", Components: []A2UIComponent{{Type: "text", Text: "two"}}})
+	if components := state.Components(); len(components) != 2 || components[1].Text != "two" {
+		t.Fatalf("expected push to the shown screen to update the display, got %+v", components)
+	}
+	state.ApplyPush(A2UIPush{Components: []A2UIComponent{{Type: "text", Text: "scratch"}}})
+	if !state.ShowScreen("home") || state.Components()[0].Text != "home" {
+		t.Fatalf("expected home screen shown")
+	}
+	if !state.ShowScreen("detail") || len(state.Components()) != 2 {
+		t.Fatalf("expected unnamed edits not to change the stored screen")
+	}
+	state.Reset()
+	if state.ShowScreen("home") || state.CurrentScreen() != "" {
+		t.Fatalf("expected reset to clear screens")
+	}
+}
+
+func TestDecodeA2UIPushResolvesPaletteNames(t *testing.T) {
+	palette := NewPalette(map[string]uint8{PaletteSurface: 200, "warning": 60})
+	push, err := DecodeA2UIPush([]byte(`{"components":[{"type":"box","style":{"fillGray":"surface","strokeGray":"warning","textGray":30}}]}`), palette)
+	if err != nil {
+		t.Fatalf("decode push: %v", err)
+	}
+	style := push.Components[0].Style
+	if *style.FillGray != 200 || *style.StrokeGray != 60 || *style.TextGray != 30 {
+		t.Fatalf("expected palette grays 200 and 60 and numeric 30, got %d %d %d", *style.FillGray, *style.StrokeGray, *style.TextGray)
+	}
+
+	r := NewRenderer(20, 20)
+	r.Render(push.Components)
+	if got := r.Image.GrayAt(10, 10).Y; got != 200 {
+		t.Fatalf("expected rendered surface gray 200, got %d", got)
+	}
+
+	push, err = DecodeA2UIPush([]byte(`{"components":[{"type":"column","children":[{"type":"box","style":{"fillGray":"background"}}]}]}`), nil)
+	if err != nil || *push.Components[0].Children[0].Style.FillGray != 255 {
+		t.Fatalf("expected default background gray 255, got %v", err)
+	}
+	if _, err := DecodeA2UIPush([]byte(`{"components":[{"type":"box","style":{"fillGray":"neon"}}]}`), palette); err == nil || !strings.Contains(err.Error(), "neon") {
+		t.Fatalf("expected unknown palette name to be rejected, got %v", err)
+	}
+}
+
+// msgpackEncode encodes the few shapes a push needs, using the compact forms
+// a gateway encoder would pick.
+func msgpackEncode(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := []byte{0x80 | byte(len(v))}
+		for key, value := range v {
+			out = append(out, msgpackEncode(t, key)...)
+			out = append(out, msgpackEncode(t, value)...)
+		}
+		return out
+	case []interface{}:
+		out := []byte{0x90 | byte(len(v))}
+		for _, value := range v {
+			out = append(out, msgpackEncode(t, value)...)
+		}
+		return out
+	case string:
+		return append([]byte{0xa0 | byte(len(v))}, v...)
+	case bool:
+		if v {
+			return []byte{0xc3}
+		}
+		return []byte{0xc2}
+	case int:
+		if v >= -32 && v <= 127 {
+			return []byte{byte(int8(v))}
+		}
+		return binary.BigEndian.AppendUint16([]byte{0xd1}, uint16(int16(v)))
+	}
+	t.Fatalf("cannot encode %T", v)
+	return nil
+}
+
+func TestDecodeA2UIMsgpack(t *testing.T) {
+	push := map[string]interface{}{
+		"screen": "home",
+		"components": []interface{}{
+			map[string]interface{}{
+				"type": "column", "x": 10, "y": 300, "width": 400,
+				"children": []interface{}{
+					map[string]interface{}{"type": "text", "text": "hi", "id": "greeting"},
+					map[string]interface{}{"type": "button", "text": "Go", "disabled": true, "x": -5},
+				},
+			},
+		},
+	}
+	packed := msgpackEncode(t, push)
+	got, err := DecodeA2UIMsgpack(packed, nil)
+	if err != nil {
+		t.Fatalf("decode msgpack push: %v", err)
+	}
+	want, err := DecodeA2UIPush([]byte(`{"screen":"home","components":[{"type":"column","x":10,"y":300,"width":400,"children":[{"type":"text","text":"hi","id":"greeting"},{"type":"button","text":"Go","disabled":true,"x":-5}]}]}`), nil)
+	if err != nil {
+		t.Fatalf("decode JSON push: %v", err)
+	}
+	if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", want) {
+		t.Fatalf("msgpack push decoded to\n%+v\nwant\n%+v", got, want)
+	}
+
+	args := []byte(`{"msgpack":"` + base64.StdEncoding.EncodeToString(packed) + `"}`)
+	if fromArgs, err := decodePushArgs(args, nil); err != nil || fmt.Sprintf("%+v", fromArgs) != fmt.Sprintf("%+v", want) {
+		t.Fatalf("expected msgpack args to decode to the same push, got %+v, %v", fromArgs, err)
+	}
+	if _, err := DecodeA2UIMsgpack(packed[:len(packed)-1], nil); err == nil {
+		t.Fatalf("expected a truncated payload to fail")
+	}
+}
@@ -0,0 +1,139 @@
+package canvas
+
+import (
+	_ "embed"
+	"fmt"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/opentype"
+)
+
+const (
+	FontDefault = "default"
+	FontUI      = "ui"
+	FontMono    = "mono"
+)
+
+var (
+	//go:embed fonts/Go-Regular.ttf
+	goRegularTTF []byte
+	//go:embed fonts/Go-Mono.ttf
+	goMonoTTF []byte
+)
+
+// fallbackSize matches the height of the default bitmap face so text that
+// falls back to a bundled font for missing glyphs keeps roughly its size.
+const fallbackSize = 13
+
+type registeredFont struct {
+	data []byte
+	size float64
+}
+
+var registeredFonts = map[string]registeredFont{
+	FontUI:   {data: goRegularTTF, size: 18},
+	FontMono: {data: goMonoTTF, size: 13},
+}
+
+type faceKey struct {
+	name string
+	size float64
+}
+
+var (
+	faceMu    sync.Mutex
+	faceCache = map[faceKey]font.Face{}
+	parsed    = map[string]*opentype.Font{}
+)
+
+// RegisterFont parses a TrueType or OpenType font and makes it selectable
+// by name from text components, e.g. to supply glyphs for non-Latin scripts.
+// A zero size defaults to 13px.
+func RegisterFont(name string, data []byte, size float64) error {
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return fmt.Errorf("parse font %q: %w", name, err)
+	}
+	if size <= 0 {
+		size = fallbackSize
+	}
+	faceMu.Lock()
+	defer faceMu.Unlock()
+	registeredFonts[name] = registeredFont{data: data, size: size}
+	parsed[name] = f
+	for key := range faceCache {
+		if key.name == name {
+			delete(faceCache, key)
+		}
+	}
+	return nil
+}
+
+// HasFont reports whether name selects a default, bundled or registered font.
+func HasFont(name string) bool {
+	if name == "" || name == FontDefault {
+		return true
+	}
+	faceMu.Lock()
+	defer faceMu.Unlock()
+	_, ok := registeredFonts[name]
+	return ok
+}
+
+// fontFace returns the face for a registered font at the given size, falling
+// back to the 7x13 bitmap face for the default or unknown fonts. A zero size
+// uses the font's default size.
+func fontFace(name string, size float64) font.Face {
+	faceMu.Lock()
+	defer faceMu.Unlock()
+	registered, ok := registeredFonts[name]
+	if !ok {
+		return basicfont.Face7x13
+	}
+	if size <= 0 {
+		size = registered.size
+	}
+	key := faceKey{name: name, size: size}
+	if face, ok := faceCache[key]; ok {
+		return face
+	}
+	f, ok := parsed[name]
+	if !ok {
+		var err error
+		if f, err = opentype.Parse(registered.data); err != nil {
+			return basicfont.Face7x13
+		}
+		parsed[name] = f
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return basicfont.Face7x13
+	}
+	faceCache[key] = face
+	return face
+}
+
+func hasGlyphs(face font.Face, text string) bool {
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		if _, ok := face.GlyphAdvance(r); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// reverseRunes lays out right-to-left text in visual order. It does not
+// apply the full bidi algorithm, so it suits runs of a single RTL script.
+func reverseRunes(text string) string {
+	runes := []rune(text)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
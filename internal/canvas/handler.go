@@ -1,9 +1,17 @@
 package canvas
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"math/rand/v2"
 	"strings"
 	"sync"
 	"time"
@@ -11,6 +19,27 @@ import (
 	"github.com/openclaw/openclaw-node-kobo/internal/eink"
 	"github.com/openclaw/openclaw-node-kobo/internal/gateway"
 	"github.com/rs/zerolog"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	defaultActionEvent = "canvas.a2ui.action"
+	slowRenderEvent    = "canvas.render.slow"
+	stalledRenderEvent = "canvas.render.stalled"
+)
+
+const (
+	defaultMarqueeInterval = 500 * time.Millisecond
+	marqueeStep            = 8
+)
+
+// defaultSnapshotMaxBytes keeps canvas.snapshot results well inside typical
+// gateway message limits.
+const defaultSnapshotMaxBytes = 1 << 20
+
+const (
+	defaultKeyRepeatDelay    = 500 * time.Millisecond
+	defaultKeyRepeatInterval = 100 * time.Millisecond
 )
 
 type ActionSender interface {
@@ -21,23 +50,123 @@ type Handler struct {
 	fb                *eink.Framebuffer
 	renderer          *Renderer
 	state             *A2UIState
+	palette           Palette
 	logger            zerolog.Logger
 	sender            ActionSender
 	resetIdle         func()
 	commandProcessing func(bool)
+	rendering         func(bool)
+	actionEvent       string
+	actionContext     map[string]interface{}
+	actionFailNotice  time.Duration
+	noticeUntil       time.Time
 	renderMu          sync.RWMutex
+	readyMu           sync.Mutex
+	ready             chan struct{}
+	statsMu           sync.Mutex
+	lastRefresh       string
+	lastUpdate        eink.Update
+	fullRefreshes     uint64
+	partialRefreshes  int
+	ghosting          float64
+	deghostThreshold  float64
+	flashFull         bool
+	renderBudget      time.Duration
+	renderTimeout     time.Duration
+	onRenderStall     func()
+	renderStalls      int
+	lastStall         time.Time
+	degraded          bool
+	refreshFunc       func(eink.Update) error
+	pendingMu         sync.Mutex
+	pendingFB         *eink.Framebuffer
+	now               func() time.Time
+	newTicker         func(time.Duration) (<-chan time.Time, func())
+	afterFunc         func(time.Duration, func())
+	presentInterval   time.Duration
+	throttleMu        sync.Mutex
+	lastPushPresent   time.Time
+	throttled         *eink.Update
+	streamMu          sync.Mutex
+	streamHint        string
+	marqueeMu         sync.Mutex
+	marquees          map[string]*marqueeRun
+	overlay           image.Rectangle
+	overlayText       [overlayKinds]string
+	traceID           string
+	snapshotMaxBytes  int
+	encodeSnapshot    func(*image.Gray, int) (string, int, error)
+	repeatDelay       time.Duration
+	repeatInterval    time.Duration
+	repeatMu          sync.Mutex
+	keyRepeat         *keyRepeat
+	templateMu        sync.Mutex
+	templates         map[string]json.RawMessage
+}
+
+type DisplayState struct {
+	Components       int     `json:"components"`
+	Width            int     `json:"width"`
+	Height           int     `json:"height"`
+	LastRefresh      string  `json:"lastRefresh,omitempty"`
+	PartialRefreshes int     `json:"partialRefreshes"`
+	Ghosting         float64 `json:"ghosting"`
+	Screen           string  `json:"screen,omitempty"`
+}
+
+// RenderHealth reports whether presents are completing. Degraded is set when
+// a present overruns the watchdog timeout and cleared by the next one that
+// finishes.
+type RenderHealth struct {
+	Degraded    bool    `json:"degraded"`
+	Stalls      int     `json:"stalls"`
+	LastStallMs int64   `json:"lastStallMs,omitempty"`
+	Ghosting    float64 `json:"ghosting"`
+}
+
+// overlayKind is a banner shown over the canvas; each kind is shown and
+// cleared on its own.
+type overlayKind int
+
+const (
+	overlayCountdown overlayKind = iota
+	overlayActionFailure
+	overlayKinds
+)
+
+type keyRepeat struct {
+	action A2UIAction
+	cancel context.CancelFunc
+}
+
+type marqueeRun struct {
+	cancel context.CancelFunc
 }
 
 func NewHandler(fb *eink.Framebuffer, renderer *Renderer, sender ActionSender, logger zerolog.Logger) *Handler {
 	return &Handler{
-		fb:       fb,
-		renderer: renderer,
-		state:    NewA2UIState(),
-		logger:   logger,
-		sender:   sender,
+		fb:          fb,
+		renderer:    renderer,
+		state:       NewA2UIState(),
+		logger:      logger,
+		sender:      sender,
+		actionEvent: defaultActionEvent,
+		now:         time.Now,
+		newTicker:   newSystemTicker,
+		afterFunc:   func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+
+		snapshotMaxBytes: defaultSnapshotMaxBytes,
+		encodeSnapshot:   SnapshotBase64Limited,
+		repeatDelay:      defaultKeyRepeatDelay,
+		repeatInterval:   defaultKeyRepeatInterval,
 	}
 }
 
+func newSystemTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
 func (h *Handler) SetIdleResetter(reset func()) {
 	h.resetIdle = reset
 }
@@ -46,87 +175,1080 @@ func (h *Handler) SetCommandProcessing(set func(bool)) {
 	h.commandProcessing = set
 }
 
+// SetRendering sets the suspend blocker held for the duration of each
+// present, including deferred presents that run outside any command, so a
+// suspend cannot race the framebuffer write and refresh.
+func (h *Handler) SetRendering(set func(bool)) {
+	h.rendering = set
+}
+
+func (h *Handler) SetTheme(theme Theme) {
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.renderer.Theme = theme
+}
+
+// SetDither turns dithering of image components on or off, quantizing to
+// levels grays, or the default when levels is 0.
+func (h *Handler) SetDither(enabled bool, levels int) {
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.renderer.Dither = enabled
+	h.renderer.DitherLevels = levels
+}
+
+// SetLogo sets the branding image drawn by logo components, or clears it.
+func (h *Handler) SetLogo(logo image.Image) {
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.renderer.Logo = logo
+}
+
+// SetPalette sets the palette style gray names in pushes resolve against.
+func (h *Handler) SetPalette(palette Palette) {
+	h.palette = palette
+}
+
+func (h *Handler) SetActionEvent(event string) {
+	if event == "" {
+		event = defaultActionEvent
+	}
+	h.actionEvent = event
+}
+
+// SetActionFailureNotice shows a "No connection" banner for d when an action
+// event cannot be sent, so a tap made while offline does not fail silently.
+// Zero disables it.
+func (h *Handler) SetActionFailureNotice(d time.Duration) {
+	h.actionFailNotice = d
+}
+
+func (h *Handler) SetRenderBudget(budget time.Duration) {
+	h.renderBudget = budget
+}
+
+// SetPresentInterval sets the minimum time between presents caused by
+// pushes. Pushes arriving sooner only update state; the latest state is
+// presented once the interval has passed. Zero presents every push.
+func (h *Handler) SetPresentInterval(interval time.Duration) {
+	h.throttleMu.Lock()
+	defer h.throttleMu.Unlock()
+	h.presentInterval = interval
+}
+
+// SetRenderWatchdog reports a present that has not finished within timeout,
+// e.g. because a framebuffer write or refresh ioctl hung. onStall, if set,
+// runs from the watchdog and may hand a reopened framebuffer to
+// ReplaceFramebuffer. A zero timeout disables the watchdog.
+func (h *Handler) SetRenderWatchdog(timeout time.Duration, onStall func()) {
+	h.renderTimeout = timeout
+	h.onRenderStall = onStall
+}
+
+// ReplaceFramebuffer swaps in fb at the start of the next render and closes
+// the old one. A render stuck in the old framebuffer still has to return
+// before the swap can happen.
+func (h *Handler) ReplaceFramebuffer(fb *eink.Framebuffer) {
+	h.pendingMu.Lock()
+	superseded := h.pendingFB
+	h.pendingFB = fb
+	h.pendingMu.Unlock()
+	if err := superseded.Close(); err != nil {
+		h.logger.Debug().Err(err).Msg("failed to close superseded framebuffer")
+	}
+}
+
+func (h *Handler) RenderHealth() RenderHealth {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+	health := RenderHealth{Degraded: h.degraded, Stalls: h.renderStalls, Ghosting: h.ghosting}
+	if !h.lastStall.IsZero() {
+		health.LastStallMs = h.lastStall.UnixMilli()
+	}
+	return health
+}
+
+// SetSnapshotLimit caps the base64 size of canvas.snapshot results. Larger
+// snapshots are downscaled to fit, or fail with the encoded size. Zero
+// disables the cap.
+func (h *Handler) SetSnapshotLimit(maxBytes int) {
+	h.snapshotMaxBytes = maxBytes
+}
+
+// SetDeghostThreshold triggers a full GC16 refresh once the ghosting
+// estimate reaches threshold. Zero disables automatic cleaning.
+func (h *Handler) SetDeghostThreshold(threshold float64) {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+	h.deghostThreshold = threshold
+}
+
+// SetFlashFullRefresh makes every full refresh, including automatic
+// deghosting, first flash the panel to black with its own full refresh.
+// Some panels only shed stubborn ghosting with this two-phase sequence.
+func (h *Handler) SetFlashFullRefresh(flash bool) {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+	h.flashFull = flash
+}
+
+// SetKeyRepeat sets how long a repeating action must be held before it
+// repeats and how often it repeats after that. Zero values keep the defaults.
+func (h *Handler) SetKeyRepeat(delay, interval time.Duration) {
+	if delay > 0 {
+		h.repeatDelay = delay
+	}
+	if interval > 0 {
+		h.repeatInterval = interval
+	}
+}
+
+func (h *Handler) SetActionContext(values map[string]interface{}) {
+	h.actionContext = values
+}
+
+func (h *Handler) HoldUntilReady() {
+	h.readyMu.Lock()
+	defer h.readyMu.Unlock()
+	if h.ready == nil {
+		h.ready = make(chan struct{})
+	}
+}
+
+func (h *Handler) MarkReady() {
+	h.readyMu.Lock()
+	defer h.readyMu.Unlock()
+	if h.ready == nil {
+		return
+	}
+	select {
+	case <-h.ready:
+	default:
+		close(h.ready)
+	}
+}
+
+func (h *Handler) waitReady(ctx context.Context, command string) error {
+	h.readyMu.Lock()
+	ready := h.ready
+	h.readyMu.Unlock()
+	if ready == nil {
+		return nil
+	}
+	select {
+	case <-ready:
+		return nil
+	default:
+	}
+	h.loggerFor(ctx).Debug().Str("command", command).Msg("deferring invoke until ready")
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (h *Handler) HandleInvoke(ctx context.Context, req InvokeRequest) (interface{}, error) {
 	switch req.Command {
 	case "canvas.present":
-		return h.present(false)
+		opts, err := decodePresentArgs(req.Args, req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return h.presentWith(ctx, opts.update(true))
 	case "canvas.hide":
 		h.renderMu.Lock()
+		defer h.renderMu.Unlock()
+		h.syncSize()
 		h.renderer.Clear()
 		if err := h.fb.WriteGray(h.renderer.Image); err != nil {
-			h.renderMu.Unlock()
 			return nil, err
 		}
-		h.renderMu.Unlock()
-		return nil, h.fb.Refresh(eink.Update{Full: true})
+		return nil, h.refresh(eink.Update{Full: true})
 	case "canvas.navigate":
 		return nil, errors.New("canvas.navigate not supported on Kobo")
 	case "canvas.eval":
 		return nil, errors.New("canvas.eval not supported on Kobo")
 	case "canvas.snapshot":
-		h.renderMu.RLock()
-		defer h.renderMu.RUnlock()
-		return SnapshotBase64(h.renderer.Image)
+		return h.snapshot(ctx)
 	case "canvas.a2ui.push":
-		return h.handleA2UIPush(req.Args)
+		return h.handleA2UIPush(ctx, req.Args)
 	case "canvas.a2ui.pushJSONL":
-		return h.handleA2UIPushJSONL(req.Args)
+		return h.handleA2UIPushJSONL(ctx, req)
+	case "canvas.blit":
+		return h.handleBlit(req.Args)
+	case "canvas.erase":
+		return h.handleErase(req.Args)
+	case "canvas.image":
+		return h.handleImage(req.Args)
+	case "canvas.measureText":
+		return h.handleMeasureText(req.Args)
+	case "canvas.state":
+		return h.displayState(), nil
+	case "canvas.marquee.start":
+		return h.handleMarqueeStart(req.Args)
+	case "canvas.marquee.stop":
+		return h.handleMarqueeStop(req.Args)
+	case "canvas.screen.show":
+		return h.handleScreenShow(ctx, req.Args)
+	case "canvas.template.register":
+		return h.handleTemplateRegister(req.Args)
+	case "canvas.template.render":
+		return h.handleTemplateRender(ctx, req.Args)
 	case "canvas.a2ui.reset":
 		h.state.Reset()
 		h.renderMu.Lock()
+		defer h.renderMu.Unlock()
+		h.syncSize()
 		h.renderer.Clear()
 		if err := h.fb.WriteGray(h.renderer.Image); err != nil {
-			h.renderMu.Unlock()
 			return nil, err
 		}
-		h.renderMu.Unlock()
-		return nil, h.fb.Refresh(eink.Update{Full: true})
+		return nil, h.refresh(eink.Update{Full: true})
 	default:
 		return nil, errors.New("unknown canvas command")
 	}
 }
 
 type InvokeRequest struct {
-	Command string
-	Args    json.RawMessage
+	Command  string
+	Args     json.RawMessage
+	Params   json.RawMessage
+	Progress gateway.ProgressReporter
+	TraceID  string
 }
 
-func (h *Handler) handleA2UIPush(args json.RawMessage) (interface{}, error) {
-	push, err := DecodeA2UIPush(args)
+type regionArgs struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type presentArgs struct {
+	Region   *regionArgs `json:"region,omitempty"`
+	Waveform string      `json:"waveform,omitempty"`
+}
+
+var waveformModes = map[string]int{
+	"init": eink.WaveformModeInit,
+	"du":   eink.WaveformModeDU,
+	"gc16": eink.WaveformModeGC16,
+	"gc4":  eink.WaveformModeGC4,
+	"a2":   eink.WaveformModeA2,
+	"auto": eink.WaveformModeAuto,
+}
+
+// decodePresentArgs reads options from the top-level params, overridden by
+// args. Params carry the rest of the invoke too, so only args must decode.
+func decodePresentArgs(args, params json.RawMessage) (presentArgs, error) {
+	var out presentArgs
+	if len(params) > 0 {
+		_ = json.Unmarshal(params, &out)
+	}
+	if len(args) > 0 {
+		var next presentArgs
+		if err := json.Unmarshal(args, &next); err != nil {
+			return presentArgs{}, err
+		}
+		if next.Region != nil {
+			out.Region = next.Region
+		}
+		if next.Waveform != "" {
+			out.Waveform = next.Waveform
+		}
+	}
+	if out.Region != nil && (out.Region.Width <= 0 || out.Region.Height <= 0) {
+		return presentArgs{}, errors.New("present region must have a positive width and height")
+	}
+	if out.Waveform != "" {
+		if _, ok := waveformModes[strings.ToLower(out.Waveform)]; !ok {
+			return presentArgs{}, fmt.Errorf("unknown waveform %q", out.Waveform)
+		}
+	}
+	return out, nil
+}
+
+func (a presentArgs) update(full bool) eink.Update {
+	update := eink.Update{Full: full}
+	if a.Region != nil {
+		update.Region = image.Rect(a.Region.X, a.Region.Y, a.Region.X+a.Region.Width, a.Region.Y+a.Region.Height)
+	}
+	if a.Waveform != "" {
+		update.Waveform = waveformModes[strings.ToLower(a.Waveform)]
+	}
+	return update
+}
+
+func (h *Handler) handleA2UIPush(ctx context.Context, args json.RawMessage) (interface{}, error) {
+	push, err := decodePushArgs(args, h.palette)
+	if err != nil {
+		return nil, err
+	}
+	return h.applyPush(ctx, push)
+}
+
+func (h *Handler) applyPush(ctx context.Context, push A2UIPush) (interface{}, error) {
+	update, err := refreshHintUpdate(push.RefreshHint)
 	if err != nil {
 		return nil, err
 	}
 	h.state.ApplyPush(push)
-	return h.present(true)
+	if push.Screen != "" && push.Screen != h.state.CurrentScreen() {
+		return nil, nil
+	}
+	return h.throttledPresent(ctx, update)
+}
+
+// throttledPresent presents now unless a push was presented less than the
+// present interval ago, in which case it schedules one present at the end
+// of the interval. Pushes landing before then share it, and the last one's
+// refresh hint wins.
+func (h *Handler) throttledPresent(ctx context.Context, update eink.Update) (interface{}, error) {
+	h.throttleMu.Lock()
+	if h.presentInterval <= 0 {
+		h.throttleMu.Unlock()
+		return h.presentWith(ctx, update)
+	}
+	if h.throttled != nil {
+		*h.throttled = update
+		h.throttleMu.Unlock()
+		return nil, nil
+	}
+	now := h.now()
+	wait := h.lastPushPresent.Add(h.presentInterval).Sub(now)
+	if wait <= 0 {
+		h.lastPushPresent = now
+		h.throttleMu.Unlock()
+		return h.presentWith(ctx, update)
+	}
+	h.throttled = &update
+	h.throttleMu.Unlock()
+	ctx = context.WithoutCancel(ctx)
+	h.afterFunc(wait, func() { h.flushThrottled(ctx) })
+	return nil, nil
+}
+
+func (h *Handler) flushThrottled(ctx context.Context) {
+	h.throttleMu.Lock()
+	pending := h.throttled
+	h.throttled = nil
+	h.lastPushPresent = h.now()
+	h.throttleMu.Unlock()
+	if pending == nil {
+		return
+	}
+	if _, err := h.presentWith(ctx, *pending); err != nil {
+		h.loggerFor(ctx).Warn().Err(err).Msg("throttled present failed")
+	}
 }
 
-func (h *Handler) handleA2UIPushJSONL(args json.RawMessage) (interface{}, error) {
-	jsonl, err := unwrapStringArgs(args)
+type screenArgs struct {
+	Name            string `json:"name"`
+	RefreshHint     string `json:"refreshHint,omitempty"`
+	Transition      string `json:"transition,omitempty"`
+	TransitionSteps int    `json:"transitionSteps,omitempty"`
+}
+
+// Screen transitions, drawn as a few fast partial refreshes before the
+// final present.
+const (
+	TransitionNone  = "none"
+	TransitionWipe  = "wipe"
+	TransitionSlide = "slide"
+)
+
+const (
+	defaultTransitionSteps = 4
+	transitionFrame        = 150 * time.Millisecond
+)
+
+func (h *Handler) handleScreenShow(ctx context.Context, args json.RawMessage) (interface{}, error) {
+	var screen screenArgs
+	if err := json.Unmarshal(positionalArgs(args, "name", "refreshHint"), &screen); err != nil {
+		return nil, err
+	}
+	if screen.Name == "" {
+		return nil, errors.New("screen requires a name")
+	}
+	update, err := refreshHintUpdate(screen.RefreshHint)
 	if err != nil {
 		return nil, err
 	}
-	pushes, err := DecodeA2UIJSONL([]byte(jsonl))
+	switch screen.Transition {
+	case "", TransitionNone, TransitionWipe, TransitionSlide:
+	default:
+		return nil, fmt.Errorf("unknown transition %q", screen.Transition)
+	}
+	if !h.state.ShowScreen(screen.Name) {
+		return nil, fmt.Errorf("unknown screen %q", screen.Name)
+	}
+	if screen.Transition == TransitionWipe || screen.Transition == TransitionSlide {
+		steps := screen.TransitionSteps
+		if steps <= 0 {
+			steps = defaultTransitionSteps
+		}
+		if err := h.playTransition(ctx, screen.Transition, steps); err != nil {
+			return nil, err
+		}
+	}
+	return h.presentWith(ctx, update)
+}
+
+// playTransition animates from what is on screen to the current components
+// in steps frames, the last of which is left to the caller's present. A wipe
+// reveals the new screen left to right; a slide pushes the old one out to
+// the left.
+func (h *Handler) playTransition(ctx context.Context, kind string, steps int) error {
+	h.renderMu.Lock()
+	h.syncSize()
+	from := image.NewGray(h.renderer.Image.Rect)
+	copy(from.Pix, h.renderer.Image.Pix)
+	h.renderer.Render(h.state.Components())
+	to := image.NewGray(h.renderer.Image.Rect)
+	copy(to.Pix, h.renderer.Image.Pix)
+	h.renderMu.Unlock()
+
+	bounds := to.Rect
+	width := bounds.Dx()
+	frame := image.NewGray(bounds)
+	ticks, stop := h.newTicker(transitionFrame)
+	defer stop()
+	for i := 1; i < steps; i++ {
+		edge := width * i / steps
+		var region image.Rectangle
+		var err error
+		h.renderMu.Lock()
+		switch kind {
+		case TransitionWipe:
+			strip := image.Rect(width*(i-1)/steps, bounds.Min.Y, edge, bounds.Max.Y)
+			region, err = h.fb.WriteGrayRegion(to.SubImage(strip).(*image.Gray), strip.Min)
+		case TransitionSlide:
+			draw.Draw(frame, image.Rect(0, bounds.Min.Y, width-edge, bounds.Max.Y), from, image.Pt(edge, bounds.Min.Y), draw.Src)
+			draw.Draw(frame, image.Rect(width-edge, bounds.Min.Y, width, bounds.Max.Y), to, bounds.Min, draw.Src)
+			region, err = bounds, h.fb.WriteGray(frame)
+		}
+		if err == nil {
+			err = h.refresh(eink.Update{Region: region, Fast: true})
+		}
+		h.renderMu.Unlock()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticks:
+		}
+	}
+	return nil
+}
+
+// refreshHintUpdate maps a push's refreshHint to an update: fast (the
+// default) for quick A2 redraws, full for a clean GC16 flash after a run of
+// fast updates, and auto to let the driver choose the waveform.
+func refreshHintUpdate(hint string) (eink.Update, error) {
+	switch strings.ToLower(hint) {
+	case "", RefreshFast:
+		return eink.Update{Fast: true}, nil
+	case RefreshFull:
+		return eink.Update{Full: true, Waveform: eink.WaveformModeGC16}, nil
+	case RefreshAuto:
+		return eink.Update{Waveform: eink.WaveformModeAuto}, nil
+	}
+	return eink.Update{}, fmt.Errorf("unknown refreshHint %q", hint)
+}
+
+func (h *Handler) handleA2UIPushJSONL(ctx context.Context, req InvokeRequest) (interface{}, error) {
+	args, err := decodeJSONLArgs(req.Args)
+	if err != nil {
+		return nil, err
+	}
+	pushes, err := DecodeA2UIJSONL([]byte(*args.JSONL), h.palette)
+	if err != nil {
+		return nil, err
+	}
+	h.streamMu.Lock()
+	defer h.streamMu.Unlock()
+	hint := h.streamHint
+	for _, push := range pushes {
+		if push.RefreshHint != "" {
+			hint = push.RefreshHint
+		}
+	}
+	update, err := refreshHintUpdate(hint)
 	if err != nil {
 		return nil, err
 	}
 	for _, push := range pushes {
 		h.state.ApplyPush(push)
 	}
-	return h.present(true)
+	if args.Stream && !args.Flush {
+		h.streamHint = hint
+		return nil, nil
+	}
+	h.streamHint = ""
+	h.reportProgress(ctx, req, 0.5, "rendering")
+	return h.throttledPresent(ctx, update)
+}
+
+func (h *Handler) reportProgress(ctx context.Context, req InvokeRequest, progress float64, message string) {
+	if req.Progress == nil {
+		return
+	}
+	if err := req.Progress(ctx, progress, message); err != nil {
+		h.loggerFor(ctx).Debug().Err(err).Str("command", req.Command).Msg("failed to report progress")
+	}
+}
+
+type blitArgs struct {
+	Data   string `json:"data"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Stride int    `json:"stride,omitempty"`
+}
+
+func (h *Handler) handleBlit(args json.RawMessage) (interface{}, error) {
+	var blit blitArgs
+	if err := json.Unmarshal(positionalArgs(args, "data", "width", "height", "stride"), &blit); err != nil {
+		return nil, err
+	}
+	pix, err := base64.StdEncoding.DecodeString(blit.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blit data: %w", err)
+	}
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.adoptPendingFramebuffer()
+	if blit.Width != h.fb.Width || blit.Height != h.fb.Height {
+		return nil, fmt.Errorf("blit size %dx%d does not match framebuffer %dx%d", blit.Width, blit.Height, h.fb.Width, h.fb.Height)
+	}
+	stride := blit.Stride
+	if stride == 0 {
+		stride = blit.Width
+	}
+	if err := h.fb.WriteRaw(pix, stride); err != nil {
+		return nil, err
+	}
+	h.syncSize()
+	h.renderer.Clear()
+	for y := 0; y < h.renderer.Height && y < blit.Height; y++ {
+		copy(h.renderer.Image.Pix[y*h.renderer.Image.Stride:y*h.renderer.Image.Stride+h.renderer.Width], pix[y*stride:y*stride+blit.Width])
+	}
+	return nil, h.refresh(eink.Update{Full: true})
+}
+
+type imageArgs struct {
+	Data       string `json:"data"`
+	Background *uint8 `json:"background,omitempty"`
+}
+
+// handleImage shows a full-frame PNG or JPEG, such as a document page
+// rendered by the gateway, scaled to fit the panel and letterboxed with the
+// background gray. Like a blit, it replaces the screen until the next present.
+func (h *Handler) handleImage(args json.RawMessage) (interface{}, error) {
+	var req imageArgs
+	if err := json.Unmarshal(positionalArgs(args, "data", "background"), &req); err != nil {
+		return nil, err
+	}
+	src, err := decodeImageSrc(req.Data)
+	if err != nil {
+		return nil, err
+	}
+	background := uint8(255)
+	if req.Background != nil {
+		background = *req.Background
+	}
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.syncSize()
+	h.renderer.Clear()
+	dst := h.renderer.Image
+	draw.Draw(dst, dst.Rect, &image.Uniform{C: color.Gray{Y: background}}, image.Point{}, draw.Src)
+	xdraw.BiLinear.Scale(dst, letterbox(src.Bounds().Size(), dst.Rect), src, src.Bounds(), xdraw.Src, nil)
+	h.resetOverlaysLocked()
+	if err := h.fb.WriteGray(dst); err != nil {
+		return nil, err
+	}
+	return nil, h.refresh(eink.Update{Full: true, Waveform: eink.WaveformModeGC16})
+}
+
+// letterbox returns the largest rect with the aspect ratio of size that fits
+// centered in bounds.
+func letterbox(size image.Point, bounds image.Rectangle) image.Rectangle {
+	if size.X <= 0 || size.Y <= 0 {
+		return image.Rectangle{}
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	if size.X*height > size.Y*width {
+		height = size.Y * width / size.X
+	} else {
+		width = size.X * height / size.Y
+	}
+	origin := bounds.Min.Add(image.Pt((bounds.Dx()-width)/2, (bounds.Dy()-height)/2))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(width, height))}
+}
+
+type measureTextArgs struct {
+	Text     string  `json:"text"`
+	Font     string  `json:"font,omitempty"`
+	FontSize float64 `json:"fontSize,omitempty"`
+}
+
+func (h *Handler) handleMeasureText(args json.RawMessage) (interface{}, error) {
+	var req measureTextArgs
+	if err := json.Unmarshal(positionalArgs(args, "text", "font", "fontSize"), &req); err != nil {
+		return nil, err
+	}
+	h.renderMu.RLock()
+	defer h.renderMu.RUnlock()
+	return h.renderer.MeasureText(req.Text, req.Font, req.FontSize), nil
+}
+
+type eraseArgs struct {
+	regionArgs
+	Gray *uint8 `json:"gray,omitempty"`
+}
+
+func (h *Handler) handleErase(args json.RawMessage) (interface{}, error) {
+	var erase eraseArgs
+	if err := json.Unmarshal(positionalArgs(args, "x", "y", "width", "height", "gray"), &erase); err != nil {
+		return nil, err
+	}
+	if erase.Width <= 0 || erase.Height <= 0 {
+		return nil, errors.New("erase region requires positive width and height")
+	}
+	gray := uint8(255)
+	if erase.Gray != nil {
+		gray = *erase.Gray
+	}
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.syncSize()
+	region := image.Rect(erase.X, erase.Y, erase.X+erase.Width, erase.Y+erase.Height)
+	if !region.In(h.renderer.Image.Bounds()) {
+		return nil, fmt.Errorf("erase region %v outside screen %v", region, h.renderer.Image.Bounds())
+	}
+	draw.Draw(h.renderer.Image, region, &image.Uniform{C: color.Gray{Y: gray}}, image.Point{}, draw.Src)
+	patch := h.renderer.Image.SubImage(region).(*image.Gray)
+	if _, err := h.fb.WriteGrayRegion(patch, region.Min); err != nil {
+		return nil, err
+	}
+	return nil, h.refresh(eink.Update{Region: region})
+}
+
+type marqueeArgs struct {
+	ID         string `json:"id"`
+	IntervalMs int    `json:"intervalMs,omitempty"`
+}
+
+func decodeMarqueeArgs(args json.RawMessage) (marqueeArgs, error) {
+	var marquee marqueeArgs
+	if err := json.Unmarshal(positionalArgs(args, "id", "intervalMs"), &marquee); err != nil {
+		return marqueeArgs{}, err
+	}
+	if marquee.ID == "" {
+		return marqueeArgs{}, errors.New("marquee requires a component id")
+	}
+	return marquee, nil
 }
 
-func (h *Handler) present(partial bool) (interface{}, error) {
+func (h *Handler) handleMarqueeStart(args json.RawMessage) (interface{}, error) {
+	marquee, err := decodeMarqueeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	interval := time.Duration(marquee.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultMarqueeInterval
+	}
+	h.renderMu.RLock()
+	_, ok := h.renderer.Marquee(marquee.ID)
+	h.renderMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no overflowing marquee text with id %q", marquee.ID)
+	}
+	h.StartMarquee(marquee.ID, interval)
+	return nil, nil
+}
+
+func (h *Handler) handleMarqueeStop(args json.RawMessage) (interface{}, error) {
+	marquee, err := decodeMarqueeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	h.StopMarquee(marquee.ID)
+	return nil, nil
+}
+
+// StartMarquee scrolls the marquee text component with the given id by one
+// step per interval until stopped or the component no longer overflows.
+func (h *Handler) StartMarquee(id string, interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &marqueeRun{cancel: cancel}
+	h.marqueeMu.Lock()
+	if h.marquees == nil {
+		h.marquees = make(map[string]*marqueeRun)
+	}
+	if prev, ok := h.marquees[id]; ok {
+		prev.cancel()
+	}
+	h.marquees[id] = run
+	h.marqueeMu.Unlock()
+
+	ticks, stop := h.newTicker(interval)
+	go func() {
+		defer stop()
+		defer h.finishMarquee(id, run)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticks:
+			}
+			ok, err := h.advanceMarquee(ctx, id)
+			if err != nil {
+				h.logger.Warn().Err(err).Str("id", id).Msg("failed to advance marquee")
+			}
+			if !ok {
+				return
+			}
+		}
+	}()
+}
+
+func (h *Handler) StopMarquee(id string) {
+	h.marqueeMu.Lock()
+	defer h.marqueeMu.Unlock()
+	if run, ok := h.marquees[id]; ok {
+		run.cancel()
+		delete(h.marquees, id)
+	}
+}
+
+// Close stops every running marquee and key repeat. The handler stays
+// usable, so it is also called before suspending.
+func (h *Handler) Close() {
+	h.marqueeMu.Lock()
+	for id, run := range h.marquees {
+		run.cancel()
+		delete(h.marquees, id)
+	}
+	h.marqueeMu.Unlock()
+	h.HandleTouchRelease()
+}
+
+func (h *Handler) finishMarquee(id string, run *marqueeRun) {
+	h.marqueeMu.Lock()
+	defer h.marqueeMu.Unlock()
+	run.cancel()
+	if current, ok := h.marquees[id]; ok && current == run {
+		delete(h.marquees, id)
+	}
+}
+
+func (h *Handler) advanceMarquee(ctx context.Context, id string) (bool, error) {
+	h.renderMu.Lock()
+	if ctx.Err() != nil {
+		h.renderMu.Unlock()
+		return false, nil
+	}
+	target, ok := h.renderer.Marquee(id)
+	if !ok {
+		h.renderMu.Unlock()
+		return false, nil
+	}
+	h.renderer.SetMarqueeOffset(id, (h.renderer.MarqueeOffset(id)+marqueeStep)%target.Period)
+	h.syncSize()
+	h.renderer.Render(h.state.Components())
+	if target, ok = h.renderer.Marquee(id); !ok {
+		h.renderMu.Unlock()
+		return false, nil
+	}
+	patch := h.renderer.Image.SubImage(target.Rect).(*image.Gray)
+	region, err := h.fb.WriteGrayRegion(patch, target.Rect.Min)
+	if err != nil {
+		h.renderMu.Unlock()
+		return true, err
+	}
+	err = h.refresh(eink.Update{Region: region, Fast: true})
+	h.renderMu.Unlock()
+	return true, err
+}
+
+// ShowSleepCountdown overlays a "sleeping in Ns" banner on the current
+// screen and refreshes only the banner region.
+func (h *Handler) ShowSleepCountdown(remaining time.Duration) error {
+	seconds := int((remaining + time.Second - 1) / time.Second)
+	return h.showOverlay(overlayCountdown, fmt.Sprintf("Sleeping in %ds", seconds))
+}
+
+// ClearSleepCountdown removes the countdown banner, if shown, by redrawing
+// the components underneath it.
+func (h *Handler) ClearSleepCountdown() error {
+	return h.clearOverlay(overlayCountdown)
+}
+
+func (h *Handler) showOverlay(kind overlayKind, text string) error {
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.overlayText[kind] = text
+	return h.redrawOverlaysLocked(true)
+}
+
+func (h *Handler) clearOverlay(kind overlayKind) error {
 	h.renderMu.Lock()
 	defer h.renderMu.Unlock()
+	if h.overlayText[kind] == "" {
+		return nil
+	}
+	h.overlayText[kind] = ""
+	return h.redrawOverlaysLocked(false)
+}
+
+// redrawOverlaysLocked redraws the components under the banners, then the
+// banners still shown, and refreshes everything they cover now or did before.
+func (h *Handler) redrawOverlaysLocked(fast bool) error {
+	h.syncSize()
 	h.renderer.Render(h.state.Components())
+	dirty := h.overlay
+	h.overlay = image.Rectangle{}
+	row := 0
+	for _, text := range h.overlayText {
+		if text == "" {
+			continue
+		}
+		h.overlay = h.overlay.Union(h.renderer.DrawOverlay(text, row))
+		row++
+	}
+	dirty = dirty.Union(h.overlay)
+	if dirty.Empty() {
+		return nil
+	}
+	region, err := h.writeRegion(dirty)
+	if err != nil {
+		return err
+	}
+	return h.refresh(eink.Update{Region: region, Fast: fast})
+}
+
+// resetOverlaysLocked forgets the banners once the screen under them has
+// been redrawn.
+func (h *Handler) resetOverlaysLocked() {
+	h.overlay = image.Rectangle{}
+	h.overlayText = [overlayKinds]string{}
+}
+
+// SleepScreen is drawn before suspend so the panel, which keeps its last
+// image while off, shows something intentional. Image, if set, is centered
+// over the rendered Components; otherwise Logo centers the branding logo.
+type SleepScreen struct {
+	Components []A2UIComponent
+	Image      image.Image
+	Logo       bool
+}
+
+// ShowSleepScreen draws screen with a full GC16 refresh. The A2UI state is
+// left alone, so FullRefresh restores the previous screen on wake.
+func (h *Handler) ShowSleepScreen(screen SleepScreen) error {
+	h.renderMu.Lock()
+	h.syncSize()
+	h.renderer.Render(screen.Components)
+	centered := screen.Image
+	if centered == nil && screen.Logo {
+		centered = h.renderer.Logo
+	}
+	if centered != nil {
+		bounds := centered.Bounds()
+		canvasRect := h.renderer.Image.Rect
+		offset := image.Pt((canvasRect.Dx()-bounds.Dx())/2, (canvasRect.Dy()-bounds.Dy())/2)
+		draw.Draw(h.renderer.Image, bounds.Sub(bounds.Min).Add(offset), centered, bounds.Min, draw.Over)
+	}
+	h.resetOverlaysLocked()
+	err := h.fb.WriteGray(h.renderer.Image)
+	if err == nil {
+		err = h.refresh(eink.Update{Full: true, Waveform: eink.WaveformModeGC16})
+	}
+	h.renderMu.Unlock()
+	return err
+}
+
+func (h *Handler) writeRegion(rect image.Rectangle) (image.Rectangle, error) {
+	rect = rect.Intersect(h.renderer.Image.Bounds())
+	if rect.Empty() {
+		return rect, errors.New("region outside screen")
+	}
+	patch := h.renderer.Image.SubImage(rect).(*image.Gray)
+	return h.fb.WriteGrayRegion(patch, rect.Min)
+}
+
+func (h *Handler) presentWith(ctx context.Context, update eink.Update) (interface{}, error) {
+	start := h.now()
+	components, err := h.render(ctx, update)
+	if err != nil {
+		return nil, err
+	}
+	h.checkRenderBudget(ctx, h.now().Sub(start), components)
+	return nil, nil
+}
+
+func (h *Handler) watchRender(ctx context.Context) func() {
+	if h.renderTimeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(h.renderTimeout, func() {
+		h.renderStalled(context.WithoutCancel(ctx))
+	})
+	return func() {
+		timer.Stop()
+		h.statsMu.Lock()
+		recovered := h.degraded
+		h.degraded = false
+		h.statsMu.Unlock()
+		if recovered {
+			h.loggerFor(ctx).Info().Msg("render recovered after stall")
+		}
+	}
+}
+
+func (h *Handler) renderStalled(ctx context.Context) {
+	h.statsMu.Lock()
+	if h.degraded {
+		// Already reported, and a reopen is already pending.
+		h.statsMu.Unlock()
+		return
+	}
+	h.degraded = true
+	h.renderStalls++
+	h.lastStall = h.now()
+	h.statsMu.Unlock()
+	logger := h.loggerFor(ctx)
+	logger.Error().Dur("timeout", h.renderTimeout).Msg("render did not complete, framebuffer may be wedged")
+	if h.sender != nil {
+		params := gateway.NodeEventParams{
+			Event:   stalledRenderEvent,
+			Payload: withTraceID(ctx, map[string]interface{}{"timeoutMs": h.renderTimeout.Milliseconds()}),
+		}
+		if err := h.sender.SendEvent(ctx, "node.event", params); err != nil {
+			logger.Debug().Err(err).Msg("failed to send render stall event")
+		}
+	}
+	if h.onRenderStall != nil {
+		h.onRenderStall()
+	}
+}
+
+func (h *Handler) adoptPendingFramebuffer() {
+	h.pendingMu.Lock()
+	fb := h.pendingFB
+	h.pendingFB = nil
+	h.pendingMu.Unlock()
+	if fb == nil {
+		return
+	}
+	old := h.fb
+	h.fb = fb
+	if err := old.Close(); err != nil {
+		h.logger.Debug().Err(err).Msg("failed to close replaced framebuffer")
+	}
+	h.logger.Info().Msg("switched to reopened framebuffer")
+}
+
+func (h *Handler) render(ctx context.Context, update eink.Update) (int, error) {
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	// Timed from here, so presents queued behind a wedged one do not stall
+	// too.
+	defer h.watchRender(ctx)()
+	// Set and cleared under renderMu, so concurrent presents cannot clear
+	// the blocker while another still runs.
+	if h.rendering != nil {
+		h.rendering(true)
+		defer h.rendering(false)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	h.adoptPendingFramebuffer()
+	h.syncSize()
+	if !update.Region.Empty() {
+		update.Region = update.Region.Intersect(h.renderer.Image.Bounds())
+		if update.Region.Empty() {
+			return 0, errors.New("present region is off screen")
+		}
+	}
+	components := h.state.Components()
+	h.renderer.Render(components)
+	h.resetOverlaysLocked()
+	// Actions on the screen are traced to the invoke that presented it.
+	h.traceID = traceID(ctx)
 	if err := h.fb.WriteGray(h.renderer.Image); err != nil {
+		return 0, err
+	}
+	return len(components), h.refresh(update)
+}
+
+// snapshot encodes a copy of the rendered image so that presents are not
+// held up while a large PNG is encoded.
+func (h *Handler) snapshot(ctx context.Context) (interface{}, error) {
+	h.renderMu.RLock()
+	frame := copySnapshotFrame(h.renderer.Image)
+	h.renderMu.RUnlock()
+	defer releaseSnapshotFrame(frame)
+	out, scale, err := h.encodeSnapshot(frame, h.snapshotMaxBytes)
+	if err != nil {
 		return nil, err
 	}
-	update := eink.Update{Full: !partial}
-	if partial {
-		update.Fast = true
+	if scale > 1 {
+		h.loggerFor(ctx).Warn().Int("scale", scale).Int("limit", h.snapshotMaxBytes).Msg("snapshot downscaled to fit size limit")
+	}
+	return out, nil
+}
+
+func (h *Handler) checkRenderBudget(ctx context.Context, elapsed time.Duration, components int) {
+	if h.renderBudget <= 0 || elapsed <= h.renderBudget {
+		return
+	}
+	logger := h.loggerFor(ctx)
+	logger.Warn().Dur("duration", elapsed).Int("components", components).Msg("render exceeded time budget")
+	if h.sender == nil {
+		return
+	}
+	params := gateway.NodeEventParams{
+		Event: slowRenderEvent,
+		Payload: withTraceID(ctx, map[string]interface{}{
+			"durationMs": elapsed.Milliseconds(),
+			"budgetMs":   h.renderBudget.Milliseconds(),
+			"components": components,
+		}),
+	}
+	if err := h.sender.SendEvent(ctx, "node.event", params); err != nil {
+		logger.Debug().Err(err).Msg("failed to send slow render warning")
 	}
-	return nil, h.fb.Refresh(update)
+}
+
+type traceIDKey struct{}
+
+// traceID returns the trace id of the invoke ctx belongs to, if any.
+func traceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// withTraceID adds the trace id of ctx's invoke to an event payload.
+func withTraceID(ctx context.Context, payload map[string]interface{}) map[string]interface{} {
+	if id := traceID(ctx); id != "" {
+		payload["traceId"] = id
+	}
+	return payload
+}
+
+func (h *Handler) loggerFor(ctx context.Context) *zerolog.Logger {
+	if logger := zerolog.Ctx(ctx); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return &h.logger
 }
 
 func (h *Handler) HandleTouch(ctx context.Context, x, y int) {
@@ -134,8 +1256,77 @@ func (h *Handler) HandleTouch(ctx context.Context, x, y int) {
 	action := h.renderer.HitTest(x, y)
 	h.renderMu.RUnlock()
 	if action == nil || h.sender == nil {
+		h.HandleTouchRelease()
+		return
+	}
+	if h.continueKeyRepeat(*action) {
 		return
 	}
+	h.sendAction(ctx, *action, x, y, false)
+	if action.Repeat {
+		h.startKeyRepeat(*action, x, y)
+	}
+}
+
+// HandleTouchRelease stops any action repeating under a held touch.
+func (h *Handler) HandleTouchRelease() {
+	h.repeatMu.Lock()
+	defer h.repeatMu.Unlock()
+	if h.keyRepeat != nil {
+		h.keyRepeat.cancel()
+		h.keyRepeat = nil
+	}
+}
+
+// continueKeyRepeat reports whether a touch still on the repeating action
+// should be absorbed by the running repeat. Moving onto anything else ends it.
+func (h *Handler) continueKeyRepeat(action A2UIAction) bool {
+	h.repeatMu.Lock()
+	defer h.repeatMu.Unlock()
+	if h.keyRepeat == nil {
+		return false
+	}
+	current := h.keyRepeat.action
+	if current.Type == action.Type && bytes.Equal(current.Payload, action.Payload) {
+		return true
+	}
+	h.keyRepeat.cancel()
+	h.keyRepeat = nil
+	return false
+}
+
+func (h *Handler) startKeyRepeat(action A2UIAction, x, y int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.repeatMu.Lock()
+	if h.keyRepeat != nil {
+		h.keyRepeat.cancel()
+	}
+	h.keyRepeat = &keyRepeat{action: action, cancel: cancel}
+	h.repeatMu.Unlock()
+
+	delay, stopDelay := h.newTicker(h.repeatDelay)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopDelay()
+			return
+		case <-delay:
+		}
+		stopDelay()
+		ticks, stop := h.newTicker(h.repeatInterval)
+		defer stop()
+		for {
+			h.sendAction(ctx, action, x, y, true)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticks:
+			}
+		}
+	}()
+}
+
+func (h *Handler) sendAction(ctx context.Context, action A2UIAction, x, y int, repeat bool) {
 	actionPayload := map[string]interface{}{
 		"type":    action.Type,
 		"payload": json.RawMessage(action.Payload),
@@ -143,27 +1334,96 @@ func (h *Handler) HandleTouch(ctx context.Context, x, y int) {
 		"y":       y,
 		"time":    time.Now().UnixMilli(),
 	}
+	if repeat {
+		actionPayload["repeat"] = true
+	}
+	if len(h.actionContext) > 0 {
+		actionPayload["context"] = h.actionContext
+	}
+	h.renderMu.RLock()
+	if h.traceID != "" {
+		actionPayload["traceId"] = h.traceID
+	}
+	h.renderMu.RUnlock()
 	params := gateway.NodeEventParams{
-		Event:   "canvas.a2ui.action",
+		Event:   h.actionEvent,
 		Payload: actionPayload,
 	}
 	if err := h.sender.SendEvent(ctx, "node.event", params); err != nil {
 		h.logger.Warn().Err(err).Msg("failed to send A2UI action")
+		h.showActionFailure()
+	}
+}
+
+// showActionFailure flashes the "No connection" banner, clearing it once the
+// notice duration has passed. Failures while it is up do not redraw it.
+func (h *Handler) showActionFailure() {
+	if h.actionFailNotice <= 0 {
+		return
+	}
+	h.renderMu.Lock()
+	now := h.now()
+	if now.Before(h.noticeUntil) {
+		h.renderMu.Unlock()
+		return
+	}
+	h.noticeUntil = now.Add(h.actionFailNotice)
+	h.overlayText[overlayActionFailure] = "No connection"
+	err := h.redrawOverlaysLocked(true)
+	h.renderMu.Unlock()
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("failed to show action failure notice")
+		return
 	}
+	h.afterFunc(h.actionFailNotice, func() {
+		if err := h.clearOverlay(overlayActionFailure); err != nil {
+			h.logger.Warn().Err(err).Msg("failed to clear action failure notice")
+		}
+	})
 }
 
-func unwrapStringArgs(args json.RawMessage) (string, error) {
+func positionalArgs(args json.RawMessage, names ...string) json.RawMessage {
+	var values []json.RawMessage
+	if err := json.Unmarshal(args, &values); err != nil {
+		return args
+	}
+	obj := make(map[string]json.RawMessage, len(values))
+	for i, value := range values {
+		if i >= len(names) {
+			break
+		}
+		obj[names[i]] = value
+	}
+	encoded, err := json.Marshal(obj)
+	if err != nil {
+		return args
+	}
+	return encoded
+}
+
+// jsonlArgs are the pushJSONL args. A large UI can be streamed over several
+// invokes marked stream, which only update state; the invoke marked flush,
+// or any unmarked one, presents everything received so far.
+type jsonlArgs struct {
+	JSONL  *string `json:"jsonl"`
+	Stream bool    `json:"stream"`
+	Flush  bool    `json:"flush"`
+}
+
+func decodeJSONLArgs(raw json.RawMessage) (jsonlArgs, error) {
+	raw = positionalArgs(raw, "jsonl", "stream", "flush")
 	var asString string
-	if err := json.Unmarshal(args, &asString); err == nil {
-		return asString, nil
+	if err := json.Unmarshal(raw, &asString); err == nil {
+		return jsonlArgs{JSONL: &asString}, nil
 	}
-	var obj map[string]string
-	if err := json.Unmarshal(args, &obj); err == nil {
-		if val, ok := obj["jsonl"]; ok {
-			return val, nil
+	var args jsonlArgs
+	if err := json.Unmarshal(raw, &args); err == nil && (args.JSONL != nil || args.Flush) {
+		if args.JSONL == nil {
+			args.JSONL = new(string)
 		}
+		return args, nil
 	}
-	return "", errors.New("invalid JSONL args")
+	return jsonlArgs{}, errors.New("invalid JSONL args")
 }
 
 func sanitizeCommand(cmd string) string {
@@ -172,9 +1432,15 @@ func sanitizeCommand(cmd string) string {
 
 func (h *Handler) HandleInvokeRequest(ctx context.Context, req InvokeRequest) (interface{}, error) {
 	req.Command = sanitizeCommand(req.Command)
+	if err := h.waitReady(ctx, req.Command); err != nil {
+		return nil, err
+	}
 	if h.resetIdle != nil {
 		h.resetIdle()
 	}
+	if req.TraceID != "" {
+		ctx = context.WithValue(ctx, traceIDKey{}, req.TraceID)
+	}
 	if h.commandProcessing != nil {
 		h.commandProcessing(true)
 		defer h.commandProcessing(false)
@@ -185,9 +1451,182 @@ func (h *Handler) HandleInvokeRequest(ctx context.Context, req InvokeRequest) (i
 func (h *Handler) FullRefresh() error {
 	h.renderMu.Lock()
 	defer h.renderMu.Unlock()
+	return h.fullRefreshLocked()
+}
+
+// RefreshIfPartial fully refreshes the current content if it has had
+// partial refreshes since the last full one, such as a burst of fast
+// updates cut short by a lost connection, reporting whether it did. A
+// screen already cleaned, e.g. by the wake path, is left alone.
+func (h *Handler) RefreshIfPartial() (bool, error) {
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.statsMu.Lock()
+	partial := h.partialRefreshes > 0
+	h.statsMu.Unlock()
+	if !partial {
+		return false, nil
+	}
+	return true, h.fullRefreshLocked()
+}
+
+func (h *Handler) fullRefreshLocked() error {
+	h.adoptPendingFramebuffer()
+	if _, err := h.fb.Redetect(); err != nil {
+		h.logger.Warn().Err(err).Msg("failed to redetect framebuffer size")
+	}
+	h.syncSize()
 	h.renderer.Render(h.state.Components())
 	if err := h.fb.WriteGray(h.renderer.Image); err != nil {
 		return err
 	}
-	return h.fb.Refresh(eink.Update{Full: true, Waveform: eink.WaveformModeGC16})
+	return h.refresh(eink.Update{Full: true, Waveform: eink.WaveformModeGC16})
+}
+
+// RunIdleRefresh fully refreshes the current content whenever interval has
+// passed without a full refresh, since a frame left up for hours ghosts even
+// without updates. The period is stretched by a random tenth at most, so a
+// fleet started together does not flash in unison. It returns when ctx is
+// done.
+func (h *Handler) RunIdleRefresh(ctx context.Context, interval time.Duration) {
+	ticks, stop := h.newTicker(interval + rand.N(interval/10+1))
+	defer stop()
+	// Full refreshes are counted rather than timed, so that rendering does
+	// not read the clock; one seen since the last tick dates from that tick.
+	h.statsMu.Lock()
+	seenFulls := h.fullRefreshes
+	h.statsMu.Unlock()
+	lastFull := h.now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+		}
+		h.statsMu.Lock()
+		shown, fulls := h.lastRefresh != "", h.fullRefreshes
+		h.statsMu.Unlock()
+		if fulls != seenFulls {
+			seenFulls, lastFull = fulls, h.now()
+		}
+		if !shown || h.now().Sub(lastFull) < interval {
+			continue
+		}
+		h.logger.Debug().Msg("idle refresh")
+		if err := h.FullRefresh(); err != nil {
+			h.logger.Warn().Err(err).Msg("idle refresh failed")
+		}
+		h.statsMu.Lock()
+		seenFulls = h.fullRefreshes
+		h.statsMu.Unlock()
+		lastFull = h.now()
+	}
+}
+
+// refresh must be called with renderMu held, since flashing reads and
+// rewrites the framebuffer.
+func (h *Handler) refresh(update eink.Update) error {
+	refresh := h.fb.Refresh
+	if h.refreshFunc != nil {
+		refresh = h.refreshFunc
+	}
+	h.statsMu.Lock()
+	flash := update.Full && h.flashFull
+	h.statsMu.Unlock()
+	if flash {
+		if err := h.flashBlackLocked(refresh); err != nil {
+			return err
+		}
+	}
+	if err := refresh(update); err != nil {
+		return err
+	}
+	h.statsMu.Lock()
+	h.lastUpdate = update
+	switch {
+	case update.Full:
+		h.lastRefresh = "full"
+		h.fullRefreshes++
+		h.partialRefreshes = 0
+		h.ghosting = 0
+	case update.Fast:
+		h.lastRefresh = "fast"
+		h.partialRefreshes++
+		h.ghosting += h.changedFraction(update.Region)
+	default:
+		h.lastRefresh = "partial"
+		h.partialRefreshes++
+		h.ghosting += h.changedFraction(update.Region) / 2
+	}
+	ghosting := h.ghosting
+	deghost := !update.Full && h.deghostThreshold > 0 && ghosting >= h.deghostThreshold
+	h.statsMu.Unlock()
+	if deghost {
+		h.logger.Debug().Float64("ghosting", ghosting).Msg("ghosting threshold reached, cleaning screen")
+		return h.refresh(eink.Update{Full: true, Waveform: eink.WaveformModeGC16})
+	}
+	return nil
+}
+
+// flashBlackLocked fully refreshes the panel to black and puts back what the
+// framebuffer held, ready for the content refresh that follows.
+func (h *Handler) flashBlackLocked(refresh func(eink.Update) error) error {
+	content, err := h.fb.ReadGray()
+	if err != nil {
+		return err
+	}
+	if err := h.fb.WriteGray(image.NewGray(content.Rect)); err != nil {
+		return err
+	}
+	flashErr := refresh(eink.Update{Full: true, Waveform: eink.WaveformModeGC16})
+	if err := h.fb.WriteGray(content); err != nil {
+		return err
+	}
+	return flashErr
+}
+
+// changedFraction is the share of the screen an update covers. Ghosting is
+// estimated as the sum of these over partial refreshes, with fast (A2)
+// refreshes counting twice as much as others since they leave the most
+// residue behind.
+func (h *Handler) changedFraction(region image.Rectangle) float64 {
+	screen := image.Rect(0, 0, h.fb.Width, h.fb.Height)
+	if screen.Empty() {
+		return 0
+	}
+	if !region.Empty() {
+		screen = region.Intersect(screen)
+	}
+	full := float64(h.fb.Width * h.fb.Height)
+	return float64(screen.Dx()*screen.Dy()) / full
+}
+
+// State reports what canvas.state returns.
+func (h *Handler) State() DisplayState {
+	return h.displayState()
+}
+
+func (h *Handler) displayState() DisplayState {
+	h.renderMu.RLock()
+	width, height := h.renderer.Width, h.renderer.Height
+	h.renderMu.RUnlock()
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+	return DisplayState{
+		Components:       len(h.state.Components()),
+		Width:            width,
+		Height:           height,
+		LastRefresh:      h.lastRefresh,
+		PartialRefreshes: h.partialRefreshes,
+		Ghosting:         h.ghosting,
+		Screen:           h.state.CurrentScreen(),
+	}
+}
+
+func (h *Handler) syncSize() {
+	if h.renderer.Width == h.fb.Width && h.renderer.Height == h.fb.Height {
+		return
+	}
+	h.logger.Info().Int("width", h.fb.Width).Int("height", h.fb.Height).Msg("framebuffer size changed, resizing renderer")
+	h.renderer.Resize(h.fb.Width, h.fb.Height)
 }
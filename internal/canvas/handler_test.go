@@ -1,14 +1,27 @@
 package canvas
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/openclaw/openclaw-node-kobo/internal/eink"
 	"github.com/openclaw/openclaw-node-kobo/internal/gateway"
 	"github.com/rs/zerolog"
+	"golang.org/x/image/font"
 )
 
 type mockSender struct {
@@ -64,6 +77,105 @@ func TestHandlerA2UIPush(t *testing.T) {
 	}
 }
 
+func TestHandlerA2UIPushRejectsInvalidActionType(t *testing.T) {
+	h := NewHandler(eink.NewFramebufferFromBuffer(100, 50), NewRenderer(100, 50), &mockSender{}, zerolog.Nop())
+	args := json.RawMessage(`{"components":[{"type":"button","width":40,"height":20,"action":{"type":"clickity"}}]}`)
+	_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args})
+	if err == nil || !strings.Contains(err.Error(), `invalid A2UI action type "clickity"`) {
+		t.Fatalf("expected invalid action type rejected, got %v", err)
+	}
+	if len(h.state.Components()) != 0 {
+		t.Fatalf("expected rejected push not applied")
+	}
+}
+
+func TestHandlerA2UIPushImage(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 2, 2))
+	src.SetGray(0, 0, color.Gray{Y: 0})
+	src.SetGray(1, 0, color.Gray{Y: 80})
+	src.SetGray(0, 1, color.Gray{Y: 160})
+	src.SetGray(1, 1, color.Gray{Y: 240})
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, src); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	fb := eink.NewFramebufferFromBuffer(20, 20)
+	h := NewHandler(fb, NewRenderer(20, 20), &mockSender{}, zerolog.Nop())
+	args, err := json.Marshal(map[string]interface{}{
+		"components": []map[string]interface{}{
+			{"type": "image", "x": 5, "y": 7, "src": base64.StdEncoding.EncodeToString(encoded.Bytes())},
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+		t.Fatalf("push image: %v", err)
+	}
+	out, err := fb.ReadGray()
+	if err != nil {
+		t.Fatalf("read framebuffer: %v", err)
+	}
+	for _, p := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		if got, want := out.GrayAt(5+p.X, 7+p.Y).Y, src.GrayAt(p.X, p.Y).Y; got != want {
+			t.Fatalf("pixel %v of the image: expected %d at offset (5,7), got %d", p, want, got)
+		}
+	}
+	if got := out.GrayAt(4, 7).Y; got != 255 {
+		t.Fatalf("expected background left of the image, got %d", got)
+	}
+
+	for _, bad := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("not an image"))} {
+		args := json.RawMessage(`{"components":[{"type":"image","src":"` + bad + `"}]}`)
+		if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err == nil {
+			t.Fatalf("expected push with image %q rejected", bad)
+		}
+	}
+}
+
+func TestHandlerTemplateRender(t *testing.T) {
+	renderer := NewRenderer(100, 50)
+	h := NewHandler(eink.NewFramebufferFromBuffer(100, 50), renderer, &mockSender{}, zerolog.Nop())
+	register := json.RawMessage(`{"name":"weather","template":{"replace":true,"components":[
+		{"type":"box","width":10,"height":10,"style":{"fillGray":"{{fill}}"}},
+		{"type":"text","y":20,"text":"{{city}}: {{temp}}°"}
+	]}}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.template.register", Args: register}); err != nil {
+		t.Fatalf("register template: %v", err)
+	}
+
+	render := func(data string) error {
+		args := json.RawMessage(`{"name":"weather","data":` + data + `}`)
+		_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.template.render", Args: args})
+		return err
+	}
+	if err := render(`{"fill":90,"city":"Lyon","temp":21}`); err != nil {
+		t.Fatalf("render template: %v", err)
+	}
+	components := h.state.Components()
+	if len(components) != 2 || components[1].Text != "Lyon: 21°" {
+		t.Fatalf("expected placeholders filled from data, got %+v", components)
+	}
+	if got := renderer.Image.GrayAt(1, 1).Y; got != 90 {
+		t.Fatalf("expected a numeric placeholder to fill the box with 90, got %d", got)
+	}
+
+	if err := render(`{"fill":180,"city":"Oslo","temp":-3}`); err != nil {
+		t.Fatalf("render template again: %v", err)
+	}
+	if components := h.state.Components(); components[1].Text != "Oslo: -3°" || renderer.Image.GrayAt(1, 1).Y != 180 {
+		t.Fatalf("expected the template rendered with the new data, got %+v", components)
+	}
+
+	if err := render(`{"fill":90,"city":"Lyon"}`); err == nil || !strings.Contains(err.Error(), `"temp"`) {
+		t.Fatalf("expected missing data rejected, got %v", err)
+	}
+	args := json.RawMessage(`{"name":"forecast","data":{}}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.template.render", Args: args}); err == nil {
+		t.Fatalf("expected unknown template rejected")
+	}
+}
+
 func TestHandlerConcurrentRenderHitTest(t *testing.T) {
 	fb := eink.NewFramebufferFromBuffer(100, 50)
 	renderer := NewRenderer(100, 50)
@@ -165,3 +277,1376 @@ func TestHandlerTouchEventWrapper(t *testing.T) {
 		t.Fatalf("expected payload %s, got %s", actionPayload, gotPayload)
 	}
 }
+
+func TestHandlerBlit(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(4, 2)
+	renderer := NewRenderer(4, 2)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+
+	raw := []byte{0, 10, 20, 30, 40, 50, 60, 70}
+	args, err := json.Marshal(map[string]interface{}{
+		"data":   base64.StdEncoding.EncodeToString(raw),
+		"width":  4,
+		"height": 2,
+	})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.blit", Args: args}); err != nil {
+		t.Fatalf("blit: %v", err)
+	}
+	got, err := fb.ReadGray()
+	if err != nil {
+		t.Fatalf("read framebuffer: %v", err)
+	}
+	if string(got.Pix) != string(raw) {
+		t.Fatalf("expected framebuffer %v, got %v", raw, got.Pix)
+	}
+	if string(renderer.Image.Pix) != string(raw) {
+		t.Fatalf("expected renderer image to mirror blit")
+	}
+
+	bad, _ := json.Marshal(map[string]interface{}{
+		"data":   base64.StdEncoding.EncodeToString(raw),
+		"width":  2,
+		"height": 4,
+	})
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.blit", Args: bad}); err == nil {
+		t.Fatalf("expected dimension mismatch error")
+	}
+
+	// A blit after a reopen goes to the new framebuffer.
+	reopened := eink.NewFramebufferFromBuffer(4, 2)
+	h.ReplaceFramebuffer(reopened)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.blit", Args: args}); err != nil {
+		t.Fatalf("blit after reopen: %v", err)
+	}
+	if got, err := reopened.ReadGray(); err != nil || string(got.Pix) != string(raw) {
+		t.Fatalf("expected blit written to the reopened framebuffer, got %v (%v)", got, err)
+	}
+}
+
+func TestHandlerResizesOnFramebufferRotation(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(4, 2)
+	renderer := NewRenderer(4, 2)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+
+	// Simulate the panel reporting rotated geometry after resume.
+	fb.Width, fb.Height, fb.Stride = 2, 4, 2
+	if err := h.FullRefresh(); err != nil {
+		t.Fatalf("full refresh after rotation: %v", err)
+	}
+	if renderer.Width != 2 || renderer.Height != 4 {
+		t.Fatalf("expected renderer 2x4, got %dx%d", renderer.Width, renderer.Height)
+	}
+	if b := renderer.Image.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("expected renderer image 2x4, got %v", b)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present after rotation: %v", err)
+	}
+}
+
+func TestHandlerTouchCustomEventAndContext(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	renderer := NewRenderer(100, 50)
+	sender := &mockSender{}
+	h := NewHandler(fb, renderer, sender, zerolog.Nop())
+	h.SetActionEvent("kobo.tap")
+	h.SetActionContext(map[string]interface{}{"deviceId": "device-123", "screenId": "home"})
+
+	args := json.RawMessage(`{"components":[{"type":"button","x":0,"y":0,"width":10,"height":10,"action":{"type":"tap"}}]}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	h.HandleTouch(context.Background(), 1, 1)
+	params, ok := sender.params.(gateway.NodeEventParams)
+	if !ok {
+		t.Fatalf("expected NodeEventParams, got %T", sender.params)
+	}
+	if params.Event != "kobo.tap" {
+		t.Fatalf("expected kobo.tap event, got %s", params.Event)
+	}
+	payload := params.Payload.(map[string]interface{})
+	ctx, ok := payload["context"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected context in payload, got %v", payload)
+	}
+	if ctx["deviceId"] != "device-123" || ctx["screenId"] != "home" {
+		t.Fatalf("unexpected context: %v", ctx)
+	}
+}
+
+func TestHandlerTouchCarriesTraceIDOfPresentingInvoke(t *testing.T) {
+	sender := &mockSender{}
+	h := NewHandler(eink.NewFramebufferFromBuffer(100, 50), NewRenderer(100, 50), sender, zerolog.Nop())
+	args := json.RawMessage(`{"components":[{"type":"button","x":0,"y":0,"width":10,"height":10,"action":{"type":"tap"}}]}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args, TraceID: "trace-1"}); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	h.HandleTouch(context.Background(), 1, 1)
+	payload := sender.params.(gateway.NodeEventParams).Payload.(map[string]interface{})
+	if payload["traceId"] != "trace-1" {
+		t.Fatalf("expected action traced to the push, got %v", payload)
+	}
+
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	h.HandleTouch(context.Background(), 1, 1)
+	payload = sender.params.(gateway.NodeEventParams).Payload.(map[string]interface{})
+	if _, ok := payload["traceId"]; ok {
+		t.Fatalf("expected no trace id after an untraced present, got %v", payload)
+	}
+}
+
+func TestHandlerPushJSONLReportsProgress(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	renderer := NewRenderer(100, 50)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+
+	var messages []string
+	progress := func(ctx context.Context, value float64, message string) error {
+		messages = append(messages, message)
+		return nil
+	}
+	args, _ := json.Marshal(map[string]string{"jsonl": `{"type":"text","text":"hi"}`})
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.pushJSONL", Args: args, Progress: progress}); err != nil {
+		t.Fatalf("push jsonl: %v", err)
+	}
+	if len(messages) != 1 || messages[0] != "rendering" {
+		t.Fatalf("expected rendering progress, got %v", messages)
+	}
+}
+
+func TestHandlerDefersInvokeUntilReady(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	renderer := NewRenderer(100, 50)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+	h.HoldUntilReady()
+
+	fill := 40
+	args, _ := json.Marshal(map[string]interface{}{
+		"components": []map[string]interface{}{
+			{"type": "box", "x": 0, "y": 0, "width": 10, "height": 10, "style": map[string]interface{}{"fillGray": fill}},
+		},
+	})
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("invoke ran before ready: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if len(h.state.Components()) != 0 {
+		t.Fatalf("expected push deferred before ready")
+	}
+
+	h.MarkReady()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("deferred invoke: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("deferred invoke not executed after ready")
+	}
+	if got := renderer.Image.GrayAt(1, 1).Y; got != uint8(fill) {
+		t.Fatalf("expected deferred push rendered, got %d", got)
+	}
+}
+
+func TestDecodePresentArgs_TopLevelParams(t *testing.T) {
+	params := json.RawMessage(`{"id":"req","command":"canvas.present","region":{"x":5,"y":6,"width":10,"height":20},"waveform":"gc16"}`)
+	opts, err := decodePresentArgs(nil, params)
+	if err != nil {
+		t.Fatalf("decode present args: %v", err)
+	}
+	update := opts.update(true)
+	if update.Region != image.Rect(5, 6, 15, 26) {
+		t.Fatalf("unexpected region %v", update.Region)
+	}
+	if update.Waveform != eink.WaveformModeGC16 {
+		t.Fatalf("expected gc16 waveform, got %d", update.Waveform)
+	}
+
+	opts, err = decodePresentArgs(json.RawMessage(`{"waveform":"du"}`), params)
+	if err != nil {
+		t.Fatalf("decode present args: %v", err)
+	}
+	if opts.update(true).Waveform != eink.WaveformModeDU {
+		t.Fatalf("expected args to override top-level waveform")
+	}
+	if _, err := decodePresentArgs(json.RawMessage(`{"waveform":"sparkle"}`), nil); err == nil {
+		t.Fatalf("expected unknown waveform error")
+	}
+	if _, err := decodePresentArgs(json.RawMessage(`{"region":"top"}`), params); err == nil {
+		t.Fatalf("expected malformed args error")
+	}
+	if _, err := decodePresentArgs(json.RawMessage(`{"region":{"x":5,"y":6,"width":0,"height":20}}`), nil); err == nil {
+		t.Fatalf("expected empty region error")
+	}
+}
+
+func TestHandlerPresentClipsRegionToScreen(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	h := NewHandler(fb, NewRenderer(100, 50), nil, zerolog.Nop())
+	var updates []eink.Update
+	h.refreshFunc = func(update eink.Update) error {
+		updates = append(updates, update)
+		return nil
+	}
+	args := json.RawMessage(`{"region":{"x":80,"y":40,"width":50,"height":50}}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present", Args: args}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if len(updates) != 1 || updates[0].Region != image.Rect(80, 40, 100, 50) {
+		t.Fatalf("expected region clipped to the screen, got %+v", updates)
+	}
+	args = json.RawMessage(`{"region":{"x":200,"y":0,"width":10,"height":10}}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present", Args: args}); err == nil {
+		t.Fatalf("expected off-screen region error")
+	}
+}
+
+func TestHandlerPresentReadsTopLevelParams(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	renderer := NewRenderer(100, 50)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+	params := json.RawMessage(`{"waveform":"bogus"}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present", Params: params}); err == nil {
+		t.Fatalf("expected handler to read waveform from top-level params")
+	}
+}
+
+func TestHandlerLoggerForUsesRequestLogger(t *testing.T) {
+	h := NewHandler(eink.NewFramebufferFromBuffer(1, 1), NewRenderer(1, 1), nil, zerolog.Nop())
+	var buf bytes.Buffer
+	requestLogger := zerolog.New(&buf).With().Str("requestId", "req-1").Logger()
+	ctx := requestLogger.WithContext(context.Background())
+	h.loggerFor(ctx).Info().Msg("hello")
+	if !bytes.Contains(buf.Bytes(), []byte(`"requestId":"req-1"`)) {
+		t.Fatalf("expected request id in log, got %s", buf.String())
+	}
+	if h.loggerFor(context.Background()) != &h.logger {
+		t.Fatalf("expected handler logger without request context")
+	}
+}
+
+func TestPositionalArgs(t *testing.T) {
+	got := positionalArgs(json.RawMessage(`["abc",4,2]`), "data", "width", "height", "stride")
+	var blit blitArgs
+	if err := json.Unmarshal(got, &blit); err != nil {
+		t.Fatalf("unmarshal positional: %v", err)
+	}
+	if blit.Data != "abc" || blit.Width != 4 || blit.Height != 2 || blit.Stride != 0 {
+		t.Fatalf("unexpected positional decode: %+v", blit)
+	}
+	obj := json.RawMessage(`{"data":"x"}`)
+	if string(positionalArgs(obj, "data")) != string(obj) {
+		t.Fatalf("expected object args untouched")
+	}
+}
+
+func TestHandlerPushJSONLPositional(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	renderer := NewRenderer(100, 50)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+	args, _ := json.Marshal([]string{`{"type":"text","text":"hi"}`})
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.pushJSONL", Args: args}); err != nil {
+		t.Fatalf("push jsonl positional: %v", err)
+	}
+	if len(h.state.Components()) != 1 {
+		t.Fatalf("expected positional jsonl push applied")
+	}
+}
+
+func TestHandlerStateAfterPush(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	renderer := NewRenderer(100, 50)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+
+	args := json.RawMessage(`{"components":[{"type":"text","text":"a"},{"type":"box"}]}`)
+	for i := 0; i < 2; i++ {
+		if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	result, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.state"})
+	if err != nil {
+		t.Fatalf("state: %v", err)
+	}
+	state, ok := result.(DisplayState)
+	if !ok {
+		t.Fatalf("expected DisplayState, got %T", result)
+	}
+	want := DisplayState{Components: 4, Width: 100, Height: 50, LastRefresh: "fast", PartialRefreshes: 2, Ghosting: 2}
+	if state != want {
+		t.Fatalf("expected %+v, got %+v", want, state)
+	}
+
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	state = h.displayState()
+	if state.LastRefresh != "full" || state.PartialRefreshes != 0 {
+		t.Fatalf("expected full refresh to reset counter, got %+v", state)
+	}
+}
+
+func TestHandlerGhostingEstimate(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	h := NewHandler(fb, NewRenderer(100, 50), nil, zerolog.Nop())
+
+	if err := h.refresh(eink.Update{Fast: true}); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if err := h.refresh(eink.Update{Fast: true, Region: image.Rect(0, 0, 50, 50)}); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if err := h.refresh(eink.Update{Region: image.Rect(0, 0, 50, 50)}); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if got := h.displayState().Ghosting; got != 1.75 {
+		t.Fatalf("expected ghosting 1.75 weighted by area and mode, got %v", got)
+	}
+	if err := h.refresh(eink.Update{Full: true}); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if got := h.RenderHealth().Ghosting; got != 0 {
+		t.Fatalf("expected full refresh to reset ghosting, got %v", got)
+	}
+
+	h.SetDeghostThreshold(2)
+	var updates []eink.Update
+	h.refreshFunc = func(update eink.Update) error {
+		updates = append(updates, update)
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := h.refresh(eink.Update{Fast: true}); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+	}
+	if len(updates) != 3 || !updates[2].Full || updates[2].Waveform != eink.WaveformModeGC16 {
+		t.Fatalf("expected GC16 clean after crossing threshold, got %+v", updates)
+	}
+	if got := h.displayState().Ghosting; got != 0 {
+		t.Fatalf("expected clean to reset ghosting, got %v", got)
+	}
+}
+
+func TestHandlerShowSleepScreen(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	h := NewHandler(fb, NewRenderer(100, 50), nil, zerolog.Nop())
+	h.state.ApplyPush(A2UIPush{Components: []A2UIComponent{{Type: "text", Text: "live"}}})
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+
+	fill := uint8(0)
+	logo := image.NewGray(image.Rect(0, 0, 10, 10))
+	screen := SleepScreen{
+		Components: []A2UIComponent{{Type: "box", X: 0, Y: 0, Width: 20, Height: 20, Style: &A2UIStyle{FillGray: &fill}}},
+		Image:      logo,
+	}
+	if err := h.ShowSleepScreen(screen); err != nil {
+		t.Fatalf("show sleep screen: %v", err)
+	}
+	out, err := fb.ReadGray()
+	if err != nil {
+		t.Fatalf("read framebuffer: %v", err)
+	}
+	if out.GrayAt(10, 10).Y != 0 {
+		t.Fatalf("expected sleep components drawn, got %d", out.GrayAt(10, 10).Y)
+	}
+	if out.GrayAt(50, 25).Y != 0 || out.GrayAt(60, 25).Y == 0 {
+		t.Fatalf("expected sleep image centered")
+	}
+	if !h.lastUpdate.Full || h.lastUpdate.Waveform != eink.WaveformModeGC16 {
+		t.Fatalf("expected full GC16 refresh, got %+v", h.lastUpdate)
+	}
+	if components := h.state.Components(); len(components) != 1 || components[0].Text != "live" {
+		t.Fatalf("expected A2UI state preserved, got %+v", components)
+	}
+}
+
+func TestHandlerEraseRegion(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(20, 10)
+	renderer := NewRenderer(20, 10)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+	fill := json.RawMessage(`{"components":[{"type":"box","x":0,"y":0,"width":20,"height":10,"style":{"fillGray":50,"strokeGray":50}}]}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: fill}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	args := json.RawMessage(`{"x":2,"y":3,"width":4,"height":2,"gray":200}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.erase", Args: args}); err != nil {
+		t.Fatalf("erase: %v", err)
+	}
+	region := image.Rect(2, 3, 6, 5)
+	got, err := fb.ReadGray()
+	if err != nil {
+		t.Fatalf("read framebuffer: %v", err)
+	}
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			want := uint8(50)
+			if image.Pt(x, y).In(region) {
+				want = 200
+			}
+			if px := got.GrayAt(x, y).Y; px != want {
+				t.Fatalf("pixel (%d,%d): expected %d, got %d", x, y, want, px)
+			}
+		}
+	}
+	if h.lastUpdate.Full || h.lastUpdate.Region != region {
+		t.Fatalf("expected partial refresh of %v, got %+v", region, h.lastUpdate)
+	}
+	if len(h.state.Components()) != 1 {
+		t.Fatalf("expected A2UI state untouched")
+	}
+
+	bad := json.RawMessage(`{"x":18,"y":0,"width":5,"height":2}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.erase", Args: bad}); err == nil {
+		t.Fatalf("expected out-of-bounds erase error")
+	}
+}
+
+func TestHandlerA2UIPushRefreshHint(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	h := NewHandler(fb, NewRenderer(100, 50), nil, zerolog.Nop())
+	cases := []struct {
+		hint string
+		want eink.Update
+	}{
+		{hint: "", want: eink.Update{Fast: true}},
+		{hint: "fast", want: eink.Update{Fast: true}},
+		{hint: "full", want: eink.Update{Full: true, Waveform: eink.WaveformModeGC16}},
+		{hint: "auto", want: eink.Update{Waveform: eink.WaveformModeAuto}},
+	}
+	for _, tc := range cases {
+		args := json.RawMessage(`{"components":[{"type":"box","width":10,"height":10}],"refreshHint":"` + tc.hint + `"}`)
+		if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+			t.Fatalf("push with hint %q: %v", tc.hint, err)
+		}
+		if h.lastUpdate != tc.want {
+			t.Fatalf("hint %q: expected update %+v, got %+v", tc.hint, tc.want, h.lastUpdate)
+		}
+	}
+
+	args := json.RawMessage(`{"components":[{"type":"box"}],"refreshHint":"slow"}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err == nil {
+		t.Fatalf("expected error for unknown refresh hint")
+	}
+}
+
+func TestHandlerScreenSwitching(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	h := NewHandler(fb, NewRenderer(100, 50), nil, zerolog.Nop())
+	black, white := uint8(0), uint8(255)
+	h.state.SetScreen("home", []A2UIComponent{{Type: "box", X: 0, Y: 0, Width: 50, Height: 50, Style: &A2UIStyle{FillGray: &black}}})
+	settings := json.RawMessage(`{"screen":"settings","components":[{"type":"box","x":50,"y":0,"width":50,"height":50,"style":{"fillGray":0}}]}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: settings}); err != nil {
+		t.Fatalf("push screen: %v", err)
+	}
+	if h.lastRefresh != "" {
+		t.Fatalf("expected pushing a hidden screen not to present")
+	}
+
+	pixel := func(x, y int) uint8 {
+		t.Helper()
+		out, err := fb.ReadGray()
+		if err != nil {
+			t.Fatalf("read framebuffer: %v", err)
+		}
+		return out.GrayAt(x, y).Y
+	}
+	show := func(name string) {
+		t.Helper()
+		if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.screen.show", Args: json.RawMessage(`["` + name + `"]`)}); err != nil {
+			t.Fatalf("show %s: %v", name, err)
+		}
+	}
+
+	show("home")
+	if pixel(10, 10) != black || pixel(75, 10) != white {
+		t.Fatalf("expected home screen rendered")
+	}
+	show("settings")
+	if pixel(10, 10) != white || pixel(75, 10) != black {
+		t.Fatalf("expected settings screen rendered")
+	}
+	if state := h.displayState(); state.Screen != "settings" || state.Components != 1 {
+		t.Fatalf("expected settings reported in state, got %+v", state)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.screen.show", Args: json.RawMessage(`{"name":"detail"}`)}); err == nil {
+		t.Fatalf("expected error for unknown screen")
+	}
+}
+
+func TestHandlerScreenSlideTransition(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	h := NewHandler(fb, NewRenderer(100, 50), nil, zerolog.Nop())
+	black := uint8(0)
+	h.state.SetScreen("home", []A2UIComponent{{Type: "box", X: 0, Y: 0, Width: 100, Height: 50, Style: &A2UIStyle{FillGray: &black, StrokeGray: &black}}})
+	h.state.SetScreen("settings", nil)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.screen.show", Args: json.RawMessage(`["home"]`)}); err != nil {
+		t.Fatalf("show home: %v", err)
+	}
+
+	type frame struct {
+		update eink.Update
+		edge   int
+	}
+	frames := make(chan frame, 8)
+	h.refreshFunc = func(update eink.Update) error {
+		out, err := fb.ReadGray()
+		if err != nil {
+			return err
+		}
+		// The new, empty screen slides in from the right over the black one.
+		edge := 0
+		for edge < 100 && out.GrayAt(edge, 10).Y == black {
+			edge++
+		}
+		frames <- frame{update: update, edge: edge}
+		return nil
+	}
+	ticks := make(chan time.Time)
+	h.newTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() {}
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.screen.show", Args: json.RawMessage(`{"name":"settings","transition":"slide"}`)})
+		done <- err
+	}()
+
+	for _, want := range []int{75, 50, 25} {
+		got := <-frames
+		if !got.update.Fast || got.update.Region != image.Rect(0, 0, 100, 50) {
+			t.Fatalf("expected fast full-width transition frame, got %+v", got.update)
+		}
+		if got.edge != want {
+			t.Fatalf("expected new screen from x=%d, got x=%d", want, got.edge)
+		}
+		ticks <- time.Time{}
+	}
+	final := <-frames
+	if final.edge != 0 {
+		t.Fatalf("expected final frame to show the new screen, got edge %d", final.edge)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("show settings: %v", err)
+	}
+	if h.state.CurrentScreen() != "settings" {
+		t.Fatalf("expected settings screen current")
+	}
+}
+
+func TestHandlerRefreshIfPartialCleansOnceAfterReconnect(t *testing.T) {
+	h := NewHandler(eink.NewFramebufferFromBuffer(100, 50), NewRenderer(100, 50), nil, zerolog.Nop())
+	var updates []eink.Update
+	h.refreshFunc = func(update eink.Update) error {
+		updates = append(updates, update)
+		return nil
+	}
+	if refreshed, err := h.RefreshIfPartial(); err != nil || refreshed {
+		t.Fatalf("expected no refresh before any partial update, got %v, %v", refreshed, err)
+	}
+
+	// A burst of fast updates, then the connection drops and comes back.
+	for i := 0; i < 3; i++ {
+		if err := h.refresh(eink.Update{Fast: true}); err != nil {
+			t.Fatalf("fast refresh: %v", err)
+		}
+	}
+	updates = nil
+	for i := 0; i < 2; i++ {
+		if _, err := h.RefreshIfPartial(); err != nil {
+			t.Fatalf("refresh after reconnect: %v", err)
+		}
+	}
+	if len(updates) != 1 || !updates[0].Full || updates[0].Waveform != eink.WaveformModeGC16 {
+		t.Fatalf("expected one full GC16 refresh on reconnect, got %+v", updates)
+	}
+
+	// The wake path already cleaned the screen.
+	if err := h.refresh(eink.Update{Fast: true}); err != nil {
+		t.Fatalf("fast refresh: %v", err)
+	}
+	if err := h.FullRefresh(); err != nil {
+		t.Fatalf("wake refresh: %v", err)
+	}
+	updates = nil
+	if refreshed, err := h.RefreshIfPartial(); err != nil || refreshed || len(updates) != 0 {
+		t.Fatalf("expected no second refresh after the wake refresh, got %v, %v", refreshed, err)
+	}
+}
+
+func TestHandlerIdleRefreshFiresAtInterval(t *testing.T) {
+	h := NewHandler(eink.NewFramebufferFromBuffer(100, 50), NewRenderer(100, 50), nil, zerolog.Nop())
+	var clock atomic.Int64
+	clock.Store(time.Unix(1000, 0).UnixNano())
+	h.now = func() time.Time { return time.Unix(0, clock.Load()) }
+	advance := func(d time.Duration) time.Time { return time.Unix(0, clock.Add(int64(d))) }
+	refreshes := make(chan eink.Update, 4)
+	h.refreshFunc = func(update eink.Update) error {
+		refreshes <- update
+		return nil
+	}
+	ticks := make(chan time.Time)
+	periods := make(chan time.Duration, 1)
+	h.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		periods <- d
+		return ticks, func() {}
+	}
+
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	<-refreshes
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.RunIdleRefresh(ctx, time.Hour)
+		close(done)
+	}()
+	if period := <-periods; period < time.Hour || period > time.Hour+6*time.Minute {
+		t.Fatalf("expected an hourly period jittered by up to a tenth, got %s", period)
+	}
+
+	ticks <- advance(time.Hour)
+	if update := <-refreshes; !update.Full || update.Waveform != eink.WaveformModeGC16 {
+		t.Fatalf("expected a full GC16 refresh once idle for the interval, got %+v", update)
+	}
+
+	// The next tick comes too soon after that full refresh.
+	ticks <- advance(30 * time.Minute)
+	ticks <- advance(30 * time.Minute)
+	if update := <-refreshes; !update.Full {
+		t.Fatalf("expected a full refresh an interval after the last one, got %+v", update)
+	}
+	cancel()
+	<-done
+	if len(refreshes) != 0 {
+		t.Fatalf("expected no refresh on the early tick, got %d extra", len(refreshes))
+	}
+}
+
+func TestHandlerThrottlesRapidPushes(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	h := NewHandler(fb, NewRenderer(100, 50), nil, zerolog.Nop())
+	h.SetPresentInterval(time.Second)
+	clock := time.Unix(1000, 0)
+	h.now = func() time.Time { return clock }
+	var presents []int
+	h.refreshFunc = func(eink.Update) error {
+		presents = append(presents, len(h.state.Components()))
+		return nil
+	}
+	var scheduled []time.Duration
+	var flush func()
+	h.afterFunc = func(d time.Duration, f func()) {
+		scheduled = append(scheduled, d)
+		flush = f
+	}
+
+	// Each push adds a component, so the component count identifies the state presented.
+	for i := 1; i <= 5; i++ {
+		args := json.RawMessage(fmt.Sprintf(`{"components":[{"type":"text","text":"tick %d"}]}`, i))
+		if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+		clock = clock.Add(100 * time.Millisecond)
+	}
+	if len(presents) != 1 || presents[0] != 1 {
+		t.Fatalf("expected only the first push presented immediately, got %v", presents)
+	}
+	if len(scheduled) != 1 || scheduled[0] != 900*time.Millisecond {
+		t.Fatalf("expected one present scheduled at the throttle boundary, got %v", scheduled)
+	}
+
+	clock = clock.Add(400 * time.Millisecond)
+	flush()
+	if len(presents) != 2 || presents[1] != 5 {
+		t.Fatalf("expected the coalesced present to show the final state, got %v", presents)
+	}
+
+	clock = clock.Add(time.Second)
+	args := json.RawMessage(`{"components":[{"type":"text","text":"later"}]}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+		t.Fatalf("push after interval: %v", err)
+	}
+	if len(presents) != 3 || len(scheduled) != 1 {
+		t.Fatalf("expected a push after the interval to present immediately, got %v", presents)
+	}
+}
+
+func TestHandlerSnapshotDoesNotBlockPresent(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(20, 20)
+	h := NewHandler(fb, NewRenderer(20, 20), nil, zerolog.Nop())
+	black := uint8(0)
+	h.state.ApplyPush(A2UIPush{Components: []A2UIComponent{{Type: "box", Width: 20, Height: 20, Style: &A2UIStyle{FillGray: &black, StrokeGray: &black}}}})
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+
+	encoding := make(chan struct{})
+	release := make(chan struct{})
+	h.encodeSnapshot = func(img *image.Gray, maxBytes int) (string, int, error) {
+		close(encoding)
+		<-release
+		return SnapshotBase64Limited(img, maxBytes)
+	}
+	type snapshotResult struct {
+		out interface{}
+		err error
+	}
+	snapshots := make(chan snapshotResult, 1)
+	go func() {
+		out, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.snapshot"})
+		snapshots <- snapshotResult{out: out, err: err}
+	}()
+	<-encoding
+
+	presented := make(chan error, 1)
+	go func() {
+		_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: json.RawMessage(`{"replace":true,"components":[{"type":"text","text":"hi"}]}`)})
+		presented <- err
+	}()
+	select {
+	case err := <-presented:
+		if err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("present blocked behind snapshot encoding")
+	}
+	close(release)
+
+	result := <-snapshots
+	if result.err != nil {
+		t.Fatalf("snapshot: %v", result.err)
+	}
+	encoded, _ := result.out.(string)
+	decoded, err := png.Decode(base64.NewDecoder(base64.StdEncoding, strings.NewReader(encoded)))
+	if err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if gray, _ := decoded.(*image.Gray); gray == nil || gray.GrayAt(10, 10).Y != black {
+		t.Fatalf("expected snapshot of the frame shown when it was requested")
+	}
+}
+
+func TestHandlerImageRejectsHugeDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	// Claim 60000x60000 in the IHDR chunk, which follows the 8-byte
+	// signature, and fix up its CRC.
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 60000)
+	binary.BigEndian.PutUint32(data[20:], 60000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	h := NewHandler(eink.NewFramebufferFromBuffer(20, 20), NewRenderer(20, 20), nil, zerolog.Nop())
+	args, _ := json.Marshal(map[string]interface{}{"data": base64.StdEncoding.EncodeToString(data)})
+	_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.image", Args: args})
+	if err == nil || !strings.Contains(err.Error(), "60000x60000") {
+		t.Fatalf("expected oversized image rejected before decoding, got %v", err)
+	}
+}
+
+func TestHandlerImageLetterboxed(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(20, 20)
+	h := NewHandler(fb, NewRenderer(20, 20), nil, zerolog.Nop())
+	var updates []eink.Update
+	h.refreshFunc = func(update eink.Update) error {
+		updates = append(updates, update)
+		return nil
+	}
+	// A 2:1 image, black on the left and white on the right, should fill
+	// the width and be centered vertically between gray bars.
+	src := image.NewGray(image.Rect(0, 0, 4, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	for y := 0; y < 2; y++ {
+		src.SetGray(0, y, color.Gray{Y: 0})
+		src.SetGray(1, y, color.Gray{Y: 0})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	args, _ := json.Marshal(map[string]interface{}{"data": base64.StdEncoding.EncodeToString(buf.Bytes()), "background": 128})
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.image", Args: args}); err != nil {
+		t.Fatalf("image: %v", err)
+	}
+	out, err := fb.ReadGray()
+	if err != nil {
+		t.Fatalf("read framebuffer: %v", err)
+	}
+	for _, tc := range []struct {
+		x, y int
+		want uint8
+	}{
+		{10, 2, 128}, {10, 17, 128},
+		{2, 6, 0}, {2, 13, 0},
+		{17, 6, 255}, {17, 13, 255},
+	} {
+		if got := out.GrayAt(tc.x, tc.y).Y; got != tc.want {
+			t.Fatalf("pixel (%d,%d) = %d, want %d", tc.x, tc.y, got, tc.want)
+		}
+	}
+	if len(updates) != 1 || !updates[0].Full || updates[0].Waveform != eink.WaveformModeGC16 {
+		t.Fatalf("expected one full GC16 refresh, got %+v", updates)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.image", Args: json.RawMessage(`{"data":"bm90IGFuIGltYWdl"}`)}); err == nil {
+		t.Fatalf("expected error for undecodable image")
+	}
+}
+
+func TestHandlerPushJSONLStreamPresentsOnFlush(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 100)
+	h := NewHandler(fb, NewRenderer(100, 100), nil, zerolog.Nop())
+	var presents []eink.Update
+	h.refreshFunc = func(update eink.Update) error {
+		presents = append(presents, update)
+		return nil
+	}
+	invoke := func(args string) {
+		t.Helper()
+		if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.pushJSONL", Args: json.RawMessage(args)}); err != nil {
+			t.Fatalf("pushJSONL %s: %v", args, err)
+		}
+	}
+
+	invoke(`{"jsonl":"{\"replace\":true,\"refreshHint\":\"full\",\"components\":[{\"type\":\"text\",\"text\":\"a\"}]}","stream":true}`)
+	invoke(`{"jsonl":"{\"components\":[{\"type\":\"text\",\"text\":\"b\"}]}\n{\"components\":[{\"type\":\"text\",\"text\":\"c\"}]}","stream":true}`)
+	if len(presents) != 0 {
+		t.Fatalf("expected streamed chunks not to present, got %d presents", len(presents))
+	}
+	if got := len(h.state.Components()); got != 3 {
+		t.Fatalf("expected streamed chunks to accumulate 3 components, got %d", got)
+	}
+	invoke(`{"jsonl":"{\"components\":[{\"type\":\"text\",\"text\":\"d\"}]}","stream":true,"flush":true}`)
+	if len(presents) != 1 || len(h.state.Components()) != 4 {
+		t.Fatalf("expected one present of 4 components on flush, got %d presents of %d", len(presents), len(h.state.Components()))
+	}
+	if !presents[0].Full {
+		t.Fatalf("expected refresh hint from an earlier chunk to apply on flush, got %+v", presents[0])
+	}
+
+	invoke(`{"flush":true}`)
+	if len(presents) != 2 || presents[1].Full {
+		t.Fatalf("expected bare flush to present with the default hint, got %+v", presents)
+	}
+}
+
+func TestHandlerMeasureText(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 100)
+	renderer := NewRenderer(100, 100)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+	for _, tc := range []struct {
+		args string
+		font string
+		size float64
+	}{
+		{args: `{"text":"Hello, Kobo"}`},
+		{args: `{"text":"Hello, Kobo","font":"mono","fontSize":20}`, font: FontMono, size: 20},
+		{args: `["Hello, Kobo","ui"]`, font: FontUI},
+	} {
+		out, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.measureText", Args: json.RawMessage(tc.args)})
+		if err != nil {
+			t.Fatalf("measureText %s: %v", tc.args, err)
+		}
+		got, ok := out.(TextMetrics)
+		if !ok {
+			t.Fatalf("expected TextMetrics, got %T", out)
+		}
+		face := renderer.faceFor(tc.font, tc.size)
+		want := font.MeasureString(face, "Hello, Kobo").Ceil()
+		if got.Width != want || got.Width != renderer.MeasureString("Hello, Kobo", tc.font, tc.size) {
+			t.Fatalf("measureText %s width = %d, want %d", tc.args, got.Width, want)
+		}
+		if got.Height != face.Metrics().Height.Ceil() || got.Ascent != face.Metrics().Ascent.Ceil() {
+			t.Fatalf("measureText %s = %+v, want face metrics %+v", tc.args, got, face.Metrics())
+		}
+	}
+}
+
+func TestHandlerFlashFullRefresh(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(20, 20)
+	h := NewHandler(fb, NewRenderer(20, 20), nil, zerolog.Nop())
+	h.SetFlashFullRefresh(true)
+	h.SetDeghostThreshold(1)
+	type call struct {
+		update eink.Update
+		center uint8
+	}
+	var calls []call
+	h.refreshFunc = func(update eink.Update) error {
+		out, err := fb.ReadGray()
+		if err != nil {
+			return err
+		}
+		calls = append(calls, call{update: update, center: out.GrayAt(10, 10).Y})
+		return nil
+	}
+	// A fast push covering the whole screen reaches the deghost threshold,
+	// whose full refresh is preceded by a flash to black.
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: json.RawMessage(`{"components":[{"type":"text","text":"hi"}]}`)}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("expected present, flash, and content refreshes, got %+v", calls)
+	}
+	flash, content := calls[1], calls[2]
+	if !flash.update.Full || flash.center != 0 {
+		t.Fatalf("expected a full refresh of a black screen first, got %+v", flash)
+	}
+	if !content.update.Full || content.update.Waveform != eink.WaveformModeGC16 || content.center != 255 {
+		t.Fatalf("expected the content restored for the second full refresh, got %+v", content)
+	}
+
+	calls = nil
+	h.SetFlashFullRefresh(false)
+	if err := h.FullRefresh(); err != nil {
+		t.Fatalf("full refresh: %v", err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expected a single refresh with flashing off, got %+v", calls)
+	}
+}
+
+func TestHandlerSlowRenderEmitsWarning(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(20, 20)
+	sender := &mockSender{}
+	h := NewHandler(fb, NewRenderer(20, 20), sender, zerolog.Nop())
+	h.SetRenderBudget(100 * time.Millisecond)
+	clock := time.Unix(0, 0)
+	step := 50 * time.Millisecond
+	h.now = func() time.Time {
+		clock = clock.Add(step)
+		return clock
+	}
+	h.state.ApplyPush(A2UIPush{Components: []A2UIComponent{{Type: "box"}, {Type: "text", Text: "hi"}}})
+
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if sender.called {
+		t.Fatalf("unexpected warning within budget")
+	}
+
+	step = 250 * time.Millisecond
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if !sender.called || sender.method != "node.event" {
+		t.Fatalf("expected slow render node.event")
+	}
+	params, ok := sender.params.(gateway.NodeEventParams)
+	if !ok || params.Event != slowRenderEvent {
+		t.Fatalf("expected %s event, got %+v", slowRenderEvent, sender.params)
+	}
+	payload := params.Payload.(map[string]interface{})
+	if payload["durationMs"] != int64(250) || payload["components"] != 2 {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+}
+
+func TestHandlerRenderingBlocksSuspendDuringPresent(t *testing.T) {
+	h := NewHandler(eink.NewFramebufferFromBuffer(20, 20), NewRenderer(20, 20), nil, zerolog.Nop())
+	var rendering atomic.Bool
+	h.SetRendering(rendering.Store)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h.refreshFunc = func(eink.Update) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	presented := make(chan error, 1)
+	go func() {
+		_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"})
+		presented <- err
+	}()
+	<-started
+	if !rendering.Load() {
+		t.Fatalf("expected suspend blocked while the present runs")
+	}
+	close(release)
+	if err := <-presented; err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if rendering.Load() {
+		t.Fatalf("expected suspend allowed after the present")
+	}
+}
+
+func TestHandlerRenderWatchdogFiresOnWedgedRefresh(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(20, 20)
+	sender := &mockSender{}
+	h := NewHandler(fb, NewRenderer(20, 20), sender, zerolog.Nop())
+	release := make(chan struct{})
+	h.refreshFunc = func(eink.Update) error {
+		<-release
+		return nil
+	}
+	stalled := make(chan struct{})
+	h.SetRenderWatchdog(20*time.Millisecond, func() { close(stalled) })
+
+	presented := make(chan error, 1)
+	go func() {
+		_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"})
+		presented <- err
+	}()
+	select {
+	case <-stalled:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("watchdog did not fire")
+	}
+	params, ok := sender.params.(gateway.NodeEventParams)
+	if !ok || params.Event != stalledRenderEvent {
+		t.Fatalf("expected %s event, got %+v", stalledRenderEvent, sender.params)
+	}
+	if health := h.RenderHealth(); !health.Degraded || health.Stalls != 1 || health.LastStallMs == 0 {
+		t.Fatalf("expected degraded health, got %+v", health)
+	}
+
+	replacement := eink.NewFramebufferFromBuffer(20, 20)
+	h.ReplaceFramebuffer(replacement)
+	close(release)
+	if err := <-presented; err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if health := h.RenderHealth(); health.Degraded || health.Stalls != 1 {
+		t.Fatalf("expected recovery after present completed, got %+v", health)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if h.fb != replacement {
+		t.Fatalf("expected replacement framebuffer to be adopted")
+	}
+}
+
+func TestHandlerRenderWatchdogReopensOncePerStall(t *testing.T) {
+	h := NewHandler(eink.NewFramebufferFromBuffer(20, 20), NewRenderer(20, 20), &mockSender{}, zerolog.Nop())
+	release := make(chan struct{})
+	h.refreshFunc = func(eink.Update) error {
+		<-release
+		return nil
+	}
+	var stalls atomic.Int32
+	reopened := make(chan struct{}, 4)
+	h.SetRenderWatchdog(20*time.Millisecond, func() {
+		stalls.Add(1)
+		h.ReplaceFramebuffer(eink.NewFramebufferFromBuffer(20, 20))
+		reopened <- struct{}{}
+	})
+
+	// Presents queue behind the wedged one.
+	presented := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"})
+			presented <- err
+		}()
+	}
+	<-reopened
+	time.Sleep(100 * time.Millisecond)
+	if got := stalls.Load(); got != 1 {
+		t.Fatalf("expected one reopen for the wedged present, got %d", got)
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-presented; err != nil {
+			t.Fatalf("present: %v", err)
+		}
+	}
+
+	superseded := eink.NewFramebufferFromBuffer(20, 20)
+	h.ReplaceFramebuffer(superseded)
+	h.ReplaceFramebuffer(eink.NewFramebufferFromBuffer(20, 20))
+	if err := superseded.WriteGray(image.NewGray(image.Rect(0, 0, 20, 20))); err == nil {
+		t.Fatalf("expected a superseded pending framebuffer to be closed")
+	}
+}
+
+type eventSender struct {
+	events chan gateway.NodeEventParams
+}
+
+func (s *eventSender) SendEvent(ctx context.Context, method string, params interface{}) error {
+	s.events <- params.(gateway.NodeEventParams)
+	return nil
+}
+
+type failingSender struct{}
+
+func (failingSender) SendEvent(ctx context.Context, method string, params interface{}) error {
+	return errors.New("not connected")
+}
+
+func TestHandlerActionFailureNotice(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(200, 100)
+	h := NewHandler(fb, NewRenderer(200, 100), failingSender{}, zerolog.Nop())
+	h.SetActionFailureNotice(2 * time.Second)
+	var scheduled []time.Duration
+	var clear func()
+	h.afterFunc = func(d time.Duration, f func()) {
+		scheduled = append(scheduled, d)
+		clear = f
+	}
+	h.state.ApplyPush(A2UIPush{Components: []A2UIComponent{{Type: "button", Width: 200, Height: 100, Action: &A2UIAction{Type: "tap"}}}})
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+
+	h.HandleTouch(context.Background(), 10, 10)
+	if h.overlay.Empty() {
+		t.Fatalf("expected no connection banner after failed send")
+	}
+	if len(scheduled) != 1 || scheduled[0] != 2*time.Second {
+		t.Fatalf("expected banner cleared after the notice duration, got %v", scheduled)
+	}
+	// Another failed tap while the banner is up is not shown again.
+	h.HandleTouchRelease()
+	h.HandleTouch(context.Background(), 10, 10)
+	if len(scheduled) != 1 {
+		t.Fatalf("expected failures debounced while the notice shows, got %v", scheduled)
+	}
+	// Clearing the notice leaves the sleep countdown up.
+	if err := h.ShowSleepCountdown(3 * time.Second); err != nil {
+		t.Fatalf("show countdown: %v", err)
+	}
+	clear()
+	if h.overlay.Empty() || h.overlayText[overlayCountdown] == "" {
+		t.Fatalf("expected countdown kept after the notice cleared")
+	}
+	if err := h.ClearSleepCountdown(); err != nil {
+		t.Fatalf("clear countdown: %v", err)
+	}
+	if !h.overlay.Empty() {
+		t.Fatalf("expected banner cleared")
+	}
+}
+
+func TestHandlerKeyRepeatWhileHeld(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	sender := &eventSender{events: make(chan gateway.NodeEventParams, 16)}
+	h := NewHandler(fb, NewRenderer(100, 50), sender, zerolog.Nop())
+	h.SetKeyRepeat(400*time.Millisecond, 50*time.Millisecond)
+	type fakeTicker struct {
+		every   time.Duration
+		ticks   chan time.Time
+		stopped chan struct{}
+	}
+	tickers := make(chan fakeTicker, 4)
+	h.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		ticker := fakeTicker{every: d, ticks: make(chan time.Time), stopped: make(chan struct{})}
+		tickers <- ticker
+		return ticker.ticks, func() { close(ticker.stopped) }
+	}
+	args := json.RawMessage(`{"components":[{"type":"button","x":0,"y":0,"width":40,"height":20,"action":{"type":"key","payload":{"key":"Backspace"},"repeat":true}}]}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	nextEvent := func() map[string]interface{} {
+		t.Helper()
+		select {
+		case params := <-sender.events:
+			return params.Payload.(map[string]interface{})
+		case <-time.After(time.Second):
+			t.Fatalf("expected action event")
+		}
+		return nil
+	}
+	nextTicker := func(every time.Duration) fakeTicker {
+		t.Helper()
+		select {
+		case ticker := <-tickers:
+			if ticker.every != every {
+				t.Fatalf("expected %v ticker, got %v", every, ticker.every)
+			}
+			return ticker
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v ticker", every)
+		}
+		return fakeTicker{}
+	}
+
+	h.HandleTouch(context.Background(), 10, 10)
+	if payload := nextEvent(); payload["type"] != "key" || payload["repeat"] != nil {
+		t.Fatalf("expected initial key action, got %+v", payload)
+	}
+	delay := nextTicker(400 * time.Millisecond)
+	// Further reports from the same held touch do not send more actions.
+	h.HandleTouch(context.Background(), 11, 10)
+	select {
+	case params := <-sender.events:
+		t.Fatalf("unexpected action before repeat delay: %+v", params)
+	default:
+	}
+
+	delay.ticks <- time.Now()
+	repeat := nextTicker(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if payload := nextEvent(); payload["type"] != "key" || payload["repeat"] != true {
+			t.Fatalf("expected repeated key action, got %+v", payload)
+		}
+		if i < 2 {
+			repeat.ticks <- time.Now()
+		}
+	}
+
+	h.HandleTouchRelease()
+	select {
+	case <-repeat.stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected repeat to stop on release")
+	}
+	select {
+	case params := <-sender.events:
+		t.Fatalf("unexpected action after release: %+v", params)
+	default:
+	}
+}
+
+func TestHandlerMarqueeAdvancesAndWraps(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	renderer := NewRenderer(100, 50)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+	ticks := make(chan time.Time)
+	stopped := make(chan struct{})
+	h.newTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() { close(stopped) }
+	}
+	args := json.RawMessage(`{"components":[{"id":"ticker","type":"text","text":"ABCDEFGHIJ","width":40,"height":20,"marquee":true}]}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.marquee.start", Args: json.RawMessage(`{"id":"ticker"}`)}); err != nil {
+		t.Fatalf("start marquee: %v", err)
+	}
+	target, ok := renderer.Marquee("ticker")
+	if !ok {
+		t.Fatalf("expected overflowing text registered as marquee")
+	}
+	want := 0
+	for i := 0; i < 12; i++ {
+		ticks <- time.Time{}
+		want = (want + marqueeStep) % target.Period
+		waitForMarqueeOffset(t, h, "ticker", want)
+	}
+	if want >= marqueeStep {
+		t.Fatalf("expected offset to wrap within 12 ticks, got %d (period %d)", want, target.Period)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.marquee.stop", Args: json.RawMessage(`["ticker"]`)}); err != nil {
+		t.Fatalf("stop marquee: %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected marquee ticker stopped")
+	}
+}
+
+func TestHandlerCloseStopsMarqueesAndRepeats(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(100, 50)
+	sender := &eventSender{events: make(chan gateway.NodeEventParams, 16)}
+	h := NewHandler(fb, NewRenderer(100, 50), sender, zerolog.Nop())
+	stopped := make(chan time.Duration, 4)
+	h.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		return make(chan time.Time), func() { stopped <- d }
+	}
+	h.SetKeyRepeat(400*time.Millisecond, 50*time.Millisecond)
+	args := json.RawMessage(`{"components":[{"id":"ticker","type":"text","text":"ABCDEFGHIJ","x":0,"y":0,"width":40,"height":20,"marquee":true},{"type":"button","x":0,"y":30,"width":40,"height":20,"action":{"type":"key","payload":{"key":"Backspace"},"repeat":true}}]}`)
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.a2ui.push", Args: args}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.marquee.start", Args: json.RawMessage(`{"id":"ticker","intervalMs":100}`)}); err != nil {
+		t.Fatalf("start marquee: %v", err)
+	}
+	h.HandleTouch(context.Background(), 10, 40)
+	<-sender.events
+
+	h.Close()
+	got := map[time.Duration]bool{}
+	for len(got) < 2 {
+		select {
+		case d := <-stopped:
+			got[d] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected marquee and repeat tickers stopped, got %v", got)
+		}
+	}
+	if !got[100*time.Millisecond] || !got[400*time.Millisecond] {
+		t.Fatalf("expected marquee and repeat tickers stopped, got %v", got)
+	}
+}
+
+func waitForMarqueeOffset(t *testing.T, h *Handler, id string, offset int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		h.renderMu.RLock()
+		got := h.renderer.MarqueeOffset(id)
+		h.renderMu.RUnlock()
+		if got == offset {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("marquee %s did not reach offset %d", id, offset)
+}
+
+func TestHandlerSleepCountdownOverlay(t *testing.T) {
+	fb := eink.NewFramebufferFromBuffer(200, 100)
+	renderer := NewRenderer(200, 100)
+	h := NewHandler(fb, renderer, nil, zerolog.Nop())
+	fill := uint8(100)
+	h.state.ApplyPush(A2UIPush{Components: []A2UIComponent{{Type: "box", Style: &A2UIStyle{FillGray: &fill}}}})
+	if _, err := h.HandleInvokeRequest(context.Background(), InvokeRequest{Command: "canvas.present"}); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+
+	if err := h.ShowSleepCountdown(2500 * time.Millisecond); err != nil {
+		t.Fatalf("show countdown: %v", err)
+	}
+	overlay := h.overlay
+	if overlay.Empty() || overlay.Max.Y > 100 {
+		t.Fatalf("expected overlay near bottom of screen, got %v", overlay)
+	}
+	img, err := fb.ReadGray()
+	if err != nil {
+		t.Fatalf("read fb: %v", err)
+	}
+	center := image.Pt(overlay.Min.X+1, overlay.Min.Y+overlay.Dy()/2)
+	if got := img.GrayAt(center.X, center.Y).Y; got != renderer.Theme.BackgroundGray {
+		t.Fatalf("expected overlay background in framebuffer, got %d", got)
+	}
+
+	if err := h.ClearSleepCountdown(); err != nil {
+		t.Fatalf("clear countdown: %v", err)
+	}
+	img, _ = fb.ReadGray()
+	if got := img.GrayAt(center.X, center.Y).Y; got != fill {
+		t.Fatalf("expected components restored under overlay, got %d", got)
+	}
+	if !h.overlay.Empty() {
+		t.Fatalf("expected overlay cleared")
+	}
+}
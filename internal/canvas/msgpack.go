@@ -0,0 +1,210 @@
+package canvas
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// MsgpackCap is advertised in the node caps so the gateway may send
+// canvas.a2ui.push args as {"msgpack": "<base64>"}, the push encoded as
+// MessagePack, which is more compact than JSON over a weak link.
+const MsgpackCap = "a2ui.msgpack"
+
+// maxMsgpackDepth bounds nesting, which in a push follows the component
+// tree.
+const maxMsgpackDepth = 64
+
+var errMsgpackTruncated = errors.New("msgpack: truncated payload")
+
+// DecodeA2UIMsgpack decodes a MessagePack push into the same A2UIPush as
+// its JSON form. Maps must have string keys.
+func DecodeA2UIMsgpack(data []byte, palette Palette) (A2UIPush, error) {
+	d := msgpackDecoder{data: data}
+	value, err := d.value(0)
+	if err != nil {
+		return A2UIPush{}, err
+	}
+	if len(d.data) > 0 {
+		return A2UIPush{}, fmt.Errorf("msgpack: %d trailing bytes", len(d.data))
+	}
+	// Going through JSON keeps one set of field names, palette names, and
+	// validation for both encodings.
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return A2UIPush{}, err
+	}
+	return DecodeA2UIPush(encoded, palette)
+}
+
+// decodePushArgs decodes canvas.a2ui.push args in either encoding.
+func decodePushArgs(args json.RawMessage, palette Palette) (A2UIPush, error) {
+	var packed struct {
+		Msgpack []byte `json:"msgpack"`
+	}
+	if err := json.Unmarshal(args, &packed); err == nil && len(packed.Msgpack) > 0 {
+		return DecodeA2UIMsgpack(packed.Msgpack, palette)
+	}
+	return DecodeA2UIPush(args, palette)
+}
+
+type msgpackDecoder struct {
+	data []byte
+}
+
+func (d *msgpackDecoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data) {
+		return nil, errMsgpackTruncated
+	}
+	out := d.data[:n]
+	d.data = d.data[n:]
+	return out, nil
+}
+
+// length reads a size field of n bytes.
+func (d *msgpackDecoder) length(n int) (int, error) {
+	b, err := d.take(n)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, c := range b {
+		size = size<<8 | uint64(c)
+	}
+	// Every element takes at least a byte, so a larger size is a lie that
+	// would only cost an allocation.
+	if size > uint64(len(d.data)) {
+		return 0, errMsgpackTruncated
+	}
+	return int(size), nil
+}
+
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack: nested too deeply")
+	}
+	b, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+	tag := b[0]
+	switch {
+	case tag <= 0x7f:
+		return int64(tag), nil
+	case tag >= 0xe0:
+		return int64(int8(tag)), nil
+	case tag&0xf0 == 0x80:
+		return d.mapOf(int(tag&0x0f), depth)
+	case tag&0xf0 == 0x90:
+		return d.arrayOf(int(tag&0x0f), depth)
+	case tag&0xe0 == 0xa0:
+		return d.str(int(tag & 0x1f))
+	}
+	switch tag {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(1 << (tag - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.take(n)
+	case 0xca:
+		raw, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), nil
+	case 0xcb:
+		raw, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		raw, err := d.take(1 << (tag - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		for _, c := range raw {
+			n = n<<8 | uint64(c)
+		}
+		return n, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (tag - 0xd0)
+		raw, err := d.take(size)
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		for _, c := range raw {
+			n = n<<8 | uint64(c)
+		}
+		// Sign-extend from the encoded width.
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (tag - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(n)
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (tag - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (tag - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(n, depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", tag)
+}
+
+func (d *msgpackDecoder) str(n int) (string, error) {
+	b, err := d.take(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) arrayOf(n, depth int) ([]interface{}, error) {
+	out := make([]interface{}, 0, min(n, len(d.data)))
+	for i := 0; i < n; i++ {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (d *msgpackDecoder) mapOf(n, depth int) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, min(n, len(d.data)))
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map key %v is not a string", k)
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = v
+	}
+	return out, nil
+}
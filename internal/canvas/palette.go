@@ -0,0 +1,105 @@
+package canvas
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Semantic palette names style grays may use instead of numbers, so agents
+// need not know the grays a deployment has tuned for its panel.
+const (
+	PaletteBackground = "background"
+	PaletteSurface    = "surface"
+	PaletteBorder     = "border"
+	PaletteText       = "text"
+	PaletteAccent     = "accent"
+)
+
+// Palette maps the names style grays may use to grays.
+type Palette map[string]uint8
+
+// NewPalette returns the default palette, which follows the default theme
+// with a black accent, with grays overriding or adding names.
+func NewPalette(grays map[string]uint8) Palette {
+	theme := DefaultTheme()
+	palette := Palette{
+		PaletteBackground: theme.BackgroundGray,
+		PaletteSurface:    theme.FillGray,
+		PaletteBorder:     theme.StrokeGray,
+		PaletteText:       theme.TextGray,
+		PaletteAccent:     0,
+	}
+	for name, gray := range grays {
+		palette[name] = gray
+	}
+	return palette
+}
+
+var errUnknownPaletteColor = errors.New("unknown palette color")
+
+// UnmarshalJSON accepts a palette name in place of a number for any gray,
+// e.g. "fillGray": "surface", leaving resolvePalette to look it up.
+func (s *A2UIStyle) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	for key, value := range fields {
+		var name string
+		if s.grayField(key) == nil || json.Unmarshal(value, &name) != nil {
+			continue
+		}
+		names[key] = name
+		delete(fields, key)
+	}
+	numeric, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	type plain A2UIStyle
+	if err := json.Unmarshal(numeric, (*plain)(s)); err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		s.names = names
+	}
+	return nil
+}
+
+func (s *A2UIStyle) grayField(key string) **uint8 {
+	switch key {
+	case "fillGray":
+		return &s.FillGray
+	case "strokeGray":
+		return &s.StrokeGray
+	case "textGray":
+		return &s.TextGray
+	case "rowGray":
+		return &s.RowGray
+	case "altRowGray":
+		return &s.AltRowGray
+	}
+	return nil
+}
+
+// resolvePalette sets the grays given by palette name in components' styles.
+func resolvePalette(components []A2UIComponent, palette Palette) error {
+	for _, comp := range components {
+		if style := comp.Style; style != nil {
+			for key, name := range style.names {
+				gray, ok := palette[name]
+				if !ok {
+					return fmt.Errorf("%s: %w %q", key, errUnknownPaletteColor, name)
+				}
+				*style.grayField(key) = &gray
+			}
+			style.names = nil
+		}
+		if err := resolvePalette(comp.Children, palette); err != nil {
+			return err
+		}
+	}
+	return nil
+}
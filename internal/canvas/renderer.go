@@ -1,26 +1,91 @@
 package canvas
 
 import (
+	"cmp"
 	"image"
 	"image/color"
 	"image/draw"
+	"math"
+	"slices"
 
+	xdraw "golang.org/x/image/draw"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/f64"
 	"golang.org/x/image/math/fixed"
 )
 
+const (
+	StrokeSolid  = "solid"
+	StrokeDashed = "dashed"
+	StrokeDotted = "dotted"
+)
+
+const (
+	dashLength = 4
+	dashGap    = 2
+)
+
+const marqueeGap = 24
+
+// defaultAltRowGray shades the odd rows of a striped list when no style is set.
+const defaultAltRowGray = 224
+
+type MarqueeTarget struct {
+	ID     string
+	Rect   image.Rectangle
+	Period int
+}
+
 type HitTarget struct {
 	Rect   image.Rectangle
 	Action A2UIAction
 }
 
+type Theme struct {
+	BackgroundGray     uint8 `json:"backgroundGray"`
+	FillGray           uint8 `json:"fillGray"`
+	StrokeGray         uint8 `json:"strokeGray"`
+	TextGray           uint8 `json:"textGray"`
+	StrokeWidth        int   `json:"strokeWidth"`
+	Padding            int   `json:"padding"`
+	DisabledFillGray   uint8 `json:"disabledFillGray"`
+	DisabledStrokeGray uint8 `json:"disabledStrokeGray"`
+	// AccentGray, if set, replaces StrokeGray for buttons and badges.
+	AccentGray *uint8 `json:"accentGray,omitempty"`
+	Font       string `json:"font,omitempty"`
+}
+
+func DefaultTheme() Theme {
+	return Theme{
+		BackgroundGray:     255,
+		FillGray:           230,
+		StrokeGray:         80,
+		TextGray:           20,
+		StrokeWidth:        1,
+		Padding:            2,
+		DisabledFillGray:   245,
+		DisabledStrokeGray: 170,
+	}
+}
+
 type Renderer struct {
 	Width      int
 	Height     int
 	Image      *image.Gray
 	HitTargets []HitTarget
+	Marquees   []MarqueeTarget
+	Theme      Theme
+	Logo       image.Image
 	face       font.Face
+	offsets    map[string]int
+	sorted     []A2UIComponent
+
+	// Dither draws image components with Floyd-Steinberg error diffusion
+	// down to DitherLevels grays (default 16, what the panel can show), so
+	// photos do not band.
+	Dither       bool
+	DitherLevels int
 }
 
 func NewRenderer(width, height int) *Renderer {
@@ -29,20 +94,64 @@ func NewRenderer(width, height int) *Renderer {
 		Width:  width,
 		Height: height,
 		Image:  img,
+		Theme:  DefaultTheme(),
 		face:   basicfont.Face7x13,
 	}
 }
 
+func (r *Renderer) Resize(width, height int) {
+	if width == r.Width && height == r.Height {
+		return
+	}
+	r.Width = width
+	r.Height = height
+	// A rotation keeps the pixel count, so the old backing array usually fits.
+	if size := width * height; cap(r.Image.Pix) >= size {
+		r.Image = &image.Gray{Pix: r.Image.Pix[:size], Stride: width, Rect: image.Rect(0, 0, width, height)}
+	} else {
+		r.Image = image.NewGray(image.Rect(0, 0, width, height))
+	}
+	r.HitTargets = r.HitTargets[:0]
+	r.Marquees = r.Marquees[:0]
+}
+
+// Clear fills the image with the background and forgets the previous
+// render's targets, keeping their storage for the next render.
 func (r *Renderer) Clear() {
-	draw.Draw(r.Image, r.Image.Bounds(), &image.Uniform{C: color.Gray{Y: 255}}, image.Point{}, draw.Src)
-	r.HitTargets = nil
+	draw.Draw(r.Image, r.Image.Bounds(), &image.Uniform{C: color.Gray{Y: r.Theme.BackgroundGray}}, image.Point{}, draw.Src)
+	r.HitTargets = r.HitTargets[:0]
+	r.Marquees = r.Marquees[:0]
+}
+
+// SetMarqueeOffset sets the horizontal scroll offset applied to the marquee
+// text component with the given id on subsequent renders.
+func (r *Renderer) SetMarqueeOffset(id string, offset int) {
+	if r.offsets == nil {
+		r.offsets = make(map[string]int)
+	}
+	r.offsets[id] = offset
+}
+
+func (r *Renderer) MarqueeOffset(id string) int {
+	return r.offsets[id]
+}
+
+func (r *Renderer) Marquee(id string) (MarqueeTarget, bool) {
+	for _, target := range r.Marquees {
+		if target.ID == id {
+			return target, true
+		}
+	}
+	return MarqueeTarget{}, false
 }
 
 func (r *Renderer) Render(components []A2UIComponent) {
 	r.Clear()
-	for _, comp := range components {
+	r.sorted = append(r.sorted[:0], components...)
+	for _, comp := range sortByZIndex(r.sorted) {
 		r.renderComponent(comp, 0, 0)
 	}
+	clear(r.sorted)
 }
 
 func (r *Renderer) renderComponent(comp A2UIComponent, offsetX, offsetY int) {
@@ -57,86 +166,387 @@ func (r *Renderer) renderComponent(comp A2UIComponent, offsetX, offsetY int) {
 		height = r.Height - y
 	}
 	rect := image.Rect(x, y, x+width, y+height)
+	if comp.Badge != "" {
+		// Drawn last so the badge sits on top of the component's children.
+		defer r.drawBadge(comp.Badge, rect)
+	}
 
 	switch comp.Type {
 	case "box", "card", "button":
-		fill := uint8(230)
+		fill := r.Theme.FillGray
 		if comp.Style != nil && comp.Style.FillGray != nil {
 			fill = *comp.Style.FillGray
 		}
-		draw.Draw(r.Image, rect, &image.Uniform{C: color.Gray{Y: fill}}, image.Point{}, draw.Src)
-		stroke := uint8(80)
-		if comp.Style != nil && comp.Style.StrokeGray != nil {
-			stroke = *comp.Style.StrokeGray
+		stroke := r.Theme.StrokeGray
+		if comp.Type == "button" {
+			stroke = r.accentGray()
+		}
+		strokeWidth := r.Theme.StrokeWidth
+		strokeStyle := StrokeSolid
+		if comp.Style != nil {
+			if comp.Style.StrokeGray != nil {
+				stroke = *comp.Style.StrokeGray
+			}
+			if comp.Style.StrokeWidth != nil {
+				strokeWidth = *comp.Style.StrokeWidth
+			}
+			if comp.Style.StrokeStyle != "" {
+				strokeStyle = comp.Style.StrokeStyle
+			}
 		}
-		r.strokeRect(rect, stroke)
+		if comp.Disabled {
+			fill = r.Theme.DisabledFillGray
+			stroke = r.Theme.DisabledStrokeGray
+		}
+		draw.Draw(r.Image, rect, &image.Uniform{C: color.Gray{Y: fill}}, image.Point{}, draw.Src)
+		r.strokeRect(rect, stroke, strokeWidth, strokeStyle)
 	case "text":
 		textRect := rect
-		textColor := color.Gray{Y: 20}
-		r.drawText(comp.Text, textRect, textColor, comp.Align)
+		textColor := color.Gray{Y: r.Theme.TextGray}
+		if comp.Style != nil && comp.Style.TextGray != nil {
+			textColor = color.Gray{Y: *comp.Style.TextGray}
+		}
+		if comp.Disabled {
+			textColor = color.Gray{Y: r.Theme.DisabledStrokeGray}
+		}
+		face := r.textFace(comp.Text, comp.Font, comp.FontSize)
+		text, align := comp.Text, comp.Align
+		if comp.Dir == "rtl" {
+			text = reverseRunes(text)
+			if align == "" {
+				align = "right"
+			}
+		}
+		if comp.Marquee && comp.ID != "" {
+			r.drawMarquee(comp.ID, text, textRect, face, textColor, align)
+			break
+		}
+		r.drawText(text, textRect, face, textColor, align)
+	case "logo":
+		if r.Logo != nil {
+			bounds := r.Logo.Bounds()
+			offset := image.Pt(x+(width-bounds.Dx())/2, y+(height-bounds.Dy())/2)
+			dst := r.Image.SubImage(rect).(*image.Gray)
+			draw.Draw(dst, bounds.Sub(bounds.Min).Add(offset), r.Logo, bounds.Min, draw.Over)
+		}
+	case "image":
+		img := comp.decoded
+		if img == nil {
+			// Components that did not come through a push, such as a
+			// configured sleep screen, are decoded here.
+			img, _ = decodeImageSrc(comp.Src)
+		}
+		if img == nil {
+			break
+		}
+		bounds := img.Bounds()
+		dst := rect
+		if comp.Width <= 0 {
+			dst.Max.X = dst.Min.X + bounds.Dx()
+		}
+		if comp.Height <= 0 {
+			dst.Max.Y = dst.Min.Y + bounds.Dy()
+		}
+		if !r.Dither {
+			drawScaled(r.Image, dst, img)
+			break
+		}
+		// Dithering works on the visible part, composited over what is
+		// already drawn.
+		visible := dst.Intersect(r.Image.Bounds())
+		if visible.Empty() {
+			break
+		}
+		scaled := image.NewGray(visible)
+		draw.Draw(scaled, visible, r.Image, visible.Min, draw.Src)
+		drawScaled(scaled, dst, img)
+		ditherGray(scaled, r.DitherLevels)
+		draw.Draw(r.Image, visible, scaled, visible.Min, draw.Src)
 	}
 
-	if comp.Action != nil && rect.Dx() > 0 && rect.Dy() > 0 {
-		r.HitTargets = append(r.HitTargets, HitTarget{Rect: rect, Action: *comp.Action})
+	if comp.Action != nil && !comp.Disabled && rect.Dx() > 0 && rect.Dy() > 0 {
+		r.HitTargets = append(r.HitTargets, HitTarget{Rect: rect.Inset(-max(comp.HitPadding, 0)), Action: *comp.Action})
 	}
 
 	if len(comp.Children) == 0 {
 		return
 	}
+	children := make([]A2UIComponent, len(comp.Children))
+	copy(children, comp.Children)
 	if comp.Type == "list" {
+		rowGrays := r.rowGrays(comp)
 		cursorY := y + comp.Padding
-		for _, child := range comp.Children {
-			childY := child.Y
-			if childY == 0 {
-				childY = cursorY - y
+		for i := range children {
+			child := &children[i]
+			if child.Y == 0 {
+				child.Y = cursorY - y
 			}
 			child.X += comp.Padding
-			child.Y = childY
-			r.renderComponent(child, x, y)
 			cursorY += child.Height + comp.Padding
+			if comp.Striped && child.Height > 0 {
+				row := image.Rect(x, y+child.Y, x+width, y+child.Y+child.Height).Intersect(rect)
+				draw.Draw(r.Image, row, &image.Uniform{C: color.Gray{Y: rowGrays[i%2]}}, image.Point{}, draw.Src)
+			}
 		}
-		return
 	}
-	for _, child := range comp.Children {
+	for _, child := range sortByZIndex(children) {
+		child.Disabled = child.Disabled || comp.Disabled
 		r.renderComponent(child, x, y)
 	}
 }
 
-func (r *Renderer) strokeRect(rect image.Rectangle, gray uint8) {
+// drawScaled draws src scaled to fill dst, computing only the pixels within
+// out's bounds, so an oversized dst costs no more than what is visible.
+func drawScaled(out draw.Image, dst image.Rectangle, src image.Image) {
+	bounds := src.Bounds()
+	if dst.Empty() || bounds.Empty() {
+		return
+	}
+	sx := float64(dst.Dx()) / float64(bounds.Dx())
+	sy := float64(dst.Dy()) / float64(bounds.Dy())
+	s2d := f64.Aff3{
+		sx, 0, float64(dst.Min.X) - sx*float64(bounds.Min.X),
+		0, sy, float64(dst.Min.Y) - sy*float64(bounds.Min.Y),
+	}
+	xdraw.BiLinear.Transform(out, s2d, src, bounds, xdraw.Over, nil)
+}
+
+const defaultDitherLevels = 16
+
+// ditherGray quantizes img in place to levels evenly spaced grays, spreading
+// each pixel's rounding error over its unvisited neighbors with the
+// Floyd-Steinberg weights so the average brightness is kept.
+func ditherGray(img *image.Gray, levels int) {
+	if levels <= 0 {
+		levels = defaultDitherLevels
+	}
+	levels = min(max(levels, 2), 256)
+	step := 255 / float64(levels-1)
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	// Errors carried to the current and next row, padded by one on each side.
+	cur := make([]float64, width+2)
+	next := make([]float64, width+2)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		for i := 0; i < width; i++ {
+			value := float64(row[i]) + cur[i+1]
+			quantized := math.Round(value/step) * step
+			quantized = min(max(quantized, 0), 255)
+			row[i] = uint8(quantized)
+			diff := value - quantized
+			cur[i+2] += diff * 7 / 16
+			next[i] += diff * 3 / 16
+			next[i+1] += diff * 5 / 16
+			next[i+2] += diff * 1 / 16
+		}
+		cur, next = next, cur
+		clear(next)
+	}
+}
+
+func (r *Renderer) accentGray() uint8 {
+	if r.Theme.AccentGray != nil {
+		return *r.Theme.AccentGray
+	}
+	return r.Theme.StrokeGray
+}
+
+// rowGrays returns the shading of a striped list's even and odd rows.
+func (r *Renderer) rowGrays(comp A2UIComponent) [2]uint8 {
+	grays := [2]uint8{r.Theme.BackgroundGray, defaultAltRowGray}
+	if comp.Style != nil {
+		if comp.Style.RowGray != nil {
+			grays[0] = *comp.Style.RowGray
+		}
+		if comp.Style.AltRowGray != nil {
+			grays[1] = *comp.Style.AltRowGray
+		}
+	}
+	return grays
+}
+
+// sortByZIndex orders siblings so higher z-indexes render last, keeping
+// tree order among equal z-indexes.
+func sortByZIndex(components []A2UIComponent) []A2UIComponent {
+	slices.SortStableFunc(components, func(a, b A2UIComponent) int {
+		return cmp.Compare(a.ZIndex, b.ZIndex)
+	})
+	return components
+}
+
+func (r *Renderer) strokeRect(rect image.Rectangle, gray uint8, width int, style string) {
 	strokeColor := color.Gray{Y: gray}
-	for x := rect.Min.X; x < rect.Max.X; x++ {
-		r.Image.SetGray(x, rect.Min.Y, strokeColor)
-		r.Image.SetGray(x, rect.Max.Y-1, strokeColor)
+	if limit := min(rect.Dx(), rect.Dy()) / 2; width > limit {
+		width = limit
+	}
+	for i := 0; i < width; i++ {
+		inset := rect.Inset(i)
+		if inset.Empty() {
+			return
+		}
+		for x := inset.Min.X; x < inset.Max.X; x++ {
+			if !strokeOn(x-rect.Min.X, style) {
+				continue
+			}
+			r.Image.SetGray(x, inset.Min.Y, strokeColor)
+			r.Image.SetGray(x, inset.Max.Y-1, strokeColor)
+		}
+		for y := inset.Min.Y; y < inset.Max.Y; y++ {
+			if !strokeOn(y-rect.Min.Y, style) {
+				continue
+			}
+			r.Image.SetGray(inset.Min.X, y, strokeColor)
+			r.Image.SetGray(inset.Max.X-1, y, strokeColor)
+		}
 	}
-	for y := rect.Min.Y; y < rect.Max.Y; y++ {
-		r.Image.SetGray(rect.Min.X, y, strokeColor)
-		r.Image.SetGray(rect.Max.X-1, y, strokeColor)
+}
+
+// strokeOn reports whether the pixel at offset pos along an edge is inked
+// for the given stroke style. Unknown styles draw solid.
+func strokeOn(pos int, style string) bool {
+	switch style {
+	case StrokeDashed:
+		return pos%(dashLength+dashGap) < dashLength
+	case StrokeDotted:
+		return pos%2 == 0
+	default:
+		return true
 	}
 }
 
-func (r *Renderer) drawText(text string, rect image.Rectangle, col color.Gray, align string) {
+func (r *Renderer) faceFor(name string, size float64) font.Face {
+	if name == "" || name == FontDefault {
+		return r.face
+	}
+	return fontFace(name, size)
+}
+
+// textFace is the face text components draw with: the named font, or the
+// theme font if none is named, or the bundled UI font if that lacks some of
+// the text's glyphs.
+func (r *Renderer) textFace(text, fontName string, size float64) font.Face {
+	if fontName == "" {
+		fontName = r.Theme.Font
+	}
+	face := r.faceFor(fontName, size)
+	if !hasGlyphs(face, text) {
+		face = fontFace(FontUI, fallbackSize)
+	}
+	return face
+}
+
+// MeasureString returns the rendered width in pixels of text in the named
+// font at the given size.
+func (r *Renderer) MeasureString(text, fontName string, size float64) int {
+	return font.MeasureString(r.textFace(text, fontName, size), text).Ceil()
+}
+
+// TextMetrics is the size of a line of text as a text component draws it.
+type TextMetrics struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Ascent int `json:"ascent"`
+}
+
+// MeasureText returns the width of text and the line height and ascent of
+// the face it would be drawn with, before the theme padding is added.
+func (r *Renderer) MeasureText(text, fontName string, size float64) TextMetrics {
+	face := r.textFace(text, fontName, size)
+	metrics := face.Metrics()
+	return TextMetrics{
+		Width:  font.MeasureString(face, text).Ceil(),
+		Height: metrics.Height.Ceil(),
+		Ascent: metrics.Ascent.Ceil(),
+	}
+}
+
+func (r *Renderer) drawText(text string, rect image.Rectangle, face font.Face, col color.Gray, align string) {
 	if text == "" {
 		return
 	}
 	d := &font.Drawer{
 		Dst:  r.Image,
 		Src:  image.NewUniform(col),
-		Face: r.face,
+		Face: face,
 	}
 	textWidth := d.MeasureString(text).Ceil()
-	startX := rect.Min.X + 2
+	padding := r.Theme.Padding
+	startX := rect.Min.X + padding
 	if align == "center" {
 		startX = rect.Min.X + (rect.Dx()-textWidth)/2
 	} else if align == "right" {
-		startX = rect.Max.X - textWidth - 2
+		startX = rect.Max.X - textWidth - padding
 	}
-	startY := rect.Min.Y + r.face.Metrics().Ascent.Ceil() + 2
+	startY := rect.Min.Y + face.Metrics().Ascent.Ceil() + padding
 	d.Dot = fixed.P(startX, startY)
 	d.DrawString(text)
 }
 
+// drawMarquee draws text scrolled by the component's marquee offset, clipped
+// to rect and repeated after marqueeGap so it wraps around. Text that fits
+// is drawn normally and not registered as a marquee.
+func (r *Renderer) drawMarquee(id, text string, rect image.Rectangle, face font.Face, col color.Gray, align string) {
+	textWidth := font.MeasureString(face, text).Ceil()
+	padding := r.Theme.Padding
+	if textWidth+2*padding <= rect.Dx() {
+		r.drawText(text, rect, face, col, align)
+		return
+	}
+	clip := rect.Intersect(r.Image.Bounds())
+	if clip.Empty() {
+		return
+	}
+	period := textWidth + marqueeGap
+	offset := r.offsets[id] % period
+	d := &font.Drawer{
+		Dst:  r.Image.SubImage(clip).(*image.Gray),
+		Src:  image.NewUniform(col),
+		Face: face,
+	}
+	startY := rect.Min.Y + face.Metrics().Ascent.Ceil() + padding
+	for startX := rect.Min.X + padding - offset; startX < rect.Max.X; startX += period {
+		d.Dot = fixed.P(startX, startY)
+		d.DrawString(text)
+	}
+	r.Marquees = append(r.Marquees, MarqueeTarget{ID: id, Rect: clip, Period: period})
+}
+
+// drawBadge draws text in a small filled box inset in the top-right corner
+// of rect, such as an unread count on a button.
+func (r *Renderer) drawBadge(text string, rect image.Rectangle) {
+	padding := r.Theme.Padding
+	height := r.face.Metrics().Height.Ceil() + 2*padding
+	width := max(font.MeasureString(r.face, text).Ceil()+2*padding, height)
+	badge := image.Rect(rect.Max.X-width, rect.Min.Y, rect.Max.X, rect.Min.Y+height).Intersect(r.Image.Bounds())
+	if badge.Empty() {
+		return
+	}
+	draw.Draw(r.Image, badge, &image.Uniform{C: color.Gray{Y: r.accentGray()}}, image.Point{}, draw.Src)
+	r.drawText(text, badge, r.face, color.Gray{Y: r.Theme.BackgroundGray}, "center")
+}
+
+// DrawOverlay draws a small bordered banner with text centered near the
+// bottom of the screen, on top of whatever was rendered, and returns its rect.
+// Row 0 is the bottom banner; each further row is stacked above it.
+func (r *Renderer) DrawOverlay(text string, row int) image.Rectangle {
+	margin := 4 * r.Theme.Padding
+	width := font.MeasureString(r.face, text).Ceil() + 2*margin
+	height := r.face.Metrics().Height.Ceil() + 2*margin
+	x := (r.Width - width) / 2
+	y := r.Height - (row+1)*(height+2*margin)
+	rect := image.Rect(x, y, x+width, y+height).Intersect(r.Image.Bounds())
+	if rect.Empty() {
+		return rect
+	}
+	draw.Draw(r.Image, rect, &image.Uniform{C: color.Gray{Y: r.Theme.BackgroundGray}}, image.Point{}, draw.Src)
+	r.strokeRect(rect, r.Theme.StrokeGray, max(r.Theme.StrokeWidth, 1), StrokeSolid)
+	r.drawText(text, rect.Inset(margin-r.Theme.Padding), r.face, color.Gray{Y: r.Theme.TextGray}, "center")
+	return rect
+}
+
 func (r *Renderer) HitTest(x, y int) *A2UIAction {
-	for i := range r.HitTargets {
+	for i := len(r.HitTargets) - 1; i >= 0; i-- {
 		hit := r.HitTargets[i]
 		if x >= hit.Rect.Min.X && x < hit.Rect.Max.X && y >= hit.Rect.Min.Y && y < hit.Rect.Max.Y {
 			return &hit.Action
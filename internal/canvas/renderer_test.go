@@ -1,6 +1,11 @@
 package canvas
 
-import "testing"
+import (
+	"image"
+	"math"
+	"runtime"
+	"testing"
+)
 
 func TestRendererHitTest(t *testing.T) {
 	r := NewRenderer(200, 100)
@@ -21,3 +26,324 @@ func TestRendererHitTest(t *testing.T) {
 		t.Fatalf("expected no hit")
 	}
 }
+
+func TestRendererThemeDefaults(t *testing.T) {
+	r := NewRenderer(50, 50)
+	r.Theme = Theme{BackgroundGray: 240, FillGray: 120, StrokeGray: 10, TextGray: 60, StrokeWidth: 2, Padding: 4}
+	r.Render([]A2UIComponent{{Type: "box", X: 10, Y: 10, Width: 20, Height: 20}})
+	if got := r.Image.GrayAt(0, 0).Y; got != 240 {
+		t.Fatalf("expected theme background 240, got %d", got)
+	}
+	if got := r.Image.GrayAt(20, 20).Y; got != 120 {
+		t.Fatalf("expected theme fill 120, got %d", got)
+	}
+	if got := r.Image.GrayAt(10, 20).Y; got != 10 {
+		t.Fatalf("expected theme stroke 10 on outer edge, got %d", got)
+	}
+	if got := r.Image.GrayAt(11, 20).Y; got != 10 {
+		t.Fatalf("expected theme stroke width 2, got %d", got)
+	}
+	if got := r.Image.GrayAt(12, 20).Y; got != 120 {
+		t.Fatalf("expected fill inside stroke, got %d", got)
+	}
+}
+
+func TestRendererDashedStroke(t *testing.T) {
+	r := NewRenderer(50, 50)
+	width := 2
+	gray := uint8(0)
+	style := &A2UIStyle{StrokeGray: &gray, StrokeStyle: StrokeDashed, StrokeWidth: &width}
+	r.Render([]A2UIComponent{{Type: "box", X: 10, Y: 10, Width: 24, Height: 20, Style: style}})
+	for x := 10; x < 33; x++ {
+		want := (x-10)%(dashLength+dashGap) < dashLength
+		if got := r.Image.GrayAt(x, 10).Y == gray; got != want {
+			t.Fatalf("dash at x=%d: expected inked=%v", x, want)
+		}
+	}
+	if got := r.Image.GrayAt(10, 11).Y; got != gray {
+		t.Fatalf("expected second border row at width 2, got %d", got)
+	}
+	if got := r.Image.GrayAt(11, 11).Y; got != gray {
+		t.Fatalf("expected second border row at width 2, got %d", got)
+	}
+	if got := r.Image.GrayAt(12, 12).Y; got != r.Theme.FillGray {
+		t.Fatalf("expected fill inside stroke, got %d", got)
+	}
+}
+
+func TestRendererTextGray(t *testing.T) {
+	r := NewRenderer(100, 30)
+	gray := uint8(128)
+	r.Render([]A2UIComponent{{Type: "text", Text: "HHHH", Style: &A2UIStyle{TextGray: &gray}}})
+	found := false
+	for _, pix := range r.Image.Pix {
+		if pix == gray {
+			found = true
+			break
+		}
+		if pix != r.Theme.BackgroundGray {
+			t.Fatalf("unexpected text pixel %d, want %d", pix, gray)
+		}
+	}
+	if !found {
+		t.Fatalf("expected text pixels drawn at gray %d", gray)
+	}
+}
+
+func TestRendererDisabledButton(t *testing.T) {
+	r := NewRenderer(200, 100)
+	action := A2UIAction{Type: "tap"}
+	r.Render([]A2UIComponent{{Type: "button", X: 10, Y: 10, Width: 80, Height: 30, Action: &action, Disabled: true}})
+	if got := r.Image.GrayAt(40, 20).Y; got != r.Theme.DisabledFillGray {
+		t.Fatalf("expected muted fill %d, got %d", r.Theme.DisabledFillGray, got)
+	}
+	if got := r.Image.GrayAt(10, 20).Y; got != r.Theme.DisabledStrokeGray {
+		t.Fatalf("expected muted stroke %d, got %d", r.Theme.DisabledStrokeGray, got)
+	}
+	if got := r.HitTest(40, 20); got != nil {
+		t.Fatalf("expected disabled button to be excluded from hit test")
+	}
+}
+
+func TestRendererZIndexOrdering(t *testing.T) {
+	r := NewRenderer(100, 100)
+	top := A2UIAction{Type: "top"}
+	bottom := A2UIAction{Type: "bottom"}
+	topFill := uint8(10)
+	bottomFill := uint8(200)
+	r.Render([]A2UIComponent{
+		{Type: "box", X: 10, Y: 10, Width: 40, Height: 40, ZIndex: 2, Action: &top, Style: &A2UIStyle{FillGray: &topFill}},
+		{Type: "box", X: 20, Y: 20, Width: 40, Height: 40, ZIndex: 1, Action: &bottom, Style: &A2UIStyle{FillGray: &bottomFill}},
+	})
+	if got := r.Image.GrayAt(30, 30).Y; got != topFill {
+		t.Fatalf("expected higher z-index drawn last with fill %d, got %d", topFill, got)
+	}
+	if got := r.HitTest(30, 30); got == nil || got.Type != "top" {
+		t.Fatalf("expected higher z-index to win hit, got %+v", got)
+	}
+	if got := r.HitTest(55, 55); got == nil || got.Type != "bottom" {
+		t.Fatalf("expected lower z-index hit outside overlap, got %+v", got)
+	}
+}
+
+func TestRendererMonospaceFontMeasure(t *testing.T) {
+	r := NewRenderer(200, 50)
+	text := "iiiiWWWW"
+	def := r.MeasureString(text, "", 0)
+	mono := r.MeasureString(text, FontMono, 0)
+	if def == mono {
+		t.Fatalf("expected monospace width to differ from default, both %d", def)
+	}
+	if ui := r.MeasureString(text, FontUI, 0); ui == def {
+		t.Fatalf("expected ui font width to differ from default, both %d", ui)
+	}
+	r.Render([]A2UIComponent{{Type: "text", Text: text, Font: FontMono}})
+	inked := false
+	for _, pix := range r.Image.Pix {
+		if pix != r.Theme.BackgroundGray {
+			inked = true
+			break
+		}
+	}
+	if !inked {
+		t.Fatalf("expected monospace text drawn")
+	}
+}
+
+func TestRendererUnicodeText(t *testing.T) {
+	if err := RegisterFont("unicode", goRegularTTF, 0); err != nil {
+		t.Fatalf("register font: %v", err)
+	}
+	if err := RegisterFont("broken", []byte("not a font"), 0); err == nil {
+		t.Fatalf("expected invalid font to be rejected")
+	}
+	r := NewRenderer(200, 40)
+	r.Render([]A2UIComponent{{Type: "text", Text: "\u00c7a d\u00e9j\u00e0 vu \u0395\u03bb\u03bb\u03b7\u03bd\u03b9\u03ba\u03ac", Font: "unicode"}})
+	if !hasInk(r, r.Image.Bounds()) {
+		t.Fatalf("expected non-ASCII text rendered with unicode font")
+	}
+
+	r.Render([]A2UIComponent{{Type: "text", Text: "\u0395\u03bb\u03bb\u03b7\u03bd\u03b9\u03ba\u03ac"}})
+	if !hasInk(r, r.Image.Bounds()) {
+		t.Fatalf("expected default font to fall back for missing glyphs")
+	}
+
+	r.Render([]A2UIComponent{{Type: "text", Text: "\u05e9\u05dc\u05d5\u05dd", Font: "unicode", Dir: "rtl", Width: 200, Height: 40}})
+	if hasInk(r, image.Rect(0, 0, 100, 40)) || !hasInk(r, image.Rect(100, 0, 200, 40)) {
+		t.Fatalf("expected rtl text right aligned by default")
+	}
+}
+
+func hasInk(r *Renderer, rect image.Rectangle) bool {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if r.Image.GrayAt(x, y).Y != r.Theme.BackgroundGray {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestRendererResizeReusesBuffer(t *testing.T) {
+	r := NewRenderer(60, 40)
+	pix := &r.Image.Pix[0]
+	r.Resize(40, 60)
+	if &r.Image.Pix[0] != pix || r.Image.Stride != 40 || r.Image.Bounds() != image.Rect(0, 0, 40, 60) {
+		t.Fatalf("expected rotation to reuse the pixel buffer, got bounds %v", r.Image.Bounds())
+	}
+	r.Resize(80, 80)
+	if len(r.Image.Pix) != 80*80 {
+		t.Fatalf("expected larger size to allocate, got %d pixels", len(r.Image.Pix))
+	}
+}
+
+func TestRendererRenderReusesStorage(t *testing.T) {
+	r := NewRenderer(100, 100)
+	components := []A2UIComponent{
+		{Type: "box", Width: 50, Height: 50, ZIndex: 1},
+		{Type: "button", X: 50, Width: 50, Height: 50, Action: &A2UIAction{Type: "tap"}},
+	}
+	r.Render(components)
+	allocs := testing.AllocsPerRun(20, func() {
+		r.Render(components)
+	})
+	// Only the small per-fill color values remain; pixel, target, and sort
+	// storage is reused.
+	if allocs > float64(2*len(components)) {
+		t.Fatalf("expected repeated renders to reuse buffers, got %.1f allocations per render", allocs)
+	}
+}
+
+func BenchmarkRendererRender(b *testing.B) {
+	r := NewRenderer(1072, 1448)
+	components := []A2UIComponent{
+		{Type: "card", X: 20, Y: 20, Width: 1000, Height: 400, Children: []A2UIComponent{{Type: "text", Text: "Title", Width: 900, Height: 40}}},
+		{Type: "button", X: 20, Y: 500, Width: 300, Height: 80, Text: "OK", Action: &A2UIAction{Type: "tap"}},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Render(components)
+	}
+}
+
+func TestRendererStripedList(t *testing.T) {
+	r := NewRenderer(100, 100)
+	rowGray, altGray := uint8(250), uint8(200)
+	r.Render([]A2UIComponent{{
+		Type:    "list",
+		Width:   100,
+		Striped: true,
+		Style:   &A2UIStyle{RowGray: &rowGray, AltRowGray: &altGray},
+		Children: []A2UIComponent{
+			{Type: "text", Height: 20},
+			{Type: "text", Height: 20},
+			{Type: "text", Height: 20},
+		},
+	}})
+	for i, want := range []uint8{rowGray, altGray, rowGray} {
+		if got := r.Image.GrayAt(50, i*20+10).Y; got != want {
+			t.Fatalf("row %d: expected fill %d, got %d", i, want, got)
+		}
+	}
+	if got := r.Image.GrayAt(50, 70).Y; got != r.Theme.BackgroundGray {
+		t.Fatalf("expected background below the rows, got %d", got)
+	}
+}
+
+func TestRendererBadge(t *testing.T) {
+	r := NewRenderer(200, 100)
+	r.Render([]A2UIComponent{{Type: "button", X: 10, Y: 10, Width: 100, Height: 50, Badge: "3"}})
+	height := r.face.Metrics().Height.Ceil() + 2*r.Theme.Padding
+	badge := image.Rect(110-height, 10, 110, 10+height)
+	dark, light := 0, 0
+	for y := badge.Min.Y; y < badge.Max.Y; y++ {
+		for x := badge.Min.X; x < badge.Max.X; x++ {
+			switch r.Image.GrayAt(x, y).Y {
+			case r.Theme.StrokeGray:
+				dark++
+			case r.Theme.BackgroundGray:
+				light++
+			}
+		}
+	}
+	if dark == 0 || light == 0 {
+		t.Fatalf("expected filled badge with light text in the top-right corner, got %d dark and %d light pixels", dark, light)
+	}
+	if got := r.Image.GrayAt(badge.Min.X-1, badge.Max.Y-1).Y; got != r.Theme.FillGray {
+		t.Fatalf("expected button fill left of the badge, got %d", got)
+	}
+	if got := r.Image.GrayAt(badge.Max.X-5, badge.Max.Y).Y; got != r.Theme.FillGray {
+		t.Fatalf("expected button fill below the badge, got %d", got)
+	}
+}
+
+func TestRendererHitPadding(t *testing.T) {
+	r := NewRenderer(200, 100)
+	action := A2UIAction{Type: "tap"}
+	r.Render([]A2UIComponent{{Type: "button", X: 50, Y: 40, Width: 20, Height: 20, HitPadding: 8, Action: &action}})
+	if got := r.HitTest(45, 50); got == nil || got.Type != "tap" {
+		t.Fatalf("expected tap within hit padding to hit, got %+v", got)
+	}
+	if got := r.HitTest(41, 50); got != nil {
+		t.Fatalf("expected tap beyond hit padding to miss")
+	}
+	if got := r.Image.GrayAt(45, 50).Y; got != r.Theme.BackgroundGray {
+		t.Fatalf("expected drawn size unchanged, got %d at padding", got)
+	}
+}
+
+func TestRendererDithersImages(t *testing.T) {
+	gradient := image.NewGray(image.Rect(0, 0, 64, 16))
+	var sum float64
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 64; x++ {
+			gradient.Pix[gradient.PixOffset(x, y)] = uint8(x * 255 / 63)
+			sum += float64(x * 255 / 63)
+		}
+	}
+	r := NewRenderer(80, 20)
+	r.Dither = true
+	r.Render([]A2UIComponent{{Type: "image", X: 4, Y: 2, decoded: gradient}})
+
+	var got float64
+	for y := 2; y < 18; y++ {
+		for x := 4; x < 68; x++ {
+			v := r.Image.GrayAt(x, y).Y
+			if v%17 != 0 {
+				t.Fatalf("expected only the 16 panel levels, got %d at (%d,%d)", v, x, y)
+			}
+			got += float64(v)
+		}
+	}
+	want := sum / (64 * 16)
+	if mean := got / (64 * 16); math.Abs(mean-want) > 1 {
+		t.Fatalf("expected dithering to keep the mean brightness %.1f, got %.1f", want, mean)
+	}
+
+	r.DitherLevels = 2
+	r.Render([]A2UIComponent{{Type: "image", decoded: gradient}})
+	for x := 0; x < 64; x++ {
+		if v := r.Image.GrayAt(x, 8).Y; v != 0 && v != 255 {
+			t.Fatalf("expected black and white only with 2 levels, got %d", v)
+		}
+	}
+}
+
+func TestRendererOversizedImageOnlyCostsTheScreen(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 64, 64))
+	for _, dither := range []bool{false, true} {
+		r := NewRenderer(80, 20)
+		r.Dither = dither
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		r.Render([]A2UIComponent{{Type: "image", X: -50, Width: 100000, Height: 100000, decoded: src}})
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+			t.Fatalf("dither %v: expected an oversized image to allocate about the screen, allocated %d bytes", dither, allocated)
+		}
+		if got := r.Image.GrayAt(40, 10).Y; got != 0 {
+			t.Fatalf("dither %v: expected the visible part of the image drawn, got %d", dither, got)
+		}
+	}
+}
@@ -3,14 +3,107 @@ package canvas
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
 	"image"
 	"image/png"
+	"sync"
 )
 
+// maxSnapshotDownscales bounds how far a snapshot is shrunk to fit the size
+// limit; past 1/8 scale it is no longer useful and an error is returned.
+const maxSnapshotDownscales = 3
+
+// SnapshotTooLargeError reports a snapshot that does not fit the limit even
+// after downscaling.
+type SnapshotTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *SnapshotTooLargeError) Error() string {
+	return fmt.Sprintf("snapshot is %d bytes encoded, over the %d byte limit", e.Size, e.Limit)
+}
+
+// Snapshots are taken repeatedly at the same size, so the frame copy, the
+// PNG encoder state, and the output buffer are pooled to spare the GC.
+var (
+	snapshotFrames  sync.Pool
+	snapshotBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	snapshotEncoder = png.Encoder{BufferPool: &pngBufferPool{}}
+)
+
+type pngBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+	buf, _ := p.pool.Get().(*png.EncoderBuffer)
+	return buf
+}
+
+func (p *pngBufferPool) Put(buf *png.EncoderBuffer) {
+	p.pool.Put(buf)
+}
+
 func SnapshotBase64(img image.Image) (string, error) {
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
+	buf := snapshotBuffers.Get().(*bytes.Buffer)
+	defer snapshotBuffers.Put(buf)
+	buf.Reset()
+	if err := snapshotEncoder.Encode(buf, img); err != nil {
 		return "", err
 	}
 	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
+
+// copySnapshotFrame copies src into a pooled image so it can be encoded
+// without holding up rendering. Return it with releaseSnapshotFrame.
+func copySnapshotFrame(src *image.Gray) *image.Gray {
+	frame, _ := snapshotFrames.Get().(*image.Gray)
+	if frame == nil || frame.Rect != src.Rect {
+		frame = image.NewGray(src.Rect)
+	}
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		copy(frame.Pix[frame.PixOffset(src.Rect.Min.X, y):frame.PixOffset(src.Rect.Max.X, y)],
+			src.Pix[src.PixOffset(src.Rect.Min.X, y):src.PixOffset(src.Rect.Max.X, y)])
+	}
+	return frame
+}
+
+func releaseSnapshotFrame(frame *image.Gray) {
+	snapshotFrames.Put(frame)
+}
+
+// SnapshotBase64Limited encodes img like SnapshotBase64, halving its size
+// until the encoded string fits in maxBytes. It returns the scale divisor
+// that was applied. A non-positive maxBytes disables the limit.
+func SnapshotBase64Limited(img *image.Gray, maxBytes int) (string, int, error) {
+	scale := 1
+	for {
+		out, err := SnapshotBase64(img)
+		if err != nil {
+			return "", 0, err
+		}
+		if maxBytes <= 0 || len(out) <= maxBytes {
+			return out, scale, nil
+		}
+		if scale>>maxSnapshotDownscales > 0 || img.Rect.Dx() < 2 || img.Rect.Dy() < 2 {
+			return "", 0, &SnapshotTooLargeError{Size: len(out), Limit: maxBytes}
+		}
+		img = halveGray(img)
+		scale *= 2
+	}
+}
+
+func halveGray(src *image.Gray) *image.Gray {
+	bounds := src.Rect
+	dst := image.NewGray(image.Rect(0, 0, bounds.Dx()/2, bounds.Dy()/2))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			sx, sy := bounds.Min.X+2*x, bounds.Min.Y+2*y
+			sum := int(src.GrayAt(sx, sy).Y) + int(src.GrayAt(sx+1, sy).Y) +
+				int(src.GrayAt(sx, sy+1).Y) + int(src.GrayAt(sx+1, sy+1).Y)
+			dst.Pix[y*dst.Stride+x] = uint8(sum / 4)
+		}
+	}
+	return dst
+}
@@ -1,7 +1,12 @@
 package canvas
 
 import (
+	"encoding/base64"
+	"errors"
 	"image"
+	"image/png"
+	"math/rand"
+	"strings"
 	"testing"
 )
 
@@ -15,3 +20,58 @@ func TestSnapshotBase64(t *testing.T) {
 		t.Fatalf("expected base64 output")
 	}
 }
+
+func noisyGray(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(1))
+	rng.Read(img.Pix)
+	return img
+}
+
+func TestSnapshotBase64LimitedDownscales(t *testing.T) {
+	img := noisyGray(200, 100)
+	full, err := SnapshotBase64(img)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	limit := len(full) / 2
+	out, scale, err := SnapshotBase64Limited(img, limit)
+	if err != nil {
+		t.Fatalf("limited snapshot: %v", err)
+	}
+	if scale != 2 || len(out) > limit {
+		t.Fatalf("expected one halving within %d bytes, got scale %d size %d", limit, scale, len(out))
+	}
+	decoded, err := png.Decode(base64.NewDecoder(base64.StdEncoding, strings.NewReader(out)))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded.Bounds().Dx() != 100 || decoded.Bounds().Dy() != 50 {
+		t.Fatalf("unexpected downscaled bounds %v", decoded.Bounds())
+	}
+
+	if _, scale, err := SnapshotBase64Limited(img, 0); err != nil || scale != 1 {
+		t.Fatalf("expected no limit to keep full size, got scale %d err %v", scale, err)
+	}
+}
+
+func TestSnapshotBase64LimitedTooLarge(t *testing.T) {
+	_, _, err := SnapshotBase64Limited(noisyGray(200, 100), 64)
+	var tooLarge *SnapshotTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("expected SnapshotTooLargeError, got %v", err)
+	}
+	if tooLarge.Limit != 64 || tooLarge.Size <= 64 {
+		t.Fatalf("unexpected error fields %+v", tooLarge)
+	}
+}
+
+func BenchmarkSnapshotBase64(b *testing.B) {
+	img := noisyGray(1072, 1448)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := SnapshotBase64(img); err != nil {
+			b.Fatalf("snapshot: %v", err)
+		}
+	}
+}
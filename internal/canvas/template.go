@@ -0,0 +1,143 @@
+package canvas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// maxTemplates bounds the templates a gateway can register, which are kept
+// in memory until restart.
+const maxTemplates = 32
+
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+type templateRegisterArgs struct {
+	Name     string          `json:"name"`
+	Template json.RawMessage `json:"template"`
+}
+
+type templateRenderArgs struct {
+	Name string                     `json:"name"`
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+// handleTemplateRegister caches a push with placeholders, so a dashboard
+// that only changes its values is sent once and then rendered from data.
+func (h *Handler) handleTemplateRegister(args json.RawMessage) (interface{}, error) {
+	var req templateRegisterArgs
+	if err := json.Unmarshal(positionalArgs(args, "name", "template"), &req); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, errors.New("template requires a name")
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(req.Template, &fields); err != nil || fields == nil {
+		return nil, errors.New("template must be an A2UI push object")
+	}
+	h.templateMu.Lock()
+	defer h.templateMu.Unlock()
+	if h.templates == nil {
+		h.templates = make(map[string]json.RawMessage)
+	}
+	if _, ok := h.templates[req.Name]; !ok && len(h.templates) >= maxTemplates {
+		return nil, fmt.Errorf("too many templates, limit %d", maxTemplates)
+	}
+	h.templates[req.Name] = req.Template
+	return nil, nil
+}
+
+func (h *Handler) handleTemplateRender(ctx context.Context, args json.RawMessage) (interface{}, error) {
+	var req templateRenderArgs
+	if err := json.Unmarshal(positionalArgs(args, "name", "data"), &req); err != nil {
+		return nil, err
+	}
+	h.templateMu.Lock()
+	template, ok := h.templates[req.Name]
+	h.templateMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", req.Name)
+	}
+	filled, err := fillTemplate(template, req.Data)
+	if err != nil {
+		return nil, err
+	}
+	push, err := DecodeA2UIPush(filled, h.palette)
+	if err != nil {
+		return nil, err
+	}
+	return h.applyPush(ctx, push)
+}
+
+// fillTemplate replaces the placeholders in template's string values with
+// data. A string that is only a placeholder becomes the data value itself,
+// of whatever type; placeholders within longer strings are replaced by the
+// value's text.
+func fillTemplate(template json.RawMessage, data map[string]json.RawMessage) (json.RawMessage, error) {
+	var tree interface{}
+	if err := json.Unmarshal(template, &tree); err != nil {
+		return nil, err
+	}
+	filled, err := fillValue(tree, data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(filled)
+}
+
+func fillValue(value interface{}, data map[string]json.RawMessage) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			filled, err := fillValue(child, data)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = filled
+		}
+		return value, nil
+	case []interface{}:
+		for i, child := range value {
+			filled, err := fillValue(child, data)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = filled
+		}
+		return value, nil
+	case string:
+		return fillString(value, data)
+	}
+	return value, nil
+}
+
+func fillString(s string, data map[string]json.RawMessage) (interface{}, error) {
+	if match := templatePlaceholder.FindStringSubmatchIndex(s); match != nil && match[0] == 0 && match[1] == len(s) {
+		raw, ok := data[s[match[2]:match[3]]]
+		if !ok {
+			return nil, fmt.Errorf("missing template data %q", s[match[2]:match[3]])
+		}
+		return raw, nil
+	}
+	var missing string
+	filled := templatePlaceholder.ReplaceAllStringFunc(s, func(placeholder string) string {
+		key := templatePlaceholder.FindStringSubmatch(placeholder)[1]
+		raw, ok := data[key]
+		if !ok {
+			missing = key
+			return placeholder
+		}
+		var text string
+		if err := json.Unmarshal(raw, &text); err == nil {
+			return text
+		}
+		return string(raw)
+	})
+	if missing != "" {
+		return nil, fmt.Errorf("missing template data %q", missing)
+	}
+	return filled, nil
+}
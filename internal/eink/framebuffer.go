@@ -95,34 +95,36 @@ type fbBitfield struct {
 }
 
 type Framebuffer struct {
-	file   *os.File
-	data   []byte
-	Width  int
-	Height int
-	Stride int
-	BPP    int
+	file      *os.File
+	data      []byte
+	ioctlFunc func(fd uintptr, req uintptr, arg unsafe.Pointer) error
+	Width     int
+	Height    int
+	Stride    int
+	BPP       int
 }
 
 func Open(path string) (*Framebuffer, error) {
+	return openWith(path, ioctl)
+}
+
+func openWith(path string, ioctlFn func(fd uintptr, req uintptr, arg unsafe.Pointer) error) (*Framebuffer, error) {
 	file, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
 		return nil, err
 	}
-	var vinfo fbVarScreeninfo
-	var finfo fbFixScreeninfo
-	if err := ioctl(file.Fd(), ior(fbIOGetVScreenInfo, 0x00, unsafe.Sizeof(vinfo)), unsafe.Pointer(&vinfo)); err != nil {
+	vinfo, finfo, err := queryScreenInfo(file.Fd(), ioctlFn)
+	if err != nil {
 		_ = file.Close()
 		return nil, err
 	}
-	if err := ioctl(file.Fd(), ior(fbIOGetFScreenInfo, 0x02, unsafe.Sizeof(finfo)), unsafe.Pointer(&finfo)); err != nil {
+	length := int(finfo.SMemLen)
+	// Some firmware misreports the memory length; catch it here rather than
+	// with an out of range slice on the first write.
+	if err := checkGeometry(int(vinfo.XRes), int(vinfo.YRes), int(finfo.LineLength), length); err != nil {
 		_ = file.Close()
 		return nil, err
 	}
-	if vinfo.BitsPerPixel != 8 {
-		_ = file.Close()
-		return nil, fmt.Errorf("unsupported bpp: %d", vinfo.BitsPerPixel)
-	}
-	length := int(finfo.SMemLen)
 	data, err := syscall.Mmap(int(file.Fd()), 0, length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
 	if err != nil {
 		_ = file.Close()
@@ -138,6 +140,66 @@ func Open(path string) (*Framebuffer, error) {
 	}, nil
 }
 
+func queryScreenInfo(fd uintptr, ioctlFn func(fd uintptr, req uintptr, arg unsafe.Pointer) error) (fbVarScreeninfo, fbFixScreeninfo, error) {
+	var vinfo fbVarScreeninfo
+	var finfo fbFixScreeninfo
+	if err := ioctlFn(fd, ior(fbIOGetVScreenInfo, 0x00, unsafe.Sizeof(vinfo)), unsafe.Pointer(&vinfo)); err != nil {
+		return vinfo, finfo, err
+	}
+	if err := ioctlFn(fd, ior(fbIOGetFScreenInfo, 0x02, unsafe.Sizeof(finfo)), unsafe.Pointer(&finfo)); err != nil {
+		return vinfo, finfo, err
+	}
+	if vinfo.BitsPerPixel != 8 {
+		return vinfo, finfo, fmt.Errorf("unsupported bpp: %d", vinfo.BitsPerPixel)
+	}
+	return vinfo, finfo, nil
+}
+
+func (fb *Framebuffer) Redetect() (bool, error) {
+	if fb == nil || fb.file == nil {
+		return false, nil
+	}
+	ioctlFn := ioctl
+	if fb.ioctlFunc != nil {
+		ioctlFn = fb.ioctlFunc
+	}
+	vinfo, finfo, err := queryScreenInfo(fb.file.Fd(), ioctlFn)
+	if err != nil {
+		return false, err
+	}
+	width, height, stride := int(vinfo.XRes), int(vinfo.YRes), int(finfo.LineLength)
+	if width == fb.Width && height == fb.Height && stride == fb.Stride {
+		return false, nil
+	}
+	if length := int(finfo.SMemLen); length > len(fb.data) {
+		data, err := syscall.Mmap(int(fb.file.Fd()), 0, length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if err != nil {
+			return false, err
+		}
+		_ = syscall.Munmap(fb.data)
+		fb.data = data
+	}
+	if err := checkGeometry(width, height, stride, len(fb.data)); err != nil {
+		return false, err
+	}
+	fb.Width = width
+	fb.Height = height
+	fb.Stride = stride
+	return true, nil
+}
+
+// checkGeometry reports an error unless length bytes of framebuffer memory
+// hold every row of a width x height screen laid out stride bytes apart.
+func checkGeometry(width, height, stride, length int) error {
+	if width <= 0 || height <= 0 || stride < width {
+		return fmt.Errorf("invalid framebuffer geometry %dx%d stride %d", width, height, stride)
+	}
+	if need := stride*(height-1) + width; need > length {
+		return fmt.Errorf("framebuffer memory too small for %dx%d stride %d: need %d bytes, have %d", width, height, stride, need, length)
+	}
+	return nil
+}
+
 func NewFramebufferFromBuffer(width, height int) *Framebuffer {
 	return &Framebuffer{
 		data:   make([]byte, width*height),
@@ -178,6 +240,72 @@ func (fb *Framebuffer) WriteGray(img *image.Gray) error {
 	return nil
 }
 
+func (fb *Framebuffer) WriteGrayRegion(img *image.Gray, at image.Point) (image.Rectangle, error) {
+	if fb == nil || fb.data == nil {
+		return image.Rectangle{}, errors.New("framebuffer not initialized")
+	}
+	src := img.Bounds()
+	dstRect := image.Rectangle{Min: at, Max: at.Add(src.Size())}.Intersect(image.Rect(0, 0, fb.Width, fb.Height))
+	if dstRect.Empty() {
+		return image.Rectangle{}, fmt.Errorf("region at %v outside framebuffer %dx%d", at, fb.Width, fb.Height)
+	}
+	srcMin := src.Min.Add(dstRect.Min.Sub(at))
+	width := dstRect.Dx()
+	for row := 0; row < dstRect.Dy(); row++ {
+		srcOff := img.PixOffset(srcMin.X, srcMin.Y+row)
+		dstOff := (dstRect.Min.Y+row)*fb.Stride + dstRect.Min.X
+		copy(fb.data[dstOff:dstOff+width], img.Pix[srcOff:srcOff+width])
+	}
+	return dstRect, nil
+}
+
+func (fb *Framebuffer) UpdateRegion(img *image.Gray, at image.Point, fast bool) error {
+	region, err := fb.WriteGrayRegion(img, at)
+	if err != nil {
+		return err
+	}
+	return fb.Refresh(Update{Region: region, Fast: fast})
+}
+
+func (fb *Framebuffer) ReadGray() (*image.Gray, error) {
+	if fb == nil || fb.data == nil {
+		return nil, errors.New("framebuffer not initialized")
+	}
+	img := image.NewGray(image.Rect(0, 0, fb.Width, fb.Height))
+	for y := 0; y < fb.Height; y++ {
+		copy(img.Pix[y*img.Stride:y*img.Stride+fb.Width], fb.data[y*fb.Stride:y*fb.Stride+fb.Width])
+	}
+	return img, nil
+}
+
+func (fb *Framebuffer) WriteRaw(pix []byte, stride int) error {
+	if fb == nil || fb.data == nil {
+		return errors.New("framebuffer not initialized")
+	}
+	if stride < fb.Width {
+		return fmt.Errorf("stride %d smaller than framebuffer width %d", stride, fb.Width)
+	}
+	if need := stride*(fb.Height-1) + fb.Width; len(pix) < need {
+		return fmt.Errorf("raw frame has %d bytes, need %d for %dx%d stride %d", len(pix), need, fb.Width, fb.Height, stride)
+	}
+	for y := 0; y < fb.Height; y++ {
+		src := pix[y*stride : y*stride+fb.Width]
+		dst := fb.data[y*fb.Stride : y*fb.Stride+fb.Width]
+		copy(dst, src)
+	}
+	return nil
+}
+
+func (fb *Framebuffer) Write(p []byte) (int, error) {
+	if fb != nil && len(p) != fb.Width*fb.Height {
+		return 0, fmt.Errorf("raw frame has %d bytes, expected %d", len(p), fb.Width*fb.Height)
+	}
+	if err := fb.WriteRaw(p, fb.Width); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
 	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
 	if errno != 0 {
@@ -1,9 +1,15 @@
 package eink
 
 import (
+	"errors"
 	"image"
 	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"unsafe"
 )
 
 func TestFramebufferWriteGray(t *testing.T) {
@@ -25,3 +31,202 @@ func TestFramebufferRefreshNoFile(t *testing.T) {
 		t.Fatalf("refresh: %v", err)
 	}
 }
+
+func TestFramebufferWriteRaw(t *testing.T) {
+	fb := NewFramebufferFromBuffer(3, 2)
+	raw := []byte{1, 2, 3, 99, 4, 5, 6, 99}
+	if err := fb.WriteRaw(raw, 4); err != nil {
+		t.Fatalf("write raw: %v", err)
+	}
+	want := []byte{1, 2, 3, 4, 5, 6}
+	if string(fb.data) != string(want) {
+		t.Fatalf("expected %v, got %v", want, fb.data)
+	}
+	if err := fb.WriteRaw(raw[:5], 4); err == nil {
+		t.Fatalf("expected short buffer error")
+	}
+	if err := fb.WriteRaw(raw, 2); err == nil {
+		t.Fatalf("expected stride error")
+	}
+}
+
+func TestFramebufferWriter(t *testing.T) {
+	fb := NewFramebufferFromBuffer(2, 2)
+	n, err := fb.Write([]byte{10, 20, 30, 40})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if n != 4 || fb.data[3] != 40 {
+		t.Fatalf("unexpected write result n=%d data=%v", n, fb.data)
+	}
+	if _, err := fb.Write([]byte{1}); err == nil {
+		t.Fatalf("expected size mismatch error")
+	}
+}
+
+func TestFramebufferWriteGrayRegion(t *testing.T) {
+	fb := NewFramebufferFromBuffer(6, 4)
+	for i := range fb.data {
+		fb.data[i] = 7
+	}
+	patch := image.NewGray(image.Rect(0, 0, 2, 2))
+	for i := range patch.Pix {
+		patch.Pix[i] = 200
+	}
+	region, err := fb.WriteGrayRegion(patch, image.Pt(3, 1))
+	if err != nil {
+		t.Fatalf("write region: %v", err)
+	}
+	if region != image.Rect(3, 1, 5, 3) {
+		t.Fatalf("unexpected region %v", region)
+	}
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			want := uint8(7)
+			if image.Pt(x, y).In(region) {
+				want = 200
+			}
+			if got := fb.data[y*fb.Stride+x]; got != want {
+				t.Fatalf("pixel (%d,%d): expected %d, got %d", x, y, want, got)
+			}
+		}
+	}
+}
+
+func TestFramebufferWriteGrayRegionClips(t *testing.T) {
+	fb := NewFramebufferFromBuffer(4, 4)
+	patch := image.NewGray(image.Rect(0, 0, 3, 3))
+	patch.SetGray(0, 0, color.Gray{Y: 9})
+	region, err := fb.WriteGrayRegion(patch, image.Pt(3, 3))
+	if err != nil {
+		t.Fatalf("write region: %v", err)
+	}
+	if region != image.Rect(3, 3, 4, 4) || fb.data[3*fb.Stride+3] != 9 {
+		t.Fatalf("expected clipped single pixel write, got %v", region)
+	}
+	if _, err := fb.WriteGrayRegion(patch, image.Pt(10, 10)); err == nil {
+		t.Fatalf("expected out of bounds error")
+	}
+}
+
+func newFakeFileFramebuffer(t *testing.T, width, height int) *Framebuffer {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "fb"))
+	if err != nil {
+		t.Fatalf("create fake fb: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = file.Close()
+	})
+	fb := NewFramebufferFromBuffer(width, height)
+	fb.file = file
+	return fb
+}
+
+// noRefreshRetryDelay makes refresh retries immediate for the test.
+func noRefreshRetryDelay(t *testing.T) {
+	old := refreshRetryDelay
+	refreshRetryDelay = 0
+	t.Cleanup(func() { refreshRetryDelay = old })
+}
+
+func TestFramebufferRefreshRetriesTransientError(t *testing.T) {
+	noRefreshRetryDelay(t)
+	fb := newFakeFileFramebuffer(t, 4, 4)
+	calls := 0
+	fb.ioctlFunc = func(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+		calls++
+		if calls == 1 {
+			return syscall.EBUSY
+		}
+		return nil
+	}
+	if err := fb.Refresh(Update{Full: true}); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 ioctl calls, got %d", calls)
+	}
+}
+
+func TestFramebufferRefreshPassesThroughRealError(t *testing.T) {
+	noRefreshRetryDelay(t)
+	fb := newFakeFileFramebuffer(t, 4, 4)
+	calls := 0
+	fb.ioctlFunc = func(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+		calls++
+		return syscall.EINVAL
+	}
+	if err := fb.Refresh(Update{Full: true}); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("expected EINVAL, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 ioctl call, got %d", calls)
+	}
+}
+
+func TestOpenRejectsShortFramebufferMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fb0")
+	if err := os.WriteFile(path, make([]byte, 64), 0o600); err != nil {
+		t.Fatalf("write fb file: %v", err)
+	}
+	smemLen := uint32(8)
+	fakeIoctl := func(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+		switch req {
+		case ior(fbIOGetVScreenInfo, 0x00, unsafe.Sizeof(fbVarScreeninfo{})):
+			vinfo := (*fbVarScreeninfo)(arg)
+			vinfo.XRes, vinfo.YRes, vinfo.BitsPerPixel = 4, 4, 8
+		case ior(fbIOGetFScreenInfo, 0x02, unsafe.Sizeof(fbFixScreeninfo{})):
+			finfo := (*fbFixScreeninfo)(arg)
+			finfo.LineLength, finfo.SMemLen = 4, smemLen
+		}
+		return nil
+	}
+	fb, err := openWith(path, fakeIoctl)
+	if err == nil {
+		_ = fb.Close()
+		t.Fatalf("expected error for framebuffer memory smaller than the screen")
+	}
+	if !strings.Contains(err.Error(), "too small for 4x4 stride 4: need 16 bytes, have 8") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	smemLen = 16
+	fb, err = openWith(path, fakeIoctl)
+	if err != nil {
+		t.Fatalf("open with enough memory: %v", err)
+	}
+	defer fb.Close()
+	if err := fb.WriteGray(image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestFramebufferRedetect(t *testing.T) {
+	fb := newFakeFileFramebuffer(t, 4, 2)
+	fb.ioctlFunc = func(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+		switch req {
+		case ior(fbIOGetVScreenInfo, 0x00, unsafe.Sizeof(fbVarScreeninfo{})):
+			vinfo := (*fbVarScreeninfo)(arg)
+			vinfo.XRes, vinfo.YRes, vinfo.BitsPerPixel = 2, 4, 8
+		case ior(fbIOGetFScreenInfo, 0x02, unsafe.Sizeof(fbFixScreeninfo{})):
+			finfo := (*fbFixScreeninfo)(arg)
+			finfo.LineLength, finfo.SMemLen = 2, 8
+		}
+		return nil
+	}
+	changed, err := fb.Redetect()
+	if err != nil {
+		t.Fatalf("redetect: %v", err)
+	}
+	if !changed || fb.Width != 2 || fb.Height != 4 || fb.Stride != 2 {
+		t.Fatalf("expected 2x4 stride 2, got %dx%d stride %d (changed=%v)", fb.Width, fb.Height, fb.Stride, changed)
+	}
+	if err := fb.WriteGray(image.NewGray(image.Rect(0, 0, 2, 4))); err != nil {
+		t.Fatalf("write after redetect: %v", err)
+	}
+	changed, err = fb.Redetect()
+	if err != nil || changed {
+		t.Fatalf("expected no change on second redetect, got changed=%v err=%v", changed, err)
+	}
+}
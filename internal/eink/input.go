@@ -13,8 +13,11 @@ const (
 	EVKey = 1
 	EVAbs = 3
 
-	ABSX = 0
-	ABSY = 1
+	ABSX            = 0
+	ABSY            = 1
+	ABSPressure     = 24
+	ABSMTTouchMajor = 48
+	ABSMTPressure   = 58
 
 	BTNToolFinger = 325
 	BTNTouch      = 330
@@ -30,12 +33,16 @@ type InputEvent struct {
 	Value int32
 }
 
+// TouchEvent is one synchronized touch report. Pressure and Size (the
+// contact's major axis) are zero on panels that do not report them.
 type TouchEvent struct {
-	X     int
-	Y     int
-	Down  bool
-	At    time.Time
-	Dirty bool
+	X        int
+	Y        int
+	Down     bool
+	At       time.Time
+	Dirty    bool
+	Pressure int
+	Size     int
 }
 
 type PowerEvent struct {
@@ -44,7 +51,11 @@ type PowerEvent struct {
 }
 
 type InputDevice struct {
-	file *os.File
+	// PalmRejection, when positive, drops every report of a contact whose
+	// size reaches it until that contact lifts.
+	PalmRejection int
+
+	file io.ReadCloser
 }
 
 func OpenInputDevice(path string) (*InputDevice, error) {
@@ -55,6 +66,12 @@ func OpenInputDevice(path string) (*InputDevice, error) {
 	return &InputDevice{file: file}, nil
 }
 
+// NewInputDevice reads evdev events from r instead of a device file, e.g.
+// from a pipe in tests.
+func NewInputDevice(r io.ReadCloser) *InputDevice {
+	return &InputDevice{file: r}
+}
+
 func (d *InputDevice) Close() error {
 	if d == nil || d.file == nil {
 		return nil
@@ -75,8 +92,12 @@ func (d *InputDevice) ReadEvents() (<-chan TouchEvent, <-chan PowerEvent, <-chan
 		var (
 			currentX   = 0
 			currentY   = 0
+			pressure   = 0
+			size       = 0
 			isTouching = false
 			dirty      = false
+			palm       = false
+			down       = false
 		)
 		for {
 			event, err := readInputEvent(d.file)
@@ -96,6 +117,12 @@ func (d *InputDevice) ReadEvents() (<-chan TouchEvent, <-chan PowerEvent, <-chan
 				case ABSY:
 					currentY = int(event.Value)
 					dirty = true
+				case ABSPressure, ABSMTPressure:
+					pressure = int(event.Value)
+					dirty = true
+				case ABSMTTouchMajor:
+					size = int(event.Value)
+					dirty = true
 				}
 			case EVKey:
 				switch event.Code {
@@ -106,9 +133,32 @@ func (d *InputDevice) ReadEvents() (<-chan TouchEvent, <-chan PowerEvent, <-chan
 					powerCh <- PowerEvent{Pressed: event.Value != 0, At: eventTime(event)}
 				}
 			case EVSyn:
-				if dirty {
-					touchCh <- TouchEvent{X: currentX, Y: currentY, Down: isTouching, At: eventTime(event), Dirty: true}
-					dirty = false
+				if !dirty {
+					continue
+				}
+				dirty = false
+				if !palm && d.PalmRejection > 0 && isTouching && size >= d.PalmRejection {
+					palm = true
+					// A contact that grows into a palm has already been
+					// reported down, so end it for consumers.
+					if down {
+						down = false
+						touchCh <- TouchEvent{X: currentX, Y: currentY, Down: false, At: eventTime(event), Dirty: true}
+					}
+				}
+				if palm {
+					if !isTouching {
+						palm = false
+						size = 0
+						pressure = 0
+					}
+					continue
+				}
+				touchCh <- TouchEvent{X: currentX, Y: currentY, Down: isTouching, At: eventTime(event), Dirty: true, Pressure: pressure, Size: size}
+				down = isTouching
+				if !isTouching {
+					size = 0
+					pressure = 0
 				}
 			}
 		}
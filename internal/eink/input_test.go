@@ -3,6 +3,7 @@ package eink
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"testing"
 )
 
@@ -20,3 +21,80 @@ func TestReadInputEvent(t *testing.T) {
 		t.Fatalf("unexpected event")
 	}
 }
+
+func TestReadEventsPalmRejection(t *testing.T) {
+	buf := &bytes.Buffer{}
+	write := func(typ, code uint16, value int32) {
+		if err := binary.Write(buf, binary.LittleEndian, InputEvent{Type: typ, Code: code, Value: value}); err != nil {
+			t.Fatalf("binary write: %v", err)
+		}
+	}
+	// A palm: large contact, then a move, then lift.
+	write(EVAbs, ABSMTTouchMajor, 40)
+	write(EVAbs, ABSX, 10)
+	write(EVAbs, ABSY, 20)
+	write(EVKey, BTNTouch, 1)
+	write(EVSyn, 0, 0)
+	write(EVAbs, ABSX, 12)
+	write(EVAbs, ABSMTTouchMajor, 5)
+	write(EVSyn, 0, 0)
+	write(EVKey, BTNTouch, 0)
+	write(EVSyn, 0, 0)
+	// A fingertip.
+	write(EVAbs, ABSMTTouchMajor, 6)
+	write(EVAbs, ABSMTPressure, 80)
+	write(EVAbs, ABSX, 30)
+	write(EVAbs, ABSY, 40)
+	write(EVKey, BTNTouch, 1)
+	write(EVSyn, 0, 0)
+
+	device := &InputDevice{PalmRejection: 30, file: io.NopCloser(buf)}
+	touchCh, _, _ := device.ReadEvents()
+	var touches []TouchEvent
+	for touch := range touchCh {
+		touches = append(touches, touch)
+	}
+	if len(touches) != 1 {
+		t.Fatalf("expected palm contact to be dropped, got %+v", touches)
+	}
+	if touch := touches[0]; touch.X != 30 || touch.Y != 40 || !touch.Down || touch.Size != 6 || touch.Pressure != 80 {
+		t.Fatalf("unexpected touch %+v", touch)
+	}
+
+	buf.Reset()
+	write(EVAbs, ABSMTTouchMajor, 40)
+	write(EVKey, BTNTouch, 1)
+	write(EVSyn, 0, 0)
+	touchCh, _, _ = (&InputDevice{file: io.NopCloser(buf)}).ReadEvents()
+	touches = touches[:0]
+	for touch := range touchCh {
+		touches = append(touches, touch)
+	}
+	if len(touches) != 1 || touches[0].Size != 40 {
+		t.Fatalf("expected large touch without palm rejection, got %+v", touches)
+	}
+
+	// A fingertip that grows into a palm is lifted once, and the rest of the
+	// contact is dropped.
+	buf.Reset()
+	write(EVAbs, ABSMTTouchMajor, 6)
+	write(EVAbs, ABSX, 10)
+	write(EVAbs, ABSY, 20)
+	write(EVKey, BTNTouch, 1)
+	write(EVSyn, 0, 0)
+	write(EVAbs, ABSX, 14)
+	write(EVAbs, ABSMTTouchMajor, 40)
+	write(EVSyn, 0, 0)
+	write(EVAbs, ABSX, 18)
+	write(EVSyn, 0, 0)
+	write(EVKey, BTNTouch, 0)
+	write(EVSyn, 0, 0)
+	touchCh, _, _ = (&InputDevice{PalmRejection: 30, file: io.NopCloser(buf)}).ReadEvents()
+	touches = touches[:0]
+	for touch := range touchCh {
+		touches = append(touches, touch)
+	}
+	if len(touches) != 2 || !touches[0].Down || touches[1].Down || touches[1].X != 14 {
+		t.Fatalf("expected the contact ended where it became a palm, got %+v", touches)
+	}
+}
@@ -1,8 +1,10 @@
 package eink
 
 import (
+	"errors"
 	"image"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -27,6 +29,10 @@ const (
 	WaveformModeAuto = 257
 )
 
+const refreshRetries = 3
+
+var refreshRetryDelay = 20 * time.Millisecond
+
 type mxcfbRect struct {
 	Top    uint32
 	Left   uint32
@@ -76,9 +82,26 @@ func (fb *Framebuffer) Refresh(update Update) error {
 		Temp:         -1,
 	}
 	req := ioc(iocRead|iocWrite, 'F', 0x2E, unsafe.Sizeof(data))
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fb.file.Fd(), req, uintptr(unsafe.Pointer(&data)))
-	if errno != 0 {
-		return errno
+	var err error
+	for attempt := 0; attempt <= refreshRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(refreshRetryDelay)
+		}
+		err = fb.ioctl(req, unsafe.Pointer(&data))
+		if !isTransientRefreshError(err) {
+			return err
+		}
 	}
-	return nil
+	return err
+}
+
+func (fb *Framebuffer) ioctl(req uintptr, arg unsafe.Pointer) error {
+	if fb.ioctlFunc != nil {
+		return fb.ioctlFunc(fb.file.Fd(), req, arg)
+	}
+	return ioctl(fb.file.Fd(), req, arg)
+}
+
+func isTransientRefreshError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EINTR)
 }
@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// InvokeBatchCap is advertised in connect caps when result batching is
+// configured; the gateway echoes it in hello-ok caps if it accepts batches.
+const InvokeBatchCap = "invoke.batch"
+
+// resultBatcher holds invoke results for a short window so results of
+// invokes processed in quick succession go out in one frame, waking the
+// radio once instead of once per result.
+type resultBatcher struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	enabled bool
+	pending []InvokeResultParams
+}
+
+// setEnabled records whether the current connection accepts batches.
+func (b *resultBatcher) setEnabled(enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.enabled = enabled && b.window > 0
+}
+
+// add queues result, reporting false if batching is off and the caller
+// should send it itself. The first result of a batch schedules flush after
+// the window.
+func (b *resultBatcher) add(result InvokeResultParams, flush func()) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.enabled {
+		return false
+	}
+	b.pending = append(b.pending, result)
+	if len(b.pending) == 1 {
+		time.AfterFunc(b.window, flush)
+	}
+	return true
+}
+
+func (b *resultBatcher) take() []InvokeResultParams {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending := b.pending
+	b.pending = nil
+	return pending
+}
+
+// flushInvokeResults sends the batched results, as a plain
+// node.invoke.result frame when only one arrived in the window.
+func (c *Client) flushInvokeResults() {
+	results := c.batcher.take()
+	if len(results) == 0 {
+		return
+	}
+	var err error
+	if len(results) == 1 {
+		err = c.sendResultFrame(context.Background(), "node.invoke.result", results[0])
+	} else {
+		err = c.sendResultFrame(context.Background(), "node.invoke.results", results)
+	}
+	if err != nil {
+		c.logger.Warn().Err(err).Int("results", len(results)).Msg("gateway: failed to send batched invoke results")
+	}
+}
+
+func (c *Client) sendResultFrame(ctx context.Context, method string, params interface{}) error {
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.sendFrame(ctx, RequestFrame{
+		Type:   "req",
+		ID:     c.nextID(),
+		Method: method,
+		Params: payload,
+	})
+}
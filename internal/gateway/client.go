@@ -8,6 +8,7 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -21,6 +22,8 @@ type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn,
 
 type InvokeHandler func(ctx context.Context, req InvokeRequestParams) (interface{}, error)
 
+type ProgressReporter func(ctx context.Context, progress float64, message string) error
+
 type wsConn interface {
 	WriteMessage(messageType int, data []byte) error
 	ReadMessage() (messageType int, p []byte, err error)
@@ -32,24 +35,62 @@ type wsConn interface {
 }
 
 var errGatewayShutdown = errors.New("gateway: shutdown")
+var errGatewayFailed = errors.New("gateway: shutdown on error")
+var errGatewayMigrate = errors.New("gateway: migrating")
+var errHandshakeTimeout = errors.New("gateway: handshake timed out")
+
+var errConnectTimeout = errors.New("gateway: connect timed out")
+
+// Shutdown reasons sent in the gateway's shutdown event. Unknown reasons are
+// treated as maintenance.
+const (
+	ShutdownMaintenance = "maintenance"
+	ShutdownError       = "error"
+	ShutdownMigrate     = "migrate"
+)
 
 type Client struct {
-	url             string
-	header          http.Header
-	dialer          DialContextFunc
-	logger          zerolog.Logger
-	register        NodeRegistration
-	onInvoke        InvokeHandler
-	onRegistered    func(context.Context) error
-	connectAuth     *ConnectAuth
-	identity        *DeviceIdentity
-	deviceToken     string
-	deviceTokenPath string
-	connMu          sync.Mutex
-	conn            wsConn
-	writeMu         sync.Mutex
-	requestSeq      atomic.Uint64
-	pingInterval    time.Duration
+	urls             []string
+	urlIndex         atomic.Int32
+	header           http.Header
+	dialer           DialContextFunc
+	logger           zerolog.Logger
+	register         NodeRegistration
+	onInvoke         InvokeHandler
+	onRegistered     func(context.Context) error
+	onConfig         func(context.Context, json.RawMessage) error
+	onTheme          func(context.Context, json.RawMessage) error
+	onTokenCleared   func(reason string)
+	connectAuth      *ConnectAuth
+	identity         *DeviceIdentity
+	deviceToken      string
+	deviceTokenPath  string
+	connMu           sync.Mutex
+	conn             wsConn
+	readDone         chan struct{}
+	nodeID           string
+	forceReconnect   bool
+	writeMu          sync.Mutex
+	requestSeq       atomic.Uint64
+	ping             *adaptivePing
+	handshakeTimeout time.Duration
+	connectTimeout   time.Duration
+	closeTimeout     time.Duration
+	keepaliveEvent   string
+	heartbeat        func() interface{}
+	heartbeatEvery   time.Duration
+	initialBackoff   time.Duration
+	stableAfter      time.Duration
+	commandScopes    map[string][]string
+	grantedScopes    map[string]bool
+	invokes          *invokeQueue
+	omitDeviceInfo   bool
+	tokenClearWords  []string
+	batcher          resultBatcher
+	maxResultBytes   int
+	unknownFrames    atomic.Uint64
+	unknownLogged    atomic.Uint64
+	unknownLogAt     atomic.Int64
 }
 
 type backoffProvider interface {
@@ -74,18 +115,35 @@ func (e backoffError) Backoff() time.Duration {
 }
 
 type Config struct {
-	URL             string
-	Header          http.Header
-	Dialer          DialContextFunc
-	Logger          zerolog.Logger
-	Register        NodeRegistration
-	OnInvoke        InvokeHandler
-	OnRegistered    func(context.Context) error
-	PingInterval    time.Duration
-	AuthToken       string
-	AuthPassword    string
-	Identity        *DeviceIdentity
-	DeviceTokenPath string
+	URL               string
+	AlternateURLs     []string
+	Header            http.Header
+	Dialer            DialContextFunc
+	Logger            zerolog.Logger
+	Register          NodeRegistration
+	OnInvoke          InvokeHandler
+	OnRegistered      func(context.Context) error
+	OnConfig          func(context.Context, json.RawMessage) error
+	OnTheme           func(context.Context, json.RawMessage) error
+	OnTokenCleared    func(reason string)
+	PingInterval      time.Duration
+	MinPingInterval   time.Duration
+	MaxPingInterval   time.Duration
+	HandshakeTimeout  time.Duration
+	ConnectTimeout    time.Duration
+	CloseTimeout      time.Duration
+	KeepaliveEvent    string
+	Heartbeat         func() interface{}
+	HeartbeatInterval time.Duration
+	AuthToken         string
+	AuthPassword      string
+	Identity          *DeviceIdentity
+	DeviceTokenPath   string
+	ScopeCommands     map[string][]string
+	OmitDeviceInfo    bool
+	TokenClearReasons []string
+	InvokeBatchWindow time.Duration
+	MaxResultBytes    int
 }
 
 func New(cfg Config) *Client {
@@ -93,6 +151,29 @@ func New(cfg Config) *Client {
 	if pingInterval == 0 {
 		pingInterval = 30 * time.Second
 	}
+	keepaliveEvent := cfg.KeepaliveEvent
+	if keepaliveEvent == "" {
+		keepaliveEvent = "ping"
+	}
+	handshakeTimeout := cfg.HandshakeTimeout
+	if handshakeTimeout == 0 {
+		handshakeTimeout = 30 * time.Second
+	}
+	connectTimeout := cfg.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = 30 * time.Second
+	}
+	closeTimeout := cfg.CloseTimeout
+	if closeTimeout == 0 {
+		closeTimeout = time.Second
+	}
+	tokenClearWords := defaultTokenClearReasons
+	if len(cfg.TokenClearReasons) > 0 {
+		tokenClearWords = make([]string, len(cfg.TokenClearReasons))
+		for i, reason := range cfg.TokenClearReasons {
+			tokenClearWords[i] = strings.ToLower(reason)
+		}
+	}
 	var connectAuth *ConnectAuth
 	if cfg.AuthToken != "" || cfg.AuthPassword != "" {
 		connectAuth = &ConnectAuth{
@@ -110,18 +191,34 @@ func New(cfg Config) *Client {
 		}
 	}
 	return &Client{
-		url:             cfg.URL,
-		header:          cfg.Header,
-		dialer:          cfg.Dialer,
-		logger:          cfg.Logger,
-		register:        cfg.Register,
-		onInvoke:        cfg.OnInvoke,
-		onRegistered:    cfg.OnRegistered,
-		connectAuth:     connectAuth,
-		identity:        cfg.Identity,
-		deviceToken:     deviceToken,
-		deviceTokenPath: cfg.DeviceTokenPath,
-		pingInterval:    pingInterval,
+		urls:             append([]string{cfg.URL}, cfg.AlternateURLs...),
+		header:           cfg.Header,
+		dialer:           cfg.Dialer,
+		logger:           cfg.Logger,
+		register:         cfg.Register,
+		onInvoke:         cfg.OnInvoke,
+		onRegistered:     cfg.OnRegistered,
+		onConfig:         cfg.OnConfig,
+		onTheme:          cfg.OnTheme,
+		onTokenCleared:   cfg.OnTokenCleared,
+		connectAuth:      connectAuth,
+		identity:         cfg.Identity,
+		deviceToken:      deviceToken,
+		deviceTokenPath:  cfg.DeviceTokenPath,
+		ping:             newAdaptivePing(pingInterval, cfg.MinPingInterval, cfg.MaxPingInterval),
+		handshakeTimeout: handshakeTimeout,
+		connectTimeout:   connectTimeout,
+		closeTimeout:     closeTimeout,
+		keepaliveEvent:   keepaliveEvent,
+		heartbeat:        cfg.Heartbeat,
+		heartbeatEvery:   cfg.HeartbeatInterval,
+		initialBackoff:   time.Second,
+		stableAfter:      30 * time.Second,
+		commandScopes:    commandScopes(cfg.ScopeCommands),
+		omitDeviceInfo:   cfg.OmitDeviceInfo,
+		tokenClearWords:  tokenClearWords,
+		batcher:          resultBatcher{window: cfg.InvokeBatchWindow},
+		maxResultBytes:   cfg.MaxResultBytes,
 	}
 }
 
@@ -132,7 +229,7 @@ func (c *Client) Run(ctx context.Context) error {
 	if c.onInvoke == nil {
 		return errors.New("gateway: invoke handler required")
 	}
-	backoff := time.Second
+	backoff := c.initialBackoff
 	for {
 		if ctx.Err() != nil {
 			return ctx.Err()
@@ -147,23 +244,52 @@ func (c *Client) Run(ctx context.Context) error {
 		}
 		c.setConn(conn)
 		if err := c.registerNode(ctx); err != nil {
-			c.logger.Error().Err(err).Msg("gateway registration failed")
 			c.closeConn()
+			if c.takeForceReconnect() && ctx.Err() == nil {
+				c.logger.Info().Msg("gateway reconnecting on request")
+				backoff = c.initialBackoff
+				continue
+			}
+			c.logger.Error().Err(err).Msg("gateway registration failed")
+			if IsTerminal(err) {
+				return err
+			}
 			c.applyBackoffOverride(err, &backoff)
 			if err := c.waitBackoff(ctx, &backoff); err != nil {
 				return err
 			}
 			continue
 		}
-		backoff = time.Second
+		connectedAt := time.Now()
 		if c.onRegistered != nil {
 			if err := c.onRegistered(ctx); err != nil {
 				c.logger.Warn().Err(err).Msg("gateway registered callback failed")
 			}
 		}
 		if err := c.readLoop(ctx); err != nil {
-			c.logger.Warn().Err(err).Msg("gateway read loop ended")
 			c.closeConn()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if c.takeForceReconnect() && ctx.Err() == nil {
+				c.logger.Info().Msg("gateway reconnecting on request")
+				backoff = c.initialBackoff
+				continue
+			}
+			if errors.Is(err, errGatewayMigrate) {
+				backoff = c.initialBackoff
+				continue
+			}
+			c.logger.Warn().Err(err).Msg("gateway read loop ended")
+			c.ping.dropped()
+			// Only a connection that stayed up for a while earns a fresh
+			// backoff; one that drops right after registering keeps growing it.
+			if time.Since(connectedAt) >= c.stableAfter {
+				backoff = c.initialBackoff
+			}
+			if IsTerminal(err) {
+				return err
+			}
 			c.applyBackoffOverride(err, &backoff)
 			if err := c.waitBackoff(ctx, &backoff); err != nil {
 				return err
@@ -173,7 +299,45 @@ func (c *Client) Run(ctx context.Context) error {
 	}
 }
 
+// handleShutdown picks how to reconnect after a shutdown event. Maintenance
+// waits out the announced restart on the same gateway; an error shutdown
+// falls back to the usual growing backoff, since the restart estimate of a
+// failing gateway is not to be trusted; a migration moves to the next
+// configured gateway URL right away.
+func (c *Client) handleShutdown(payload ShutdownPayload) error {
+	restart := time.Duration(payload.RestartExpectedMs) * time.Millisecond
+	switch payload.Reason {
+	case ShutdownMigrate:
+		if len(c.urls) > 1 {
+			url := c.nextURL()
+			c.logger.Info().Str("url", url).Msg("gateway migrating, reconnecting to alternate")
+			return errGatewayMigrate
+		}
+	case ShutdownError:
+		c.logger.Warn().Msg("gateway shut down on error")
+		return backoffError{err: errGatewayFailed, backoff: restart}
+	}
+	if restart <= 0 {
+		restart = time.Second
+	}
+	c.logger.Info().Str("reason", payload.Reason).Msg(fmt.Sprintf("gateway shutting down, reconnect in %dms", restart.Milliseconds()))
+	return backoffError{err: errGatewayShutdown, backoff: restart}
+}
+
+func (c *Client) currentURL() string {
+	return c.urls[int(c.urlIndex.Load())%len(c.urls)]
+}
+
+func (c *Client) nextURL() string {
+	c.urlIndex.Store(int32((int(c.urlIndex.Load()) + 1) % len(c.urls)))
+	return c.currentURL()
+}
+
 func (c *Client) SendEvent(ctx context.Context, method string, params interface{}) error {
+	if event, ok := params.(NodeEventParams); ok && event.NodeID == "" {
+		event.NodeID = c.NodeID()
+		params = event
+	}
 	payload, err := json.Marshal(params)
 	if err != nil {
 		return err
@@ -212,16 +376,23 @@ func (c *Client) connect(ctx context.Context) (wsConn, error) {
 		HandshakeTimeout: 10 * time.Second,
 		NetDialContext:   c.dialer,
 	}
-	conn, _, err := dialer.DialContext(ctx, c.url, c.header)
+	// The upgrade has its own timeout, but dialing through tsnet can stall
+	// before it starts, so bound the whole connect too.
+	dialCtx, cancel := context.WithTimeout(ctx, c.connectTimeout)
+	defer cancel()
+	conn, _, err := dialer.DialContext(dialCtx, c.currentURL(), c.header)
 	if err != nil {
+		if ctx.Err() == nil && errors.Is(dialCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %v: %w", errConnectTimeout, c.connectTimeout, err)
+		}
 		return nil, err
 	}
 	conn.SetReadLimit(8 << 20)
 	conn.SetPongHandler(func(string) error {
-		_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		_ = conn.SetReadDeadline(time.Now().Add(c.ping.readTimeout()))
 		return nil
 	})
-	_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	_ = conn.SetReadDeadline(time.Now().Add(c.ping.readTimeout()))
 	return conn, nil
 }
 
@@ -245,15 +416,42 @@ func (c *Client) registerNode(ctx context.Context) error {
 		connectID = req.ID
 		return nil
 	}
+	// Invokes sent before hello-ok are held until it has set the node id
+	// and scopes, then handed to the read loop.
+	invokes := newInvokeQueue(ctx, c.logger)
+	release := invokes.hold()
+	registered := false
+	defer func() {
+		release()
+		if !registered {
+			invokes.stop()
+			return
+		}
+		c.connMu.Lock()
+		c.invokes = invokes
+		c.connMu.Unlock()
+	}()
+	deadline := time.Now().Add(c.handshakeTimeout)
+	_ = conn.SetReadDeadline(deadline)
 	for {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 		_, data, err := conn.ReadMessage()
 		if err != nil {
+			if !time.Now().Before(deadline) {
+				return errHandshakeTimeout
+			}
 			return c.handleCloseError(err)
 		}
-		_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		if !time.Now().Before(deadline) {
+			return errHandshakeTimeout
+		}
+		readDeadline := time.Now().Add(60 * time.Second)
+		if deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+		_ = conn.SetReadDeadline(readDeadline)
 		var base struct {
 			Type string `json:"type"`
 		}
@@ -279,18 +477,32 @@ func (c *Client) registerNode(ctx context.Context) error {
 				if payload.Nonce == "" || payload.Nonce == nonce {
 					continue
 				}
+				if connectSent {
+					c.logger.Info().Msg("gateway: re-challenged with new nonce, resending connect")
+				}
 				nonce = payload.Nonce
-				if !connectSent {
-					if err := sendConnect(nonce); err != nil {
-						return err
-					}
+				if err := sendConnect(nonce); err != nil {
+					return err
 				}
 			case "tick":
 				c.logger.Debug().Msg("gateway: tick")
+			case "node.invoke.request":
+				if err := c.queueInvoke(invokes, evt.Payload); err != nil {
+					c.logger.Warn().Err(err).Msg("gateway: invoke handler error")
+				}
 			default:
 			}
 			continue
 		}
+		if base.Type == "req" {
+			var req RequestFrame
+			if err := json.Unmarshal(data, &req); err == nil && req.Method == "node.invoke.request" {
+				if err := c.queueInvoke(invokes, req.Params); err != nil {
+					c.logger.Warn().Err(err).Msg("gateway: invoke handler error")
+				}
+			}
+			continue
+		}
 		if base.Type != "res" {
 			continue
 		}
@@ -315,14 +527,24 @@ func (c *Client) registerNode(ctx context.Context) error {
 		if hello.Type != "hello-ok" {
 			return errors.New("gateway: unexpected handshake payload")
 		}
+		if hello.Auth != nil && hello.Auth.NodeID != "" {
+			c.setNodeID(hello.Auth.NodeID)
+		}
+		var scopes []string
+		if hello.Auth != nil {
+			scopes = hello.Auth.Scopes
+		}
+		c.setGrantedScopes(scopes)
+		c.batcher.setEnabled(slices.Contains(hello.Caps, InvokeBatchCap))
 		if hello.Auth != nil && hello.Auth.DeviceToken != "" {
 			c.deviceToken = hello.Auth.DeviceToken
 			if c.deviceTokenPath != "" {
 				if err := SaveDeviceToken(c.deviceTokenPath, c.deviceToken); err != nil {
-					c.logger.Warn().Err(err).Msg("gateway: failed to save device token")
+					c.logger.Warn().Err(err).Msg("gateway: failed to save device token, keeping it in memory only")
 				}
 			}
 		}
+		registered = true
 		return nil
 	}
 }
@@ -332,9 +554,29 @@ func (c *Client) readLoop(ctx context.Context) error {
 	if conn == nil {
 		return errors.New("gateway: no connection")
 	}
+	readDone := make(chan struct{})
+	c.connMu.Lock()
+	c.readDone = readDone
+	c.connMu.Unlock()
+	defer close(readDone)
 	done := make(chan struct{})
 	go c.pingLoop(ctx, conn, done)
+	if c.heartbeat != nil && c.heartbeatEvery > 0 {
+		go c.heartbeatLoop(ctx, done)
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.closeGracefully(context.Background())
+		case <-done:
+		}
+	}()
 	defer close(done)
+	invokes := c.takeInvokes()
+	if invokes == nil {
+		invokes = newInvokeQueue(ctx, c.logger)
+	}
+	defer invokes.stop()
 	for {
 		if ctx.Err() != nil {
 			return ctx.Err()
@@ -343,7 +585,7 @@ func (c *Client) readLoop(ctx context.Context) error {
 		if err != nil {
 			return c.handleCloseError(err)
 		}
-		_ = conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		_ = conn.SetReadDeadline(time.Now().Add(c.ping.readTimeout()))
 		var base struct {
 			Type string `json:"type"`
 		}
@@ -358,9 +600,15 @@ func (c *Client) readLoop(ctx context.Context) error {
 				c.logger.Warn().Err(err).Msg("gateway: invalid event frame")
 				continue
 			}
+			if evt.Event == c.keepaliveEvent {
+				if err := c.sendPong(ctx, evt); err != nil {
+					c.logger.Warn().Err(err).Msg("gateway: failed to reply to keepalive")
+				}
+				continue
+			}
 			switch evt.Event {
 			case "node.invoke.request":
-				if err := c.handleInvokeEvent(ctx, evt); err != nil {
+				if err := c.queueInvoke(invokes, evt.Payload); err != nil {
 					c.logger.Warn().Err(err).Msg("gateway: invoke handler error")
 				}
 			case "shutdown":
@@ -369,17 +617,28 @@ func (c *Client) readLoop(ctx context.Context) error {
 					c.logger.Warn().Err(err).Msg("gateway: invalid shutdown payload")
 					return err
 				}
-				restartMs := payload.RestartExpectedMs
-				if restartMs <= 0 {
-					restartMs = int(time.Second / time.Millisecond)
+				return c.handleShutdown(payload)
+			case "node.config":
+				if c.onConfig == nil {
+					continue
+				}
+				if err := c.onConfig(ctx, evt.Payload); err != nil {
+					c.logger.Warn().Err(err).Msg("gateway: failed to apply node config")
+				}
+			case "node.theme":
+				if c.onTheme == nil {
+					continue
+				}
+				if err := c.onTheme(ctx, evt.Payload); err != nil {
+					c.logger.Warn().Err(err).Msg("gateway: failed to apply node theme")
 				}
-				c.logger.Info().Str("reason", payload.Reason).Msg(fmt.Sprintf("gateway shutting down, reconnect in %dms", restartMs))
-				return backoffError{err: errGatewayShutdown, backoff: time.Duration(restartMs) * time.Millisecond}
 			case "tick":
 				c.logger.Debug().Msg("gateway: tick")
 				continue
 			case "connect.challenge", "voicewake.changed":
 				continue
+			default:
+				c.noteUnknownFrame("event", evt.Event)
 			}
 		case "req":
 			var req RequestFrame
@@ -387,36 +646,95 @@ func (c *Client) readLoop(ctx context.Context) error {
 				c.logger.Warn().Err(err).Msg("gateway: invalid request frame")
 				continue
 			}
-			if req.Method == "node.invoke.request" {
-				if err := c.handleInvokeRequest(ctx, req); err != nil {
-					c.logger.Warn().Err(err).Msg("gateway: invoke handler error")
-				}
+			if req.Method != "node.invoke.request" {
+				c.noteUnknownFrame("req", req.Method)
+				continue
+			}
+			if err := c.queueInvoke(invokes, req.Params); err != nil {
+				c.logger.Warn().Err(err).Msg("gateway: invoke handler error")
 			}
 		case "res":
 			continue
+		default:
+			c.noteUnknownFrame(base.Type, "")
 		}
 	}
 }
 
-func (c *Client) handleInvokeEvent(ctx context.Context, evt EventFrame) error {
-	params, err := parseInvokePayload(evt.Payload)
-	if err != nil {
-		return err
+// unknownFrameLogEvery bounds how often unknown frames are logged, so a
+// gateway sending a new event at a high rate does not flood the log.
+const unknownFrameLogEvery = time.Minute
+
+// noteUnknownFrame counts a frame the node does not handle, a sign of
+// protocol drift between node and gateway, and logs it at debug level at
+// most once per unknownFrameLogEvery, with how many went unlogged.
+func (c *Client) noteUnknownFrame(frameType, name string) {
+	total := c.unknownFrames.Add(1)
+	now := time.Now().UnixNano()
+	last := c.unknownLogAt.Load()
+	if last != 0 && now-last < int64(unknownFrameLogEvery) || !c.unknownLogAt.CompareAndSwap(last, now) {
+		return
 	}
-	return c.handleInvoke(ctx, params)
+	suppressed := total - c.unknownLogged.Swap(total) - 1
+	c.logger.Debug().Str("type", frameType).Str("name", name).Uint64("suppressed", suppressed).Msg("gateway: unhandled frame")
 }
 
-func (c *Client) handleInvokeRequest(ctx context.Context, req RequestFrame) error {
-	params, err := parseInvokePayload(req.Params)
-	if err != nil {
-		return err
+// UnknownFrames returns how many frames the gateway sent that the node did
+// not handle: unknown frame types, events, and request methods.
+func (c *Client) UnknownFrames() uint64 {
+	return c.unknownFrames.Load()
+}
+
+func (c *Client) sendPong(ctx context.Context, evt EventFrame) error {
+	payload := map[string]interface{}{
+		"ts": time.Now().UnixMilli(),
+	}
+	if len(evt.Payload) > 0 {
+		payload["echo"] = evt.Payload
 	}
-	return c.handleInvoke(ctx, params)
+	return c.SendEvent(ctx, "node.event", NodeEventParams{
+		Event:   "pong",
+		Payload: payload,
+	})
 }
 
 func (c *Client) handleInvoke(ctx context.Context, params InvokeRequestParams) error {
+	if params.NodeID == "" {
+		params.NodeID = c.NodeID()
+	}
+	logCtx := c.logger.With().
+		Str("requestId", params.RequestID).
+		Str("command", params.Command).
+		Str("nodeId", params.NodeID)
+	if params.TraceID != "" {
+		logCtx = logCtx.Str("traceId", params.TraceID)
+	}
+	logger := logCtx.Logger()
+	ctx = logger.WithContext(ctx)
+	logger.Debug().Msg("gateway: invoke received")
+	if err := c.checkPermission(params.Command); err != nil {
+		logger.Warn().Msg("gateway: invoke denied, missing scope")
+		return c.sendInvokeResult(ctx, params, nil, err)
+	}
+	params.Progress = c.progressReporter(params)
 	result, err := c.onInvoke(ctx, params)
-	return c.sendInvokeResult(ctx, params, result, err)
+	// A superseded invoke still reports its result.
+	return c.sendInvokeResult(context.WithoutCancel(ctx), params, result, err)
+}
+
+func (c *Client) progressReporter(req InvokeRequestParams) ProgressReporter {
+	return func(ctx context.Context, progress float64, message string) error {
+		return c.SendEvent(ctx, "node.event", NodeEventParams{
+			Event: "node.invoke.progress",
+			Payload: InvokeProgressPayload{
+				RequestID: req.RequestID,
+				NodeID:    req.NodeID,
+				Command:   req.Command,
+				Progress:  progress,
+				Message:   message,
+			},
+		})
+	}
 }
 
 func (c *Client) sendInvokeResult(ctx context.Context, req InvokeRequestParams, result interface{}, err error) error {
@@ -428,18 +746,40 @@ func (c *Client) sendInvokeResult(ctx context.Context, req InvokeRequestParams,
 	}
 	if err != nil {
 		params.Error = &NodeInvokeError{Message: err.Error()}
+		var invokeErr *InvokeError
+		if errors.As(err, &invokeErr) {
+			params.Error.Code = invokeErr.Code
+		}
 	}
-	payload, marshalErr := json.Marshal(params)
-	if marshalErr != nil {
-		return marshalErr
+	c.limitResult(&params)
+	if c.batcher.add(params, c.flushInvokeResults) {
+		return nil
 	}
-	frame := RequestFrame{
-		Type:   "req",
-		ID:     c.nextID(),
-		Method: "node.invoke.result",
-		Params: payload,
+	return c.sendResultFrame(ctx, "node.invoke.result", params)
+}
+
+// limitResult replaces a result over maxResultBytes with a
+// result_too_large error giving its size, since the gateway would reject
+// the whole frame.
+func (c *Client) limitResult(params *InvokeResultParams) {
+	if c.maxResultBytes <= 0 || params.Result == nil {
+		return
+	}
+	encoded, err := json.Marshal(params.Result)
+	if err != nil {
+		return
+	}
+	if len(encoded) <= c.maxResultBytes {
+		params.Result = json.RawMessage(encoded)
+		return
+	}
+	c.logger.Warn().Str("requestId", params.RequestID).Int("bytes", len(encoded)).Int("limit", c.maxResultBytes).Msg("gateway: invoke result too large")
+	params.OK = false
+	params.Result = nil
+	params.Error = &NodeInvokeError{
+		Code:    ErrCodeResultTooLarge,
+		Message: fmt.Sprintf("result is %d bytes, over the %d byte limit", len(encoded), c.maxResultBytes),
 	}
-	return c.sendFrame(ctx, frame)
 }
 
 func parseInvokePayload(raw json.RawMessage) (InvokeRequestParams, error) {
@@ -450,11 +790,12 @@ func parseInvokePayload(raw json.RawMessage) (InvokeRequestParams, error) {
 		ParamsJSON     *string         `json:"paramsJSON,omitempty"`
 		Params         json.RawMessage `json:"params,omitempty"`
 		IdempotencyKey string          `json:"idempotencyKey,omitempty"`
+		TraceID        string          `json:"traceId,omitempty"`
 	}
 	if err := json.Unmarshal(raw, &payload); err != nil {
 		return InvokeRequestParams{}, err
 	}
-	if payload.ID == "" || payload.NodeID == "" || payload.Command == "" {
+	if payload.ID == "" || payload.Command == "" {
 		return InvokeRequestParams{}, errors.New("gateway: invalid invoke payload")
 	}
 	var args json.RawMessage
@@ -468,22 +809,42 @@ func parseInvokePayload(raw json.RawMessage) (InvokeRequestParams, error) {
 		NodeID:    payload.NodeID,
 		Command:   payload.Command,
 		Args:      args,
+		Params:    raw,
+		TraceID:   payload.TraceID,
 	}, nil
 }
 
 func (c *Client) pingLoop(ctx context.Context, conn wsConn, done <-chan struct{}) {
-	ticker := time.NewTicker(c.pingInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(c.ping.current())
+	defer timer.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-done:
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			if err := c.writeMessage(conn, websocket.PingMessage, nil); err != nil {
 				return
 			}
+			timer.Reset(c.ping.next())
+		}
+	}
+}
+
+func (c *Client) heartbeatLoop(ctx context.Context, done <-chan struct{}) {
+	ticker := time.NewTicker(c.heartbeatEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.SendEvent(ctx, "node.event", NodeEventParams{Event: "heartbeat", Payload: c.heartbeat()}); err != nil {
+				c.logger.Debug().Err(err).Msg("gateway: failed to send heartbeat")
+			}
 		}
 	}
 }
@@ -504,6 +865,84 @@ func (c *Client) setConn(conn wsConn) {
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
 	c.conn = conn
+	c.forceReconnect = false
+}
+
+// Connected reports whether the client currently has a gateway connection.
+func (c *Client) Connected() bool {
+	return c.getConn() != nil
+}
+
+// NodeID returns the node id assigned by the gateway in hello-ok, or an
+// empty string if it has not assigned one.
+func (c *Client) NodeID() string {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.nodeID
+}
+
+func (c *Client) setNodeID(id string) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.nodeID = id
+}
+
+// takeInvokes returns the invokes queued during registration, if any.
+func (c *Client) takeInvokes() *invokeQueue {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	invokes := c.invokes
+	c.invokes = nil
+	return invokes
+}
+
+func (c *Client) setGrantedScopes(scopes []string) {
+	granted := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		granted[scope] = true
+	}
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.grantedScopes = granted
+}
+
+// commandScopes inverts a scope-to-commands map into the scopes that allow
+// each command; holding any one of them is enough.
+func commandScopes(byScope map[string][]string) map[string][]string {
+	if len(byScope) == 0 {
+		return nil
+	}
+	scopes := make(map[string][]string)
+	for scope, commands := range byScope {
+		for _, command := range commands {
+			scopes[command] = append(scopes[command], scope)
+		}
+	}
+	return scopes
+}
+
+// checkPermission allows a mapped command if any scope allowing it was
+// granted. Once the gateway grants scopes, canvas commands missing from the
+// map are denied, so one added later cannot render unchecked.
+func (c *Client) checkPermission(command string) error {
+	if len(c.commandScopes) == 0 {
+		return nil
+	}
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	scopes, gated := c.commandScopes[command]
+	if !gated {
+		if len(c.grantedScopes) > 0 && strings.HasPrefix(command, "canvas.") {
+			return &InvokeError{Code: ErrCodePermissionDenied, Message: fmt.Sprintf("%s is not allowed by any granted scope", command)}
+		}
+		return nil
+	}
+	for _, scope := range scopes {
+		if c.grantedScopes[scope] {
+			return nil
+		}
+	}
+	return &InvokeError{Code: ErrCodePermissionDenied, Message: fmt.Sprintf("%s requires scope %q", command, strings.Join(scopes, `" or "`))}
 }
 
 func (c *Client) closeConn() {
@@ -515,6 +954,53 @@ func (c *Client) closeConn() {
 	}
 }
 
+// closeGracefully sends a normal close frame and gives the gateway until the
+// close timeout, or until ctx is done, to echo it before dropping the
+// connection, so the gateway sees a clean disconnect. The read loop sees the
+// echo; without one, the read deadline ends it by the timeout.
+func (c *Client) closeGracefully(ctx context.Context) {
+	c.connMu.Lock()
+	conn, readDone := c.conn, c.readDone
+	c.connMu.Unlock()
+	if conn == nil {
+		return
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := c.writeMessage(conn, websocket.CloseMessage, msg); err == nil {
+		_ = conn.SetReadDeadline(time.Now().Add(c.closeTimeout))
+		timer := time.NewTimer(c.closeTimeout)
+		select {
+		case <-readDone:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	}
+	c.closeConn()
+}
+
+// ForceReconnect drops the current connection so Run reconnects at once
+// with a fresh backoff. It reports whether there was a connection to drop.
+func (c *Client) ForceReconnect() bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn == nil {
+		return false
+	}
+	c.forceReconnect = true
+	_ = c.conn.Close()
+	c.conn = nil
+	return true
+}
+
+func (c *Client) takeForceReconnect() bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	forced := c.forceReconnect
+	c.forceReconnect = false
+	return forced
+}
+
 func (c *Client) waitBackoff(ctx context.Context, backoff *time.Duration) error {
 	timer := time.NewTimer(*backoff)
 	select {
@@ -576,11 +1062,20 @@ func (c *Client) selectConnectAuth() (*ConnectAuth, string) {
 	return nil, ""
 }
 
+func (c *Client) connectCaps() []string {
+	if c.batcher.window <= 0 {
+		return c.register.Caps
+	}
+	return append(slices.Clip(c.register.Caps), InvokeBatchCap)
+}
+
 func (c *Client) buildConnectRequest(nonce string) (RequestFrame, error) {
 	id := c.nextID()
 	auth, tokenForPayload := c.selectConnectAuth()
 	var deviceInfo *DeviceInfo
-	if c.identity != nil {
+	// Gateways that authenticate by shared secret alone may reject device
+	// info they did not expect, so it can be left out even with an identity.
+	if c.identity != nil && !c.omitDeviceInfo {
 		signedAtMs := time.Now().UnixMilli()
 		payload := BuildDeviceAuthPayload(
 			c.identity.DeviceID,
@@ -605,7 +1100,7 @@ func (c *Client) buildConnectRequest(nonce string) (RequestFrame, error) {
 		MaxProtocol: ProtocolVersion,
 		Client:      c.register.Client,
 		Role:        c.register.Role,
-		Caps:        c.register.Caps,
+		Caps:        c.connectCaps(),
 		Commands:    c.register.Commands,
 		Permissions: c.register.Permissions,
 		PathEnv:     c.register.PathEnv,
@@ -626,15 +1121,66 @@ func (c *Client) buildConnectRequest(nonce string) (RequestFrame, error) {
 	}, nil
 }
 
+var closeCodeBackoff = map[int]time.Duration{
+	websocket.CloseGoingAway:         2 * time.Second,
+	websocket.CloseInternalServerErr: 5 * time.Second,
+	websocket.CloseServiceRestart:    5 * time.Second,
+	websocket.CloseTryAgainLater:     30 * time.Second,
+}
+
+// defaultTokenClearReasons are the policy-violation close reasons that mean
+// the saved device token is no longer valid.
+var defaultTokenClearReasons = []string{
+	"device token mismatch",
+}
+
+var terminalCloseReasons = []string{
+	"banned",
+	"revoked",
+	"node disabled",
+}
+
+type terminalError struct {
+	err error
+}
+
+func (e terminalError) Error() string {
+	return e.err.Error()
+}
+
+func (e terminalError) Unwrap() error {
+	return e.err
+}
+
+func IsTerminal(err error) bool {
+	var terminal terminalError
+	return errors.As(err, &terminal)
+}
+
 func (c *Client) handleCloseError(err error) error {
 	var closeErr *websocket.CloseError
 	if !errors.As(err, &closeErr) {
 		return err
 	}
+	reason := strings.ToLower(closeErr.Text)
+	// Checked before terminal reasons, since a rejected token (e.g. "token
+	// revoked") is recoverable by re-pairing without it.
+	if closeErr.Code == websocket.ClosePolicyViolation && c.clearsToken(reason) {
+		c.clearDeviceToken(closeErr.Text)
+		return err
+	}
+	for _, terminal := range terminalCloseReasons {
+		if strings.Contains(reason, terminal) {
+			c.logger.Error().Int("code", closeErr.Code).Str("reason", closeErr.Text).Msg("gateway: connection permanently rejected")
+			return terminalError{err: err}
+		}
+	}
 	if closeErr.Code != websocket.ClosePolicyViolation {
+		if backoff, ok := closeCodeBackoff[closeErr.Code]; ok {
+			return backoffError{err: err, backoff: backoff}
+		}
 		return err
 	}
-	reason := strings.ToLower(closeErr.Text)
 	if strings.Contains(reason, "pairing required") {
 		c.logger.Warn().Msg("pairing required — waiting for approval")
 		return backoffError{err: err, backoff: 10 * time.Second}
@@ -643,17 +1189,26 @@ func (c *Client) handleCloseError(err error) error {
 		c.logger.Warn().Msg("device identity required — waiting for approval")
 		return backoffError{err: err, backoff: 10 * time.Second}
 	}
-	if strings.Contains(reason, "device token mismatch") {
-		c.clearDeviceToken()
-	}
 	return err
 }
 
-func (c *Client) clearDeviceToken() {
+func (c *Client) clearsToken(reason string) bool {
+	for _, word := range c.tokenClearWords {
+		if strings.Contains(reason, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) clearDeviceToken(reason string) {
 	if c.deviceToken == "" && c.deviceTokenPath == "" {
 		return
 	}
 	c.deviceToken = ""
+	if c.onTokenCleared != nil {
+		c.onTokenCleared(reason)
+	}
 	if c.deviceTokenPath == "" {
 		return
 	}
@@ -1,12 +1,20 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -50,9 +58,12 @@ func (f *fakeConn) Close() error {
 }
 
 type mockConn struct {
-	readCh  chan []byte
-	writeCh chan writeRecord
-	pingCh  chan struct{}
+	readCh   chan []byte
+	writeCh  chan writeRecord
+	pingCh   chan struct{}
+	mu       sync.Mutex
+	deadline time.Time
+	closed   sync.Once
 }
 
 type writeRecord struct {
@@ -77,11 +88,24 @@ func (m *mockConn) WriteMessage(messageType int, data []byte) error {
 }
 
 func (m *mockConn) ReadMessage() (int, []byte, error) {
-	data, ok := <-m.readCh
-	if !ok {
-		return 0, nil, errors.New("connection closed")
+	m.mu.Lock()
+	deadline := m.deadline
+	m.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case data, ok := <-m.readCh:
+		if !ok {
+			return 0, nil, errors.New("connection closed")
+		}
+		return websocket.TextMessage, data, nil
+	case <-timeout:
+		return 0, nil, errors.New("i/o timeout")
 	}
-	return websocket.TextMessage, data, nil
 }
 
 func (m *mockConn) SetWriteDeadline(t time.Time) error {
@@ -89,6 +113,9 @@ func (m *mockConn) SetWriteDeadline(t time.Time) error {
 }
 
 func (m *mockConn) SetReadDeadline(t time.Time) error {
+	m.mu.Lock()
+	m.deadline = t
+	m.mu.Unlock()
 	return nil
 }
 
@@ -96,8 +123,10 @@ func (m *mockConn) SetReadLimit(limit int64) {}
 
 func (m *mockConn) SetPongHandler(h func(appData string) error) {}
 
+// Close may run twice, from a test and from the client's graceful close,
+// as closing a real connection twice is harmless.
 func (m *mockConn) Close() error {
-	close(m.readCh)
+	m.closed.Do(func() { close(m.readCh) })
 	return nil
 }
 
@@ -375,17 +404,68 @@ func TestClientDeviceTokenMismatchClearsToken(t *testing.T) {
 	}
 }
 
+func TestClientTokenClearReasonsConfigurable(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "device-token.json")
+	if err := SaveDeviceToken(tokenPath, "token-value"); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	client := New(Config{
+		Logger:            zerolog.Nop(),
+		DeviceTokenPath:   tokenPath,
+		TokenClearReasons: []string{"Invalid Device Token", "token revoked"},
+	})
+	client.deviceToken = "token-value"
+	_ = client.handleCloseError(&websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "device token mismatch"})
+	if client.deviceToken != "token-value" {
+		t.Fatalf("expected unlisted reason to keep the token")
+	}
+	err := client.handleCloseError(&websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "token revoked by admin"})
+	if client.deviceToken != "" {
+		t.Fatalf("expected configured reason to clear the token")
+	}
+	if IsTerminal(err) {
+		t.Fatalf("expected cleared token to allow reconnecting, got terminal %v", err)
+	}
+	if _, err := os.Stat(tokenPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected token file removed")
+	}
+}
+
+func TestClientDeviceTokenMismatchFiresTokenCleared(t *testing.T) {
+	dir := t.TempDir()
+	tokenPath := filepath.Join(dir, "device-token.json")
+	if err := SaveDeviceToken(tokenPath, "token-value"); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	var reasons []string
+	client := New(Config{
+		Logger:          zerolog.Nop(),
+		DeviceTokenPath: tokenPath,
+		OnTokenCleared: func(reason string) {
+			reasons = append(reasons, reason)
+		},
+	})
+	_ = client.handleCloseError(&websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "device token mismatch"})
+	if len(reasons) != 1 || reasons[0] != "device token mismatch" {
+		t.Fatalf("expected token cleared callback once, got %v", reasons)
+	}
+	_ = client.handleCloseError(&websocket.CloseError{Code: websocket.ClosePolicyViolation, Text: "pairing required"})
+	if len(reasons) != 1 {
+		t.Fatalf("expected no callback for unrelated close, got %v", reasons)
+	}
+}
+
 func TestClient_New_DefaultPingInterval(t *testing.T) {
 	client := New(Config{})
-	if client.pingInterval != 30*time.Second {
-		t.Fatalf("expected default ping interval 30s, got %v", client.pingInterval)
+	if client.ping.current() != 30*time.Second {
+		t.Fatalf("expected default ping interval 30s, got %v", client.ping.current())
 	}
 }
 
 func TestClient_New_CustomPingInterval(t *testing.T) {
 	client := New(Config{PingInterval: 5 * time.Second})
-	if client.pingInterval != 5*time.Second {
-		t.Fatalf("expected custom ping interval, got %v", client.pingInterval)
+	if client.ping.current() != 5*time.Second {
+		t.Fatalf("expected custom ping interval, got %v", client.ping.current())
 	}
 }
 
@@ -601,6 +681,218 @@ func TestClient_ConnectHandshake_DeviceTokenStored(t *testing.T) {
 	}
 }
 
+func TestClient_ConnectHandshake_NodeIDStoredAndUsed(t *testing.T) {
+	mock := newMockConn()
+	client := New(Config{
+		Logger:   zerolog.Nop(),
+		Register: DefaultRegistration(),
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.registerNode(ctx)
+	}()
+
+	sendConnectChallenge(t, mock, "nonce-123")
+	req := waitForConnectRequest(t, ctx, mock)
+	resData, err := json.Marshal(ResponseFrame{
+		Type:    "res",
+		ID:      req.ID,
+		OK:      true,
+		Payload: json.RawMessage(`{"type":"hello-ok","auth":{"nodeId":"node-assigned"}}`),
+	})
+	if err != nil {
+		t.Fatalf("marshal res: %v", err)
+	}
+	mock.readCh <- resData
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatalf("register did not finish")
+	}
+	if client.NodeID() != "node-assigned" {
+		t.Fatalf("expected node id stored, got %q", client.NodeID())
+	}
+
+	if err := client.SendEvent(ctx, "node.event", NodeEventParams{Event: "hello"}); err != nil {
+		t.Fatalf("send event: %v", err)
+	}
+	record := <-mock.writeCh
+	var frame RequestFrame
+	if err := json.Unmarshal(record.data, &frame); err != nil {
+		t.Fatalf("unmarshal frame: %v", err)
+	}
+	var event NodeEventParams
+	if err := json.Unmarshal(frame.Params, &event); err != nil || event.NodeID != "node-assigned" {
+		t.Fatalf("expected event to carry assigned node id, got %s", string(frame.Params))
+	}
+
+	if err := client.queueInvoke(newInvokeQueue(ctx, zerolog.Nop()), json.RawMessage(`{"id":"invoke-1","command":"canvas.state"}`)); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	record = <-mock.writeCh
+	if err := json.Unmarshal(record.data, &frame); err != nil {
+		t.Fatalf("unmarshal frame: %v", err)
+	}
+	var result InvokeResultParams
+	if err := json.Unmarshal(frame.Params, &result); err != nil || result.NodeID != "node-assigned" {
+		t.Fatalf("expected invoke result to default to assigned node id, got %s", string(frame.Params))
+	}
+}
+
+func TestClient_BatchesInvokeResultsWithinWindow(t *testing.T) {
+	mock := newMockConn()
+	client := New(Config{
+		Logger:            zerolog.Nop(),
+		Register:          DefaultRegistration(),
+		InvokeBatchWindow: 200 * time.Millisecond,
+		OnInvoke:          func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.registerNode(ctx)
+	}()
+	sendConnectChallenge(t, mock, "nonce-123")
+	req := waitForConnectRequest(t, ctx, mock)
+	var connect ConnectParams
+	if err := json.Unmarshal(req.Params, &connect); err != nil || !slices.Contains(connect.Caps, InvokeBatchCap) {
+		t.Fatalf("expected batching advertised in connect caps, got %v", connect.Caps)
+	}
+	resData, err := json.Marshal(ResponseFrame{Type: "res", ID: req.ID, OK: true, Payload: json.RawMessage(`{"type":"hello-ok","caps":["invoke.batch"]}`)})
+	if err != nil {
+		t.Fatalf("marshal res: %v", err)
+	}
+	mock.readCh <- resData
+	if err := <-done; err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+
+	invokes := newInvokeQueue(ctx, zerolog.Nop())
+	invoke := func(id string) {
+		t.Helper()
+		if err := client.queueInvoke(invokes, json.RawMessage(fmt.Sprintf(`{"id":%q,"command":"canvas.state"}`, id))); err != nil {
+			t.Fatalf("handle invoke: %v", err)
+		}
+	}
+	nextFrame := func() RequestFrame {
+		t.Helper()
+		select {
+		case record := <-mock.writeCh:
+			var frame RequestFrame
+			if err := json.Unmarshal(record.data, &frame); err != nil {
+				t.Fatalf("unmarshal frame: %v", err)
+			}
+			return frame
+		case <-ctx.Done():
+			t.Fatalf("no frame sent")
+			return RequestFrame{}
+		}
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		invoke(id)
+	}
+	frame := nextFrame()
+	var results []InvokeResultParams
+	if err := json.Unmarshal(frame.Params, &results); frame.Method != "node.invoke.results" || err != nil || len(results) != 3 {
+		t.Fatalf("expected one batch of 3 results, got %s %s", frame.Method, frame.Params)
+	}
+	if results[0].RequestID != "a" || results[2].RequestID != "c" {
+		t.Fatalf("expected results in invoke order, got %+v", results)
+	}
+
+	invoke("late")
+	frame = nextFrame()
+	var result InvokeResultParams
+	if err := json.Unmarshal(frame.Params, &result); frame.Method != "node.invoke.result" || err != nil || result.RequestID != "late" {
+		t.Fatalf("expected late result sent on its own, got %s %s", frame.Method, frame.Params)
+	}
+}
+
+func TestClient_InvokeResultsUnbatchedWithoutGatewaySupport(t *testing.T) {
+	mock := newMockConn()
+	client := New(Config{
+		Logger:            zerolog.Nop(),
+		InvokeBatchWindow: time.Hour,
+		OnInvoke:          func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	client.setConn(mock)
+	// No hello-ok with the batch cap, so results go out immediately.
+	if err := client.handleInvoke(context.Background(), InvokeRequestParams{RequestID: "a", Command: "canvas.state"}); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	select {
+	case record := <-mock.writeCh:
+		var frame RequestFrame
+		if err := json.Unmarshal(record.data, &frame); err != nil || frame.Method != "node.invoke.result" {
+			t.Fatalf("expected a single result frame, got %s", record.data)
+		}
+	default:
+		t.Fatalf("expected result sent without batching")
+	}
+}
+
+func TestClient_OversizeInvokeResultReplacedWithError(t *testing.T) {
+	mock := newMockConn()
+	results := map[string]interface{}{
+		"small": map[string]string{"ok": "yes"},
+		"large": map[string]string{"image": strings.Repeat("A", 200)},
+	}
+	client := New(Config{
+		Logger:         zerolog.Nop(),
+		MaxResultBytes: 100,
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			return results[req.Command], nil
+		},
+	})
+	client.setConn(mock)
+
+	type sentResult struct {
+		OK      bool             `json:"ok"`
+		Payload json.RawMessage  `json:"payload"`
+		Error   *NodeInvokeError `json:"error"`
+	}
+	send := func(command string) sentResult {
+		t.Helper()
+		if err := client.handleInvoke(context.Background(), InvokeRequestParams{RequestID: command, Command: command}); err != nil {
+			t.Fatalf("handle invoke: %v", err)
+		}
+		record := <-mock.writeCh
+		var frame RequestFrame
+		if err := json.Unmarshal(record.data, &frame); err != nil {
+			t.Fatalf("unmarshal frame: %v", err)
+		}
+		var result sentResult
+		if err := json.Unmarshal(frame.Params, &result); err != nil {
+			t.Fatalf("unmarshal result: %v", err)
+		}
+		return result
+	}
+
+	if result := send("small"); !result.OK || string(result.Payload) != `{"ok":"yes"}` {
+		t.Fatalf("expected small result sent as is, got %+v", result)
+	}
+	result := send("large")
+	if result.OK || result.Error == nil || result.Error.Code != ErrCodeResultTooLarge || result.Payload != nil {
+		t.Fatalf("expected oversize result replaced with an error, got %+v", result)
+	}
+	if !strings.Contains(result.Error.Message, "212 bytes") {
+		t.Fatalf("expected size in error message, got %q", result.Error.Message)
+	}
+}
+
 func TestClient_ConnectHandshake_ExplicitTokenPreferred(t *testing.T) {
 	mock := newMockConn()
 	client := New(Config{
@@ -707,6 +999,128 @@ func TestClient_ConnectChallenge_IgnoresSameNonce(t *testing.T) {
 	}
 }
 
+func TestClient_ConnectChallenge_NewNonceResendsConnect(t *testing.T) {
+	mock := newMockConn()
+	identity, err := LoadOrCreateIdentity(filepath.Join(t.TempDir(), "device.json"))
+	if err != nil {
+		t.Fatalf("create identity: %v", err)
+	}
+	client := New(Config{
+		Logger:   zerolog.Nop(),
+		Register: DefaultRegistration(),
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+		Identity: identity,
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.registerNode(ctx)
+	}()
+
+	sendConnectChallenge(t, mock, "nonce-1")
+	firstReq := waitForConnectRequest(t, ctx, mock)
+	sendConnectChallenge(t, mock, "nonce-2")
+	secondReq := waitForConnectRequest(t, ctx, mock)
+	var params ConnectParams
+	if err := json.Unmarshal(secondReq.Params, &params); err != nil {
+		t.Fatalf("unmarshal connect params: %v", err)
+	}
+	if params.Device == nil || params.Device.Nonce != "nonce-2" {
+		t.Fatalf("expected re-connect signed with new nonce")
+	}
+
+	stale, err := json.Marshal(ResponseFrame{Type: "res", ID: firstReq.ID, OK: false})
+	if err != nil {
+		t.Fatalf("marshal res: %v", err)
+	}
+	mock.readCh <- stale
+	res, err := json.Marshal(ResponseFrame{Type: "res", ID: secondReq.ID, OK: true, Payload: json.RawMessage(`{"type":"hello-ok"}`)})
+	if err != nil {
+		t.Fatalf("marshal res: %v", err)
+	}
+	mock.readCh <- res
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatalf("register did not finish")
+	}
+}
+
+func TestClient_ConnectHandshake_Timeout(t *testing.T) {
+	mock := newMockConn()
+	client := New(Config{
+		Logger:           zerolog.Nop(),
+		Register:         DefaultRegistration(),
+		HandshakeTimeout: 50 * time.Millisecond,
+		OnInvoke:         func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- client.registerNode(ctx)
+	}()
+
+	sendConnectChallenge(t, mock, "nonce-1")
+	waitForConnectRequest(t, ctx, mock)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errHandshakeTimeout) {
+			t.Fatalf("expected handshake timeout, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("handshake timeout took too long: %v", elapsed)
+		}
+	case <-ctx.Done():
+		t.Fatalf("registration did not time out")
+	}
+}
+
+func TestClient_Connect_TimesOutStuckDial(t *testing.T) {
+	client := New(Config{
+		URL:            "ws://gateway.invalid/ws",
+		Logger:         zerolog.Nop(),
+		ConnectTimeout: 50 * time.Millisecond,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	start := time.Now()
+	_, err := client.connect(context.Background())
+	if !errors.Is(err, errConnectTimeout) {
+		t.Fatalf("expected connect timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Fatalf("expected connect to give up at the timeout, took %v", elapsed)
+	}
+}
+
+func TestClient_New_DefaultConnectTimeout(t *testing.T) {
+	client := New(Config{})
+	if client.connectTimeout != 30*time.Second {
+		t.Fatalf("expected default connect timeout 30s, got %v", client.connectTimeout)
+	}
+}
+
+func TestClient_New_DefaultHandshakeTimeout(t *testing.T) {
+	client := New(Config{})
+	if client.handshakeTimeout != 30*time.Second {
+		t.Fatalf("expected default handshake timeout 30s, got %v", client.handshakeTimeout)
+	}
+}
+
 func TestClient_ConnectChallenge_IgnoresEmptyNonce(t *testing.T) {
 	mock := newMockConn()
 	dir := t.TempDir()
@@ -897,15 +1311,16 @@ func TestClient_ReadLoop_UnknownEventIgnored(t *testing.T) {
 	<-done
 }
 
-func TestClient_ReadLoop_VoicewakeIgnored(t *testing.T) {
+func TestClient_ReadLoop_NodeConfigEvent(t *testing.T) {
 	mock := newMockConn()
-	invoked := make(chan struct{}, 1)
+	configs := make(chan json.RawMessage, 1)
 	client := New(Config{
 		Logger:       zerolog.Nop(),
 		PingInterval: time.Hour,
-		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
-			invoked <- struct{}{}
-			return nil, nil
+		OnInvoke:     func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+		OnConfig: func(ctx context.Context, payload json.RawMessage) error {
+			configs <- payload
+			return nil
 		},
 	})
 	client.setConn(mock)
@@ -916,21 +1331,19 @@ func TestClient_ReadLoop_VoicewakeIgnored(t *testing.T) {
 		done <- client.readLoop(ctx)
 	}()
 
-	event := EventFrame{
-		Type:    "event",
-		Event:   "voicewake.changed",
-		Payload: json.RawMessage(`{"state":"on"}`),
-	}
-	data, err := json.Marshal(event)
+	data, err := json.Marshal(EventFrame{Type: "event", Event: "node.config", Payload: json.RawMessage(`{"power":{"idleTimeoutMin":10}}`)})
 	if err != nil {
 		t.Fatalf("marshal event: %v", err)
 	}
 	mock.readCh <- data
 
 	select {
-	case <-invoked:
-		t.Fatalf("unexpected invoke handler call")
-	case <-time.After(50 * time.Millisecond):
+	case payload := <-configs:
+		if string(payload) != `{"power":{"idleTimeoutMin":10}}` {
+			t.Fatalf("unexpected config payload %s", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("config handler not called")
 	}
 
 	cancel()
@@ -938,15 +1351,16 @@ func TestClient_ReadLoop_VoicewakeIgnored(t *testing.T) {
 	<-done
 }
 
-func TestClient_ReadLoop_TickEvent(t *testing.T) {
+func TestClient_ReadLoop_NodeThemeEvent(t *testing.T) {
 	mock := newMockConn()
-	invoked := make(chan struct{}, 1)
+	themes := make(chan json.RawMessage, 1)
 	client := New(Config{
 		Logger:       zerolog.Nop(),
 		PingInterval: time.Hour,
-		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
-			invoked <- struct{}{}
-			return nil, nil
+		OnInvoke:     func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+		OnTheme: func(ctx context.Context, payload json.RawMessage) error {
+			themes <- payload
+			return nil
 		},
 	})
 	client.setConn(mock)
@@ -957,9 +1371,130 @@ func TestClient_ReadLoop_TickEvent(t *testing.T) {
 		done <- client.readLoop(ctx)
 	}()
 
-	event := EventFrame{
-		Type:    "event",
-		Event:   "tick",
+	data, err := json.Marshal(EventFrame{Type: "event", Event: "node.theme", Payload: json.RawMessage(`{"accentGray":0}`)})
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+	mock.readCh <- data
+
+	select {
+	case payload := <-themes:
+		if string(payload) != `{"accentGray":0}` {
+			t.Fatalf("unexpected theme payload %s", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("theme handler not called")
+	}
+
+	cancel()
+	mock.Close()
+	<-done
+}
+
+func TestClient_ReadLoop_CountsUnknownFrames(t *testing.T) {
+	mock := newMockConn()
+	themes := make(chan json.RawMessage, 1)
+	client := New(Config{
+		Logger:       zerolog.Nop(),
+		PingInterval: time.Hour,
+		OnInvoke:     func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+		OnTheme: func(ctx context.Context, payload json.RawMessage) error {
+			themes <- payload
+			return nil
+		},
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.readLoop(ctx)
+	}()
+
+	mock.readCh <- []byte(`{"type":"event","event":"node.future"}`)
+	mock.readCh <- []byte(`{"type":"event","event":"tick"}`)
+	mock.readCh <- []byte(`{"type":"req","id":"1","method":"node.future"}`)
+	mock.readCh <- []byte(`{"type":"stream"}`)
+	// Frames are handled in order, so once the theme arrives the rest are
+	// counted.
+	mock.readCh <- []byte(`{"type":"event","event":"node.theme","payload":{}}`)
+	select {
+	case <-themes:
+	case <-time.After(time.Second):
+		t.Fatalf("theme handler not called")
+	}
+	if got := client.UnknownFrames(); got != 3 {
+		t.Fatalf("expected 3 unknown frames, got %d", got)
+	}
+
+	cancel()
+	mock.Close()
+	<-done
+}
+
+func TestClient_ReadLoop_VoicewakeIgnored(t *testing.T) {
+	mock := newMockConn()
+	invoked := make(chan struct{}, 1)
+	client := New(Config{
+		Logger:       zerolog.Nop(),
+		PingInterval: time.Hour,
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			invoked <- struct{}{}
+			return nil, nil
+		},
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.readLoop(ctx)
+	}()
+
+	event := EventFrame{
+		Type:    "event",
+		Event:   "voicewake.changed",
+		Payload: json.RawMessage(`{"state":"on"}`),
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+	mock.readCh <- data
+
+	select {
+	case <-invoked:
+		t.Fatalf("unexpected invoke handler call")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	mock.Close()
+	<-done
+}
+
+func TestClient_ReadLoop_TickEvent(t *testing.T) {
+	mock := newMockConn()
+	invoked := make(chan struct{}, 1)
+	client := New(Config{
+		Logger:       zerolog.Nop(),
+		PingInterval: time.Hour,
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			invoked <- struct{}{}
+			return nil, nil
+		},
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.readLoop(ctx)
+	}()
+
+	event := EventFrame{
+		Type:    "event",
+		Event:   "tick",
 		Payload: json.RawMessage(`{}`),
 	}
 	data, err := json.Marshal(event)
@@ -979,6 +1514,65 @@ func TestClient_ReadLoop_TickEvent(t *testing.T) {
 	<-done
 }
 
+func TestClient_ReadLoop_KeepaliveRepliesPong(t *testing.T) {
+	mock := newMockConn()
+	client := New(Config{
+		Logger:         zerolog.Nop(),
+		PingInterval:   time.Hour,
+		KeepaliveEvent: "keepalive",
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			return nil, nil
+		},
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.readLoop(ctx)
+	}()
+
+	event := EventFrame{
+		Type:    "event",
+		Event:   "keepalive",
+		Payload: json.RawMessage(`{"seq":4}`),
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+	mock.readCh <- data
+
+	select {
+	case record := <-mock.writeCh:
+		var frame RequestFrame
+		if err := json.Unmarshal(record.data, &frame); err != nil {
+			t.Fatalf("unmarshal frame: %v", err)
+		}
+		var params struct {
+			Event   string `json:"event"`
+			Payload struct {
+				Echo json.RawMessage `json:"echo"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal(frame.Params, &params); err != nil {
+			t.Fatalf("unmarshal params: %v", err)
+		}
+		if frame.Method != "node.event" || params.Event != "pong" {
+			t.Fatalf("expected node.event pong, got %s %s", frame.Method, params.Event)
+		}
+		if string(params.Payload.Echo) != `{"seq":4}` {
+			t.Fatalf("expected echoed payload, got %s", params.Payload.Echo)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("expected pong reply")
+	}
+
+	cancel()
+	mock.Close()
+	<-done
+}
+
 func TestClient_ReadLoop_ShutdownEvent(t *testing.T) {
 	mock := newMockConn()
 	client := New(Config{
@@ -1162,6 +1756,33 @@ func TestClient_BuildConnectRequest_NoIdentity(t *testing.T) {
 	}
 }
 
+func TestClient_BuildConnectRequest_OmitDeviceInfo(t *testing.T) {
+	identity, err := LoadOrCreateIdentity(filepath.Join(t.TempDir(), "device.json"))
+	if err != nil {
+		t.Fatalf("create identity: %v", err)
+	}
+	client := New(Config{
+		Register:       DefaultRegistration(),
+		Identity:       identity,
+		AuthToken:      "shared-secret",
+		OmitDeviceInfo: true,
+	})
+	req, err := client.buildConnectRequest("nonce-123")
+	if err != nil {
+		t.Fatalf("build connect request: %v", err)
+	}
+	var params ConnectParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		t.Fatalf("unmarshal params: %v", err)
+	}
+	if params.Device != nil {
+		t.Fatalf("expected no device info when omitted, got %+v", params.Device)
+	}
+	if params.Auth == nil || params.Auth.Token != "shared-secret" {
+		t.Fatalf("expected shared secret auth, got %+v", params.Auth)
+	}
+}
+
 func TestClient_BuildConnectRequest_AllFields(t *testing.T) {
 	reg := NodeRegistration{
 		Client: ClientInfo{
@@ -1261,6 +1882,354 @@ func TestClient_HandleCloseError_WrongReason(t *testing.T) {
 	}
 }
 
+func TestClient_HandleCloseError_RetryPolicyByCode(t *testing.T) {
+	client := New(Config{Logger: zerolog.Nop()})
+	cases := []struct {
+		code    int
+		backoff time.Duration
+	}{
+		{websocket.CloseGoingAway, 2 * time.Second},
+		{websocket.CloseInternalServerErr, 5 * time.Second},
+		{websocket.CloseServiceRestart, 5 * time.Second},
+		{websocket.CloseTryAgainLater, 30 * time.Second},
+	}
+	for _, tc := range cases {
+		err := client.handleCloseError(&websocket.CloseError{Code: tc.code})
+		if IsTerminal(err) {
+			t.Fatalf("code %d: expected retryable error", tc.code)
+		}
+		if got := backoffFromErr(t, err); got != tc.backoff {
+			t.Fatalf("code %d: expected backoff %v, got %v", tc.code, tc.backoff, got)
+		}
+	}
+
+	err := client.handleCloseError(&websocket.CloseError{Code: websocket.CloseNormalClosure})
+	var provider backoffProvider
+	if IsTerminal(err) || errors.As(err, &provider) {
+		t.Fatalf("expected plain retryable error for normal closure, got %v", err)
+	}
+}
+
+func TestClient_HandleCloseError_TerminalReason(t *testing.T) {
+	client := New(Config{Logger: zerolog.Nop()})
+	for _, code := range []int{websocket.ClosePolicyViolation, websocket.CloseNormalClosure} {
+		err := client.handleCloseError(&websocket.CloseError{Code: code, Text: "Node banned by operator"})
+		if !IsTerminal(err) {
+			t.Fatalf("code %d: expected terminal error, got %v", code, err)
+		}
+	}
+}
+
+func TestClient_Run_StopsOnTerminalClose(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "node banned")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	dialer := &net.Dialer{}
+	client := New(Config{
+		URL:      "ws" + strings.TrimPrefix(server.URL, "http"),
+		Logger:   zerolog.Nop(),
+		Register: DefaultRegistration(),
+		Dialer:   dialer.DialContext,
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := client.Run(ctx)
+	if !IsTerminal(err) {
+		t.Fatalf("expected Run to stop with terminal error, got %v", err)
+	}
+}
+
+func TestClient_ConnectHandshake_EphemeralIdentityWithUnwritableToken(t *testing.T) {
+	identity, err := NewEphemeralIdentity()
+	if err != nil {
+		t.Fatalf("ephemeral identity: %v", err)
+	}
+	tokenPath := filepath.Join(t.TempDir(), "missing", "device-token.json")
+	mock := newMockConn()
+	client := New(Config{
+		Logger:          zerolog.Nop(),
+		Register:        DefaultRegistration(),
+		Identity:        identity,
+		DeviceTokenPath: tokenPath,
+		OnInvoke:        func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	handshake := func(nonce, hello string) ConnectParams {
+		t.Helper()
+		client.setConn(mock)
+		done := make(chan error, 1)
+		go func() {
+			done <- client.registerNode(ctx)
+		}()
+		sendConnectChallenge(t, mock, nonce)
+		req := waitForConnectRequest(t, ctx, mock)
+		var params ConnectParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			t.Fatalf("unmarshal connect params: %v", err)
+		}
+		resData, err := json.Marshal(ResponseFrame{Type: "res", ID: req.ID, OK: true, Payload: json.RawMessage(hello)})
+		if err != nil {
+			t.Fatalf("marshal res: %v", err)
+		}
+		mock.readCh <- resData
+		if err := <-done; err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+		return params
+	}
+
+	first := handshake("nonce-1", `{"type":"hello-ok","auth":{"deviceToken":"issued-token"}}`)
+	if first.Device == nil || first.Device.ID != identity.DeviceID {
+		t.Fatalf("expected ephemeral device identity in connect, got %+v", first.Device)
+	}
+	if _, err := os.Stat(tokenPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected token not to be saved, got %v", err)
+	}
+	second := handshake("nonce-2", `{"type":"hello-ok"}`)
+	if second.Auth == nil || second.Auth.Token != "issued-token" {
+		t.Fatalf("expected in-memory token reused on reconnect, got %+v", second.Auth)
+	}
+}
+
+func TestClient_Run_BackoffGrowsOnRapidDrops(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	connects := make(chan time.Time, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":    "event",
+			"event":   "connect.challenge",
+			"payload": map[string]string{"nonce": "nonce"},
+		})
+		var req RequestFrame
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = conn.WriteJSON(ResponseFrame{Type: "res", ID: req.ID, OK: true, Payload: json.RawMessage(`{"type":"hello-ok"}`)})
+		connects <- time.Now()
+	}))
+	defer server.Close()
+
+	dialer := &net.Dialer{}
+	client := New(Config{
+		URL:      "ws" + strings.TrimPrefix(server.URL, "http"),
+		Logger:   zerolog.Nop(),
+		Register: DefaultRegistration(),
+		Dialer:   dialer.DialContext,
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	client.initialBackoff = 20 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		_ = client.Run(ctx)
+	}()
+
+	var times []time.Time
+	for len(times) < 4 {
+		select {
+		case at := <-connects:
+			times = append(times, at)
+		case <-ctx.Done():
+			t.Fatalf("expected 4 connections, got %d", len(times))
+		}
+	}
+	for i := 1; i < len(times); i++ {
+		want := client.initialBackoff << (i - 1)
+		if gap := times[i].Sub(times[i-1]); gap < want {
+			t.Fatalf("reconnect %d after %v, expected backoff of at least %v", i, gap, want)
+		}
+	}
+}
+
+func TestClient_ForceReconnect_SkipsBackoff(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	connects := make(chan struct{}, 4)
+	disconnects := make(chan struct{}, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":    "event",
+			"event":   "connect.challenge",
+			"payload": map[string]string{"nonce": "nonce"},
+		})
+		var req RequestFrame
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = conn.WriteJSON(ResponseFrame{Type: "res", ID: req.ID, OK: true, Payload: json.RawMessage(`{"type":"hello-ok"}`)})
+		connects <- struct{}{}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				disconnects <- struct{}{}
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	dialer := &net.Dialer{}
+	client := New(Config{
+		URL:      "ws" + strings.TrimPrefix(server.URL, "http"),
+		Logger:   zerolog.Nop(),
+		Register: DefaultRegistration(),
+		Dialer:   dialer.DialContext,
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	// A dropped connection would normally wait out this backoff.
+	client.initialBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- client.Run(ctx)
+	}()
+
+	wait := func(ch <-chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	wait(connects, "connect")
+	if !client.ForceReconnect() {
+		t.Fatalf("expected a connection to drop")
+	}
+	wait(disconnects, "disconnect")
+	wait(connects, "reconnect")
+
+	cancel()
+	client.ForceReconnect()
+	if err := <-runErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected Run to stop on cancel, got %v", err)
+	}
+	if client.ForceReconnect() {
+		t.Fatalf("expected no connection after Run returned")
+	}
+}
+
+func TestClient_HandleShutdown_Reasons(t *testing.T) {
+	client := New(Config{Logger: zerolog.Nop()})
+	cases := []struct {
+		reason    string
+		restartMs int
+		current   time.Duration
+		wantErr   error
+		want      time.Duration
+	}{
+		// Maintenance waits exactly the announced restart, even if shorter.
+		{reason: ShutdownMaintenance, restartMs: 2000, current: 8 * time.Second, wantErr: errGatewayShutdown, want: 2 * time.Second},
+		{reason: "", current: 8 * time.Second, wantErr: errGatewayShutdown, want: time.Second},
+		// An error shutdown never shortens the growing backoff.
+		{reason: ShutdownError, restartMs: 2000, current: 8 * time.Second, wantErr: errGatewayFailed, want: 8 * time.Second},
+		{reason: ShutdownError, restartMs: 20000, current: 8 * time.Second, wantErr: errGatewayFailed, want: 20 * time.Second},
+		// Without alternates a migration is waited out like maintenance.
+		{reason: ShutdownMigrate, restartMs: 3000, current: 8 * time.Second, wantErr: errGatewayShutdown, want: 3 * time.Second},
+	}
+	for _, tc := range cases {
+		err := client.handleShutdown(ShutdownPayload{Reason: tc.reason, RestartExpectedMs: tc.restartMs})
+		if !errors.Is(err, tc.wantErr) {
+			t.Fatalf("reason %q: expected %v, got %v", tc.reason, tc.wantErr, err)
+		}
+		backoff := tc.current
+		client.applyBackoffOverride(err, &backoff)
+		if backoff != tc.want {
+			t.Fatalf("reason %q: expected backoff %v, got %v", tc.reason, tc.want, backoff)
+		}
+	}
+}
+
+func TestClient_ShutdownMigrate_ReconnectsToAlternate(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	serve := func(onHello func(*websocket.Conn)) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_ = conn.WriteJSON(map[string]interface{}{
+				"type":    "event",
+				"event":   "connect.challenge",
+				"payload": map[string]string{"nonce": "nonce"},
+			})
+			var req RequestFrame
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			_ = conn.WriteJSON(ResponseFrame{Type: "res", ID: req.ID, OK: true, Payload: json.RawMessage(`{"type":"hello-ok"}`)})
+			onHello(conn)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+	}
+	primary := serve(func(conn *websocket.Conn) {
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":    "event",
+			"event":   "shutdown",
+			"payload": map[string]interface{}{"reason": "migrate", "restartExpectedMs": 60000},
+		})
+	})
+	defer primary.Close()
+	migrated := make(chan struct{}, 1)
+	alternate := serve(func(*websocket.Conn) { migrated <- struct{}{} })
+	defer alternate.Close()
+
+	dialer := &net.Dialer{}
+	client := New(Config{
+		URL:           "ws" + strings.TrimPrefix(primary.URL, "http"),
+		AlternateURLs: []string{"ws" + strings.TrimPrefix(alternate.URL, "http")},
+		Logger:        zerolog.Nop(),
+		Register:      DefaultRegistration(),
+		Dialer:        dialer.DialContext,
+		OnInvoke:      func(ctx context.Context, req InvokeRequestParams) (interface{}, error) { return nil, nil },
+	})
+	// Any backoff, or waiting out restartExpectedMs, would time the test out.
+	client.initialBackoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- client.Run(ctx)
+	}()
+
+	select {
+	case <-migrated:
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for alternate gateway")
+	}
+	cancel()
+	if err := <-runErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected Run to stop on cancel, got %v", err)
+	}
+}
+
 func TestClient_HandleCloseError_PairingRequired(t *testing.T) {
 	dir := t.TempDir()
 	tokenPath := filepath.Join(dir, "device-token.json")
@@ -1377,6 +2346,163 @@ func TestClient_InvokeResult_Error(t *testing.T) {
 	}
 }
 
+func TestClient_Invoke_DeniedWithoutScope(t *testing.T) {
+	mock := newMockConn()
+	invoked := false
+	client := New(Config{
+		Logger:        zerolog.Nop(),
+		ScopeCommands: map[string][]string{"canvas.read": {"canvas.snapshot"}},
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			invoked = true
+			return "ok", nil
+		},
+	})
+	client.setConn(mock)
+	client.setGrantedScopes([]string{"canvas.write"})
+
+	readResult := func() InvokeResultParams {
+		t.Helper()
+		record := <-mock.writeCh
+		var frame RequestFrame
+		if err := json.Unmarshal(record.data, &frame); err != nil {
+			t.Fatalf("unmarshal frame: %v", err)
+		}
+		var params InvokeResultParams
+		if err := json.Unmarshal(frame.Params, &params); err != nil {
+			t.Fatalf("unmarshal params: %v", err)
+		}
+		return params
+	}
+
+	ctx := context.Background()
+	if err := client.handleInvoke(ctx, InvokeRequestParams{RequestID: "req-1", NodeID: "node-1", Command: "canvas.snapshot"}); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	result := readResult()
+	if invoked || result.OK || result.Error == nil || result.Error.Code != ErrCodePermissionDenied {
+		t.Fatalf("expected permission_denied without calling handler, got %+v", result)
+	}
+
+	// An unmapped canvas command is denied once scopes are granted.
+	if err := client.handleInvoke(ctx, InvokeRequestParams{RequestID: "req-2", NodeID: "node-1", Command: "canvas.image"}); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	if result := readResult(); invoked || result.OK || result.Error == nil || result.Error.Code != ErrCodePermissionDenied {
+		t.Fatalf("expected unmapped canvas command denied, got %+v", result)
+	}
+	if err := client.handleInvoke(ctx, InvokeRequestParams{RequestID: "req-2b", NodeID: "node-1", Command: "status.get"}); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	if result := readResult(); !invoked || !result.OK {
+		t.Fatalf("expected unmapped non-canvas command to run, got %+v", result)
+	}
+
+	invoked = false
+	client.setGrantedScopes([]string{"canvas.read"})
+	if err := client.handleInvoke(ctx, InvokeRequestParams{RequestID: "req-3", NodeID: "node-1", Command: "canvas.snapshot"}); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	if result := readResult(); !invoked || !result.OK {
+		t.Fatalf("expected granted scope to allow command, got %+v", result)
+	}
+}
+
+func TestClient_Invoke_ProgressBeforeResult(t *testing.T) {
+	mock := newMockConn()
+	client := New(Config{
+		Logger: zerolog.Nop(),
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			if req.Progress == nil {
+				return nil, errors.New("missing progress reporter")
+			}
+			if err := req.Progress(ctx, 0.5, "halfway"); err != nil {
+				return nil, err
+			}
+			if err := req.Progress(ctx, 1, "done"); err != nil {
+				return nil, err
+			}
+			return "ok", nil
+		},
+	})
+	client.setConn(mock)
+
+	req := InvokeRequestParams{RequestID: "req-7", NodeID: "node-1", Command: "canvas.present"}
+	if err := client.handleInvoke(context.Background(), req); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+
+	var methods []string
+	var progress []InvokeProgressPayload
+	for i := 0; i < 3; i++ {
+		record := <-mock.writeCh
+		var frame RequestFrame
+		if err := json.Unmarshal(record.data, &frame); err != nil {
+			t.Fatalf("unmarshal frame: %v", err)
+		}
+		methods = append(methods, frame.Method)
+		if frame.Method != "node.event" {
+			continue
+		}
+		var evt struct {
+			Event   string                `json:"event"`
+			Payload InvokeProgressPayload `json:"payload"`
+		}
+		if err := json.Unmarshal(frame.Params, &evt); err != nil {
+			t.Fatalf("unmarshal progress: %v", err)
+		}
+		if evt.Event != "node.invoke.progress" {
+			t.Fatalf("unexpected event %s", evt.Event)
+		}
+		progress = append(progress, evt.Payload)
+	}
+	want := []string{"node.event", "node.event", "node.invoke.result"}
+	if !reflect.DeepEqual(methods, want) {
+		t.Fatalf("expected frames %v, got %v", want, methods)
+	}
+	for _, p := range progress {
+		if p.RequestID != "req-7" || p.NodeID != "node-1" {
+			t.Fatalf("progress not tied to request: %+v", p)
+		}
+	}
+	if progress[0].Progress != 0.5 || progress[1].Message != "done" {
+		t.Fatalf("unexpected progress payloads: %+v", progress)
+	}
+}
+
+func TestClient_Invoke_RequestScopedLogger(t *testing.T) {
+	mock := newMockConn()
+	var buf bytes.Buffer
+	client := New(Config{
+		Logger: zerolog.New(&buf),
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			zerolog.Ctx(ctx).Info().Msg("handling")
+			return nil, nil
+		},
+	})
+	client.setConn(mock)
+
+	req := InvokeRequestParams{RequestID: "req-9", NodeID: "node-3", Command: "canvas.snapshot"}
+	if err := client.handleInvoke(context.Background(), req); err != nil {
+		t.Fatalf("handle invoke: %v", err)
+	}
+	var entry map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var candidate map[string]interface{}
+		if err := json.Unmarshal(line, &candidate); err != nil {
+			t.Fatalf("unmarshal log line: %v", err)
+		}
+		if candidate["message"] == "handling" {
+			entry = candidate
+		}
+	}
+	if entry == nil {
+		t.Fatalf("handler log line missing: %s", buf.String())
+	}
+	if entry["requestId"] != "req-9" || entry["command"] != "canvas.snapshot" || entry["nodeId"] != "node-3" {
+		t.Fatalf("expected request fields on handler log, got %v", entry)
+	}
+}
+
 func TestParseInvokePayload_ParamsJSON(t *testing.T) {
 	raw := json.RawMessage(`{"id":"req","nodeId":"node","command":"cmd","paramsJSON":"{\"value\":1}"}`)
 	params, err := parseInvokePayload(raw)
@@ -1410,6 +2536,51 @@ func TestParseInvokePayload_BothFields(t *testing.T) {
 	}
 }
 
+func TestParseInvokePayload_KeepsTopLevelParams(t *testing.T) {
+	raw := json.RawMessage(`{"id":"req","nodeId":"node","command":"canvas.present","waveform":"gc16"}`)
+	params, err := parseInvokePayload(raw)
+	if err != nil {
+		t.Fatalf("parse invoke payload: %v", err)
+	}
+	var top struct {
+		Waveform string `json:"waveform"`
+	}
+	if err := json.Unmarshal(params.Params, &top); err != nil || top.Waveform != "gc16" {
+		t.Fatalf("expected raw params with waveform, got %s", string(params.Params))
+	}
+}
+
+func TestParseInvokePayload_TraceID(t *testing.T) {
+	raw := json.RawMessage(`{"id":"req","nodeId":"node","command":"canvas.present","traceId":"trace-1"}`)
+	params, err := parseInvokePayload(raw)
+	if err != nil {
+		t.Fatalf("parse invoke payload: %v", err)
+	}
+	if params.TraceID != "trace-1" {
+		t.Fatalf("expected trace id trace-1, got %q", params.TraceID)
+	}
+}
+
+func TestParseInvokePayload_ArrayParams(t *testing.T) {
+	raw := json.RawMessage(`{"id":"req","nodeId":"node","command":"cmd","params":["a",2,{"b":true}]}`)
+	params, err := parseInvokePayload(raw)
+	if err != nil {
+		t.Fatalf("parse invoke payload: %v", err)
+	}
+	if string(params.Args) != `["a",2,{"b":true}]` {
+		t.Fatalf("expected array args preserved, got %s", string(params.Args))
+	}
+
+	raw = json.RawMessage(`{"id":"req","nodeId":"node","command":"cmd","paramsJSON":"[1,2]"}`)
+	params, err = parseInvokePayload(raw)
+	if err != nil {
+		t.Fatalf("parse invoke payload: %v", err)
+	}
+	if string(params.Args) != `[1,2]` {
+		t.Fatalf("expected array paramsJSON preserved, got %s", string(params.Args))
+	}
+}
+
 func TestParseInvokePayload_MissingRequestID(t *testing.T) {
 	raw := json.RawMessage(`{"nodeId":"node","command":"cmd","params":{"value":2}}`)
 	if _, err := parseInvokePayload(raw); err == nil {
@@ -1465,3 +2636,158 @@ func waitForConnectRequest(t *testing.T, ctx context.Context, mock *mockConn) Re
 	}
 	return req
 }
+
+func TestClient_ReadLoop_SendsHeartbeat(t *testing.T) {
+	mock := newMockConn()
+	client := New(Config{
+		Logger:            zerolog.Nop(),
+		PingInterval:      time.Hour,
+		HeartbeatInterval: 10 * time.Millisecond,
+		Heartbeat: func() interface{} {
+			return map[string]interface{}{"power": map[string]bool{"suspendEnabled": true}}
+		},
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			return nil, nil
+		},
+	})
+	client.setConn(mock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.readLoop(ctx)
+	}()
+
+	select {
+	case record := <-mock.writeCh:
+		var frame RequestFrame
+		if err := json.Unmarshal(record.data, &frame); err != nil {
+			t.Fatalf("unmarshal frame: %v", err)
+		}
+		var params struct {
+			Event   string `json:"event"`
+			Payload struct {
+				Power struct {
+					SuspendEnabled bool `json:"suspendEnabled"`
+				} `json:"power"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal(frame.Params, &params); err != nil {
+			t.Fatalf("unmarshal params: %v", err)
+		}
+		if frame.Method != "node.event" || params.Event != "heartbeat" || !params.Payload.Power.SuspendEnabled {
+			t.Fatalf("expected heartbeat with power state, got %s %s", frame.Method, frame.Params)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected heartbeat event")
+	}
+
+	cancel()
+	mock.Close()
+	<-done
+}
+
+func TestClient_CloseGracefully_SendsCloseFrameAndTimesOut(t *testing.T) {
+	mock := newMockConn()
+	client := New(Config{Logger: zerolog.Nop(), CloseTimeout: 50 * time.Millisecond})
+	client.setConn(mock)
+
+	start := time.Now()
+	client.closeGracefully(context.Background())
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected close to wait out the 50ms timeout for an echo, took %s", elapsed)
+	}
+
+	select {
+	case record := <-mock.writeCh:
+		if record.messageType != websocket.CloseMessage {
+			t.Fatalf("expected close frame, got message type %d", record.messageType)
+		}
+		if code := binary.BigEndian.Uint16(record.data); code != websocket.CloseNormalClosure {
+			t.Fatalf("expected normal closure code, got %d", code)
+		}
+	default:
+		t.Fatalf("expected close frame to be written")
+	}
+	if client.getConn() != nil {
+		t.Fatalf("expected connection to be dropped")
+	}
+}
+
+func TestClient_InvokeBeforeHelloRunsAfterRegistration(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	results := make(chan InvokeResultParams, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":    "event",
+			"event":   "connect.challenge",
+			"payload": map[string]string{"nonce": "nonce"},
+		})
+		var req RequestFrame
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		// The invoke overtakes hello-ok.
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":    "event",
+			"event":   "node.invoke.request",
+			"payload": map[string]interface{}{"id": "early", "command": "canvas.snapshot"},
+		})
+		_ = conn.WriteJSON(ResponseFrame{Type: "res", ID: req.ID, OK: true, Payload: json.RawMessage(`{"type":"hello-ok","auth":{"nodeId":"node-1","scopes":["canvas.read"]}}`)})
+		for {
+			var frame RequestFrame
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			var result InvokeResultParams
+			if frame.Method == "node.invoke.result" && json.Unmarshal(frame.Params, &result) == nil {
+				results <- result
+			}
+		}
+	}))
+	defer server.Close()
+
+	ready := make(chan struct{})
+	var registeredFirst atomic.Bool
+	dialer := &net.Dialer{}
+	client := New(Config{
+		URL:           "ws" + strings.TrimPrefix(server.URL, "http"),
+		Logger:        zerolog.Nop(),
+		Register:      DefaultRegistration(),
+		Dialer:        dialer.DialContext,
+		ScopeCommands: map[string][]string{"canvas.read": {"canvas.snapshot"}},
+		OnRegistered: func(ctx context.Context) error {
+			close(ready)
+			return nil
+		},
+		OnInvoke: func(ctx context.Context, req InvokeRequestParams) (interface{}, error) {
+			// Like the canvas handler, wait until registration marks ready.
+			select {
+			case <-ready:
+				registeredFirst.Store(true)
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return "ok", nil
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		_ = client.Run(ctx)
+	}()
+
+	select {
+	case result := <-results:
+		if result.RequestID != "early" || !result.OK || result.NodeID != "node-1" || !registeredFirst.Load() {
+			t.Fatalf("expected the early invoke to run after registration, got %+v", result)
+		}
+	case <-ctx.Done():
+		t.Fatalf("early invoke was dropped")
+	}
+}
@@ -36,15 +36,19 @@ func SaveDeviceToken(path string, token string) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, encoded, 0o600)
+	return withStateLock(path, func() error {
+		return os.WriteFile(path, encoded, 0o600)
+	})
 }
 
 func ClearDeviceToken(path string) error {
 	if path == "" {
 		return nil
 	}
-	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
-	}
-	return nil
+	return withStateLock(path, func() error {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
 }
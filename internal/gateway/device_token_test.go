@@ -5,6 +5,8 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -125,3 +127,29 @@ func TestDeviceTokenRoundtrip_WithTimestamp(t *testing.T) {
 		t.Fatalf("expected savedAtMs to be populated")
 	}
 }
+
+func TestSaveDeviceToken_ConcurrentSavesDoNotCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "device-token.json")
+	tokens := []string{strings.Repeat("a", 4096), "b"}
+	for round := 0; round < 50; round++ {
+		var wg sync.WaitGroup
+		for _, token := range tokens {
+			wg.Add(1)
+			go func(token string) {
+				defer wg.Done()
+				if err := SaveDeviceToken(path, token); err != nil {
+					t.Errorf("save token: %v", err)
+				}
+			}(token)
+		}
+		wg.Wait()
+		got, err := LoadDeviceToken(path)
+		if err != nil {
+			t.Fatalf("round %d: load token: %v", round, err)
+		}
+		if got != tokens[0] && got != tokens[1] {
+			t.Fatalf("round %d: unexpected token of length %d", round, len(got))
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// stateLockName is the lock file, next to the identity and token files,
+// that serializes their writes across node instances sharing a directory.
+const stateLockName = ".state.lock"
+
+// withStateLock runs fn holding an exclusive flock on the state lock in
+// path's directory. If the lock file cannot be opened, as on a read-only
+// filesystem where no other instance can write either, fn runs unlocked.
+func withStateLock(path string, fn func() error) error {
+	lock, err := os.OpenFile(filepath.Join(filepath.Dir(path), stateLockName), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return fn()
+	}
+	defer lock.Close()
+	for {
+		err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	return fn()
+}
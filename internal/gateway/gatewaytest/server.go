@@ -0,0 +1,251 @@
+// Package gatewaytest provides an in-process gateway for end-to-end tests.
+package gatewaytest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/openclaw/openclaw-node-kobo/internal/gateway"
+)
+
+// Event is a node event received by the server.
+type Event struct {
+	Name    string
+	Payload json.RawMessage
+}
+
+// Server speaks the gateway side of the node protocol to the latest node.
+type Server struct {
+	// HelloAuth is returned in hello-ok; set it before the client connects.
+	HelloAuth *gateway.HelloOkAuth
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+	seq      atomic.Uint64
+
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	writeMu  sync.Mutex
+	results  map[string]chan gateway.InvokeResultParams
+	connects chan gateway.ConnectParams
+	events   chan Event
+}
+
+func NewServer() *Server {
+	s := &Server{
+		results:  make(map[string]chan gateway.InvokeResultParams),
+		connects: make(chan gateway.ConnectParams, 16),
+		events:   make(chan Event, 64),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveWS))
+	return s
+}
+
+// URL returns the WebSocket URL clients should dial.
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/ws"
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+	s.mu.Unlock()
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// WaitConnected waits for a handshake and returns its connect params.
+func (s *Server) WaitConnected(ctx context.Context) (gateway.ConnectParams, error) {
+	select {
+	case params := <-s.connects:
+		return params, nil
+	case <-ctx.Done():
+		return gateway.ConnectParams{}, ctx.Err()
+	}
+}
+
+// Events returns node events sent by the client, dropping any overflow.
+func (s *Server) Events() <-chan Event {
+	return s.events
+}
+
+// Invoke sends a node.invoke.request and waits for its result.
+func (s *Server) Invoke(ctx context.Context, nodeID, command string, args interface{}) (gateway.InvokeResultParams, error) {
+	id := fmt.Sprintf("invoke-%d", s.seq.Add(1))
+	payload := map[string]interface{}{
+		"id":      id,
+		"nodeId":  nodeID,
+		"command": command,
+	}
+	if args != nil {
+		encoded, err := json.Marshal(args)
+		if err != nil {
+			return gateway.InvokeResultParams{}, err
+		}
+		payload["paramsJSON"] = string(encoded)
+	}
+	resultCh := make(chan gateway.InvokeResultParams, 1)
+	s.mu.Lock()
+	s.results[id] = resultCh
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.results, id)
+		s.mu.Unlock()
+	}()
+	if err := s.sendEvent("node.invoke.request", payload); err != nil {
+		return gateway.InvokeResultParams{}, err
+	}
+	select {
+	case result := <-resultCh:
+		return result, nil
+	case <-ctx.Done():
+		return gateway.InvokeResultParams{}, ctx.Err()
+	}
+}
+
+// Shutdown announces a gateway shutdown with the given restart hint.
+func (s *Server) Shutdown(reason string, restartExpectedMs int) error {
+	return s.sendEvent("shutdown", gateway.ShutdownPayload{Reason: reason, RestartExpectedMs: restartExpectedMs})
+}
+
+// CloseWith closes the current connection with a WebSocket close frame.
+func (s *Server) CloseWith(code int, reason string) error {
+	conn := s.current()
+	if conn == nil {
+		return errors.New("gatewaytest: no node connected")
+	}
+	s.writeMu.Lock()
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	s.writeMu.Unlock()
+	_ = conn.Close()
+	return err
+}
+
+// Drop closes the current connection without a close frame.
+func (s *Server) Drop() error {
+	conn := s.current()
+	if conn == nil {
+		return errors.New("gatewaytest: no node connected")
+	}
+	return conn.Close()
+}
+
+func (s *Server) current() *websocket.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+func (s *Server) sendEvent(event string, payload interface{}) error {
+	conn := s.current()
+	if conn == nil {
+		return errors.New("gatewaytest: no node connected")
+	}
+	return s.write(conn, map[string]interface{}{
+		"type":    "event",
+		"event":   event,
+		"payload": payload,
+	})
+}
+
+func (s *Server) write(conn *websocket.Conn, frame interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return conn.WriteJSON(frame)
+}
+
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+		s.mu.Lock()
+		if s.conn == conn {
+			s.conn = nil
+		}
+		s.mu.Unlock()
+	}()
+	nonce := fmt.Sprintf("nonce-%d", s.seq.Add(1))
+	if err := s.write(conn, map[string]interface{}{
+		"type":    "event",
+		"event":   "connect.challenge",
+		"payload": map[string]string{"nonce": nonce},
+	}); err != nil {
+		return
+	}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req gateway.RequestFrame
+		if err := json.Unmarshal(data, &req); err != nil || req.Type != "req" {
+			continue
+		}
+		switch req.Method {
+		case "connect":
+			if err := s.handleConnect(conn, req); err != nil {
+				return
+			}
+		case "node.invoke.result":
+			var result gateway.InvokeResultParams
+			if err := json.Unmarshal(req.Params, &result); err != nil {
+				continue
+			}
+			s.mu.Lock()
+			resultCh := s.results[result.RequestID]
+			s.mu.Unlock()
+			if resultCh != nil {
+				resultCh <- result
+			}
+		case "node.event":
+			var params struct {
+				Event   string          `json:"event"`
+				Payload json.RawMessage `json:"payload"`
+			}
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				continue
+			}
+			select {
+			case s.events <- Event{Name: params.Event, Payload: params.Payload}:
+			default:
+			}
+		}
+	}
+}
+
+func (s *Server) handleConnect(conn *websocket.Conn, req gateway.RequestFrame) error {
+	var params gateway.ConnectParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(gateway.HelloOkPayload{Type: "hello-ok", Auth: s.HelloAuth})
+	if err != nil {
+		return err
+	}
+	if err := s.write(conn, gateway.ResponseFrame{Type: "res", ID: req.ID, OK: true, Payload: payload}); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	select {
+	case s.connects <- params:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package gatewaytest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openclaw/openclaw-node-kobo/internal/gateway"
+	"github.com/rs/zerolog"
+)
+
+func TestServerInvokeRoundTrip(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	client := gateway.New(gateway.Config{
+		URL:      srv.URL(),
+		Dialer:   (&net.Dialer{}).DialContext,
+		Logger:   zerolog.Nop(),
+		Register: gateway.DefaultRegistration(),
+		OnInvoke: func(ctx context.Context, req gateway.InvokeRequestParams) (interface{}, error) {
+			var args map[string]string
+			if err := json.Unmarshal(req.Args, &args); err != nil {
+				return nil, err
+			}
+			return map[string]string{"command": req.Command, "echo": args["text"]}, nil
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- client.Run(ctx)
+	}()
+
+	params, err := srv.WaitConnected(ctx)
+	if err != nil {
+		t.Fatalf("wait connected: %v", err)
+	}
+	if params.MinProtocol != gateway.ProtocolVersion || params.Client.ID != "node-host" {
+		t.Fatalf("unexpected connect params %+v", params)
+	}
+	result, err := srv.Invoke(ctx, "node-1", "canvas.state", map[string]string{"text": "hi"})
+	if err != nil {
+		t.Fatalf("invoke: %v", err)
+	}
+	payload, _ := result.Result.(map[string]interface{})
+	if !result.OK || result.NodeID != "node-1" || payload["command"] != "canvas.state" || payload["echo"] != "hi" {
+		t.Fatalf("unexpected invoke result %+v", result)
+	}
+
+	if err := srv.Shutdown("restart", 10); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if _, err := srv.WaitConnected(ctx); err != nil {
+		t.Fatalf("expected reconnect after shutdown: %v", err)
+	}
+	if err := srv.Drop(); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if _, err := srv.WaitConnected(ctx); err != nil {
+		t.Fatalf("expected reconnect after drop: %v", err)
+	}
+	if _, err := srv.Invoke(ctx, "node-1", "canvas.hide", map[string]string{}); err != nil {
+		t.Fatalf("invoke after reconnect: %v", err)
+	}
+
+	// The client only observes cancellation between reads, so drop the
+	// connection to unblock it.
+	cancel()
+	_ = srv.Drop()
+	select {
+	case err := <-runErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected Run to stop on cancel, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Run did not stop after cancel")
+	}
+}
+
+func TestServerScopeRestrictedCommands(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.HelloAuth = &gateway.HelloOkAuth{Role: "node", Scopes: []string{"canvas.read"}}
+
+	client := gateway.New(gateway.Config{
+		URL:      srv.URL(),
+		Dialer:   (&net.Dialer{}).DialContext,
+		Logger:   zerolog.Nop(),
+		Register: gateway.DefaultRegistration(),
+		ScopeCommands: map[string][]string{
+			"canvas.read":  {"canvas.state", "canvas.snapshot"},
+			"canvas.write": {"canvas.present", "canvas.a2ui.push"},
+		},
+		OnInvoke: func(ctx context.Context, req gateway.InvokeRequestParams) (interface{}, error) {
+			return "ok", nil
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() {
+		_ = client.Run(ctx)
+	}()
+	if _, err := srv.WaitConnected(ctx); err != nil {
+		t.Fatalf("wait connected: %v", err)
+	}
+
+	result, err := srv.Invoke(ctx, "node-1", "canvas.present", nil)
+	if err != nil {
+		t.Fatalf("invoke: %v", err)
+	}
+	if result.OK || result.Error == nil || result.Error.Code != gateway.ErrCodePermissionDenied {
+		t.Fatalf("expected canvas.present to be denied for a read-only node, got %+v", result)
+	}
+	result, err = srv.Invoke(ctx, "node-1", "canvas.state", nil)
+	if err != nil {
+		t.Fatalf("invoke: %v", err)
+	}
+	if !result.OK {
+		t.Fatalf("expected granted canvas.state to run, got %+v", result)
+	}
+	result, err = srv.Invoke(ctx, "node-1", "canvas.image", nil)
+	if err != nil {
+		t.Fatalf("invoke: %v", err)
+	}
+	if result.OK || result.Error == nil || result.Error.Code != gateway.ErrCodePermissionDenied {
+		t.Fatalf("expected unmapped canvas.image to be denied, got %+v", result)
+	}
+	cancel()
+	_ = srv.Drop()
+}
+
+func TestServerNewerPushSupersedesSlowOne(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	started := make(chan string, 2)
+	client := gateway.New(gateway.Config{
+		URL:      srv.URL(),
+		Dialer:   (&net.Dialer{}).DialContext,
+		Logger:   zerolog.Nop(),
+		Register: gateway.DefaultRegistration(),
+		OnInvoke: func(ctx context.Context, req gateway.InvokeRequestParams) (interface{}, error) {
+			var args map[string]string
+			if err := json.Unmarshal(req.Args, &args); err != nil {
+				return nil, err
+			}
+			started <- args["text"]
+			if args["text"] == "slow" {
+				// A render that only ends when superseded.
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return args["text"], nil
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() {
+		_ = client.Run(ctx)
+	}()
+	if _, err := srv.WaitConnected(ctx); err != nil {
+		t.Fatalf("wait connected: %v", err)
+	}
+
+	slow := make(chan gateway.InvokeResultParams, 1)
+	go func() {
+		result, _ := srv.Invoke(ctx, "node-1", "canvas.a2ui.push", map[string]string{"text": "slow"})
+		slow <- result
+	}()
+	if got := <-started; got != "slow" {
+		t.Fatalf("expected the slow push first, got %q", got)
+	}
+	result, err := srv.Invoke(ctx, "node-1", "canvas.a2ui.push", map[string]string{"text": "fast"})
+	if err != nil {
+		t.Fatalf("invoke: %v", err)
+	}
+	if !result.OK || result.Result != "fast" {
+		t.Fatalf("expected the newer push to run, got %+v", result)
+	}
+	if superseded := <-slow; superseded.OK || superseded.Error == nil {
+		t.Fatalf("expected the slow push cancelled, got %+v", superseded)
+	}
+	cancel()
+	_ = srv.Drop()
+}
@@ -34,7 +34,23 @@ type deviceIdentityFile struct {
 	CreatedAtMs   int64  `json:"createdAtMs"`
 }
 
+// LoadOrCreateIdentity loads the identity at path, or generates and saves
+// one. It holds the state lock throughout so concurrently starting instances
+// agree on one identity and never interleave writes with a token save.
 func LoadOrCreateIdentity(path string) (*DeviceIdentity, error) {
+	var identity *DeviceIdentity
+	err := withStateLock(path, func() error {
+		var err error
+		identity, err = loadOrCreateIdentity(path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func loadOrCreateIdentity(path string) (*DeviceIdentity, error) {
 	data, err := os.ReadFile(path)
 	if err == nil {
 		var stored deviceIdentityFile
@@ -74,24 +90,15 @@ func LoadOrCreateIdentity(path string) (*DeviceIdentity, error) {
 	if !errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
-	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
-	if err != nil {
-		return nil, err
-	}
-	publicPem, err := marshalPublicKeyPem(publicKey)
-	if err != nil {
-		return nil, err
-	}
-	privatePem, err := marshalPrivateKeyPem(privateKey)
+	identity, err := NewEphemeralIdentity()
 	if err != nil {
 		return nil, err
 	}
-	deviceID := deviceIDFromPublicKey(publicKey)
 	stored := deviceIdentityFile{
 		Version:       deviceIdentityVersion,
-		DeviceID:      deviceID,
-		PublicKeyPem:  publicPem,
-		PrivateKeyPem: privatePem,
+		DeviceID:      identity.DeviceID,
+		PublicKeyPem:  identity.PublicKeyPem,
+		PrivateKeyPem: identity.PrivateKeyPem,
 		CreatedAtMs:   time.Now().UnixMilli(),
 	}
 	encoded, err := json.MarshalIndent(stored, "", "  ")
@@ -101,8 +108,27 @@ func LoadOrCreateIdentity(path string) (*DeviceIdentity, error) {
 	if err := os.WriteFile(path, encoded, 0o600); err != nil {
 		return nil, err
 	}
+	return identity, nil
+}
+
+// NewEphemeralIdentity generates an identity without saving it, for when the
+// state directory is read-only. The gateway sees it as a new device each
+// time the node starts.
+func NewEphemeralIdentity() (*DeviceIdentity, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	publicPem, err := marshalPublicKeyPem(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	privatePem, err := marshalPrivateKeyPem(privateKey)
+	if err != nil {
+		return nil, err
+	}
 	return &DeviceIdentity{
-		DeviceID:      deviceID,
+		DeviceID:      deviceIDFromPublicKey(publicKey),
 		PublicKeyPem:  publicPem,
 		PrivateKeyPem: privatePem,
 		publicKey:     publicKey,
@@ -111,12 +137,24 @@ func LoadOrCreateIdentity(path string) (*DeviceIdentity, error) {
 }
 
 func (d *DeviceIdentity) PublicKeyRawBase64Url() string {
+	d.ensurePublicKey()
+	return base64URLEncode([]byte(d.publicKey))
+}
+
+func (d *DeviceIdentity) ensurePublicKey() {
 	if len(d.publicKey) == 0 && d.PublicKeyPem != "" {
 		if pub, err := parsePublicKeyPem(d.PublicKeyPem); err == nil {
 			d.publicKey = pub
 		}
 	}
-	return base64URLEncode([]byte(d.publicKey))
+}
+
+// Fingerprint returns the SHA-256 of the raw public key in the familiar
+// "SHA256:<base64>" form, for comparing keys by eye.
+func (d *DeviceIdentity) Fingerprint() string {
+	d.ensurePublicKey()
+	hash := sha256.Sum256(d.publicKey)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:])
 }
 
 func (d *DeviceIdentity) Sign(payload string) string {
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// invokeQueue runs a connection's invokes one at a time, in the order they
+// arrived, off the read loop. A newer invoke of a command cancels an older
+// one still queued or running, so a slow push is superseded by the next.
+type invokeQueue struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger zerolog.Logger
+
+	mu      sync.Mutex
+	tail    chan struct{}
+	current map[string]*queuedInvoke
+	wg      sync.WaitGroup
+}
+
+type queuedInvoke struct {
+	cancel context.CancelFunc
+}
+
+func newInvokeQueue(ctx context.Context, logger zerolog.Logger) *invokeQueue {
+	ctx, cancel := context.WithCancel(ctx)
+	return &invokeQueue{ctx: ctx, cancel: cancel, logger: logger, current: make(map[string]*queuedInvoke)}
+}
+
+// hold makes invokes wait until release is called. Call it before any add.
+func (q *invokeQueue) hold() (release func()) {
+	gate := make(chan struct{})
+	q.mu.Lock()
+	q.tail = gate
+	q.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(gate) })
+	}
+}
+
+// add queues run behind every invoke added before it.
+func (q *invokeQueue) add(command string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(q.ctx)
+	entry := &queuedInvoke{cancel: cancel}
+	done := make(chan struct{})
+	q.mu.Lock()
+	if prev, ok := q.current[command]; ok {
+		q.logger.Debug().Str("command", command).Msg("gateway: cancelling superseded invoke")
+		prev.cancel()
+	}
+	q.current[command] = entry
+	prev := q.tail
+	q.tail = done
+	q.mu.Unlock()
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		defer close(done)
+		defer q.finish(command, entry)
+		if prev != nil {
+			<-prev
+		}
+		run(ctx)
+	}()
+}
+
+func (q *invokeQueue) finish(command string, entry *queuedInvoke) {
+	q.mu.Lock()
+	if q.current[command] == entry {
+		delete(q.current, command)
+	}
+	q.mu.Unlock()
+	entry.cancel()
+}
+
+// stop cancels every queued invoke and waits for them to finish.
+func (q *invokeQueue) stop() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+// queueInvoke parses an invoke payload and queues it on invokes.
+func (c *Client) queueInvoke(invokes *invokeQueue, payload json.RawMessage) error {
+	params, err := parseInvokePayload(payload)
+	if err != nil {
+		return err
+	}
+	invokes.add(params.Command, func(ctx context.Context) {
+		if err := c.handleInvoke(ctx, params); err != nil {
+			c.logger.Warn().Err(err).Msg("gateway: invoke handler error")
+		}
+	})
+	return nil
+}
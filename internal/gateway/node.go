@@ -18,9 +18,20 @@ func DefaultRegistration() NodeRegistration {
 			"canvas.navigate",
 			"canvas.eval",
 			"canvas.snapshot",
+			"canvas.blit",
+			"canvas.state",
+			"canvas.erase",
+			"canvas.image",
+			"canvas.measureText",
+			"canvas.marquee.start",
+			"canvas.marquee.stop",
+			"canvas.screen.show",
+			"canvas.template.register",
+			"canvas.template.render",
 			"canvas.a2ui.push",
 			"canvas.a2ui.pushJSONL",
 			"canvas.a2ui.reset",
+			"status.get",
 		},
 	}
 }
@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	minReadTimeout = 60 * time.Second
+	pingReadSlack  = 10 * time.Second
+)
+
+// pingStableRounds is how many pings in a row must go out on a live
+// connection before the ping interval is lengthened.
+const pingStableRounds = 10
+
+// adaptivePing picks the interval between keepalive pings. On a stable link
+// it doubles the interval every pingStableRounds pings, up to max, to spare
+// the radio; after a dropped connection it falls back to min so the next
+// drop is noticed sooner. With min equal to max the interval is fixed.
+type adaptivePing struct {
+	mu       sync.Mutex
+	min      time.Duration
+	max      time.Duration
+	interval time.Duration
+	stable   int
+}
+
+func newAdaptivePing(interval, min, max time.Duration) *adaptivePing {
+	if min <= 0 || min > interval {
+		min = interval
+	}
+	if max < interval {
+		max = interval
+	}
+	return &adaptivePing{min: min, max: max, interval: interval}
+}
+
+// next records a ping sent on a live connection and returns how long to
+// wait before the following one.
+func (p *adaptivePing) next() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stable++
+	if p.stable >= pingStableRounds && p.interval < p.max {
+		p.interval = min(2*p.interval, p.max)
+		p.stable = 0
+	}
+	return p.interval
+}
+
+// current returns the interval without counting a ping.
+func (p *adaptivePing) current() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interval
+}
+
+// readTimeout is how long a read may wait: long enough for the next ping,
+// even once the interval doubles, to be answered.
+func (p *adaptivePing) readTimeout() time.Duration {
+	return max(minReadTimeout, 2*p.current()+pingReadSlack)
+}
+
+// dropped records a lost connection.
+func (p *adaptivePing) dropped() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interval = p.min
+	p.stable = 0
+}
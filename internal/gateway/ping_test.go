@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptivePing_LengthensWhenStableAndShortensAfterDrop(t *testing.T) {
+	p := newAdaptivePing(30*time.Second, 10*time.Second, 2*time.Minute)
+	pings := func(n int) time.Duration {
+		var interval time.Duration
+		for i := 0; i < n; i++ {
+			interval = p.next()
+		}
+		return interval
+	}
+	if got := pings(pingStableRounds - 1); got != 30*time.Second {
+		t.Fatalf("expected interval unchanged before a stable run, got %v", got)
+	}
+	if got := pings(1); got != time.Minute {
+		t.Fatalf("expected interval doubled after a stable run, got %v", got)
+	}
+	if got := pings(3 * pingStableRounds); got != 2*time.Minute {
+		t.Fatalf("expected interval capped at max, got %v", got)
+	}
+
+	p.dropped()
+	if got := p.current(); got != 10*time.Second {
+		t.Fatalf("expected interval at min after a drop, got %v", got)
+	}
+	if got := pings(pingStableRounds); got != 20*time.Second {
+		t.Fatalf("expected interval to grow again from min, got %v", got)
+	}
+}
+
+func TestAdaptivePing_ReadTimeoutOutlastsInterval(t *testing.T) {
+	p := newAdaptivePing(20*time.Second, 0, 5*time.Minute)
+	if got := p.readTimeout(); got != minReadTimeout {
+		t.Fatalf("expected the minimum read timeout at a short interval, got %v", got)
+	}
+	for i := 0; i < 5*pingStableRounds; i++ {
+		p.next()
+	}
+	if interval := p.current(); interval != 5*time.Minute || p.readTimeout() <= 2*interval {
+		t.Fatalf("expected the read timeout to outlast two %v intervals, got %v", interval, p.readTimeout())
+	}
+}
+
+func TestAdaptivePing_FixedWithoutBounds(t *testing.T) {
+	p := newAdaptivePing(30*time.Second, 0, 0)
+	for i := 0; i < 3*pingStableRounds; i++ {
+		if got := p.next(); got != 30*time.Second {
+			t.Fatalf("expected fixed interval, got %v", got)
+		}
+	}
+	p.dropped()
+	if got := p.current(); got != 30*time.Second {
+		t.Fatalf("expected fixed interval after drop, got %v", got)
+	}
+
+	// Bounds on the wrong side of the interval are widened to include it.
+	p = newAdaptivePing(30*time.Second, time.Minute, 10*time.Second)
+	if p.min != 30*time.Second || p.max != 30*time.Second {
+		t.Fatalf("expected bounds clamped to the interval, got %v-%v", p.min, p.max)
+	}
+}
@@ -93,20 +93,34 @@ type NodeRegistration struct {
 type HelloOkPayload struct {
 	Type string       `json:"type"`
 	Auth *HelloOkAuth `json:"auth,omitempty"`
+	Caps []string     `json:"caps,omitempty"`
 }
 
 type HelloOkAuth struct {
 	DeviceToken string   `json:"deviceToken,omitempty"`
+	NodeID      string   `json:"nodeId,omitempty"`
 	Role        string   `json:"role,omitempty"`
 	Scopes      []string `json:"scopes,omitempty"`
 	IssuedAtMs  int64    `json:"issuedAtMs,omitempty"`
 }
 
 type InvokeRequestParams struct {
-	RequestID string          `json:"id"`
-	NodeID    string          `json:"nodeId"`
-	Command   string          `json:"command"`
-	Args      json.RawMessage `json:"args,omitempty"`
+	RequestID string           `json:"id"`
+	NodeID    string           `json:"nodeId"`
+	Command   string           `json:"command"`
+	Args      json.RawMessage  `json:"args,omitempty"`
+	Params    json.RawMessage  `json:"-"`
+	Progress  ProgressReporter `json:"-"`
+	// TraceID correlates the invoke with the events it leads to.
+	TraceID string `json:"traceId,omitempty"`
+}
+
+type InvokeProgressPayload struct {
+	RequestID string  `json:"id"`
+	NodeID    string  `json:"nodeId"`
+	Command   string  `json:"command,omitempty"`
+	Progress  float64 `json:"progress"`
+	Message   string  `json:"message,omitempty"`
 }
 
 type InvokeResultParams struct {
@@ -123,8 +137,26 @@ type NodeInvokeError struct {
 	Message string `json:"message,omitempty"`
 }
 
+const ErrCodePermissionDenied = "permission_denied"
+
+// ErrCodeResultTooLarge replaces a result larger than the node's configured
+// limit.
+const ErrCodeResultTooLarge = "result_too_large"
+
+// InvokeError is returned by invoke handlers to report a failure with a
+// machine-readable code in the invoke result.
+type InvokeError struct {
+	Code    string
+	Message string
+}
+
+func (e *InvokeError) Error() string {
+	return e.Message
+}
+
 type NodeEventParams struct {
 	Event       string      `json:"event"`
+	NodeID      string      `json:"nodeId,omitempty"`
 	Payload     interface{} `json:"payload,omitempty"`
 	PayloadJSON *string     `json:"payloadJSON,omitempty"`
 }
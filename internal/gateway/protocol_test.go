@@ -321,9 +321,20 @@ func TestDefaultRegistration_Commands(t *testing.T) {
 		"canvas.navigate",
 		"canvas.eval",
 		"canvas.snapshot",
+		"canvas.blit",
+		"canvas.state",
+		"canvas.erase",
+		"canvas.image",
+		"canvas.measureText",
+		"canvas.marquee.start",
+		"canvas.marquee.stop",
+		"canvas.screen.show",
+		"canvas.template.register",
+		"canvas.template.render",
 		"canvas.a2ui.push",
 		"canvas.a2ui.pushJSONL",
 		"canvas.a2ui.reset",
+		"status.get",
 	}
 	if !reflect.DeepEqual(reg.Commands, expected) {
 		t.Fatalf("unexpected commands")
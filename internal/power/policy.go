@@ -0,0 +1,119 @@
+package power
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// MaxIdleTimeout bounds the idle timeout a policy may set.
+const MaxIdleTimeout = 24 * time.Hour
+
+// Policy is power configuration pushed by the gateway; unset fields are kept.
+type Policy struct {
+	IdleTimeoutMin *int    `json:"idleTimeoutMin,omitempty"`
+	SuspendEnabled *bool   `json:"suspendEnabled,omitempty"`
+	DoNotDisturb   *string `json:"doNotDisturb,omitempty"`
+}
+
+type policyFile struct {
+	Policy    Policy `json:"policy"`
+	SavedAtMs int64  `json:"savedAtMs"`
+}
+
+func (p Policy) Validate() error {
+	if p.IdleTimeoutMin != nil {
+		if minutes := *p.IdleTimeoutMin; minutes < 0 || time.Duration(minutes)*time.Minute > MaxIdleTimeout {
+			return fmt.Errorf("power: idle timeout %d min outside 0-%d", minutes, int(MaxIdleTimeout.Minutes()))
+		}
+	}
+	if p.DoNotDisturb != nil && *p.DoNotDisturb != "" {
+		if _, err := ParseWindow(*p.DoNotDisturb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge returns p with the fields set in next replacing its own.
+func (p Policy) Merge(next Policy) Policy {
+	if next.IdleTimeoutMin != nil {
+		p.IdleTimeoutMin = next.IdleTimeoutMin
+	}
+	if next.SuspendEnabled != nil {
+		p.SuspendEnabled = next.SuspendEnabled
+	}
+	if next.DoNotDisturb != nil {
+		p.DoNotDisturb = next.DoNotDisturb
+	}
+	return p
+}
+
+// ApplyPolicy validates p and applies it, restarting the idle timer.
+func (m *Manager) ApplyPolicy(p Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	m.init()
+	m.idleMu.Lock()
+	if p.IdleTimeoutMin != nil {
+		m.IdleTimeout = time.Duration(*p.IdleTimeoutMin) * time.Minute
+	}
+	if p.SuspendEnabled != nil {
+		m.SuspendEnabled = *p.SuspendEnabled
+	}
+	if p.DoNotDisturb != nil {
+		m.DoNotDisturb = nil
+		if *p.DoNotDisturb != "" {
+			window, _ := ParseWindow(*p.DoNotDisturb)
+			m.DoNotDisturb = &window
+		}
+	}
+	if m.SuspendEnabled && m.IdleTimeout > 0 {
+		m.resetTimersLocked()
+	} else {
+		for _, t := range []timer{m.idleTimer, m.warnTimer} {
+			if t != nil {
+				t.Stop()
+			}
+		}
+		m.idleDeadline = time.Time{}
+	}
+	cleared := m.warning
+	m.warning = false
+	m.idleMu.Unlock()
+	if cleared && m.OnIdleWarningCleared != nil {
+		m.OnIdleWarningCleared()
+	}
+	select {
+	case m.policyChange <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// LoadPolicy reads a policy saved by SavePolicy, or an empty one if missing.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Policy{}, nil
+		}
+		return Policy{}, err
+	}
+	var stored policyFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return Policy{}, err
+	}
+	return stored.Policy, nil
+}
+
+func SavePolicy(path string, p Policy) error {
+	encoded, err := json.MarshalIndent(policyFile{Policy: p, SavedAtMs: time.Now().UnixMilli()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, encoded, 0o600)
+}
@@ -0,0 +1,101 @@
+package power
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManagerApplyPolicyEnablesIdleSuspend(t *testing.T) {
+	clock := newFakeClock(time.Unix(1, 0))
+	suspendCh := make(chan struct{}, 1)
+	m := &Manager{
+		clock: clock,
+		suspendFunc: func() error {
+			suspendCh <- struct{}{}
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- m.Run(ctx)
+	}()
+
+	minutes, enabled := 1, true
+	if err := m.ApplyPolicy(Policy{IdleTimeoutMin: &minutes, SuspendEnabled: &enabled}); err != nil {
+		t.Fatalf("apply policy: %v", err)
+	}
+	if snapshot := m.Snapshot(); !snapshot.SuspendEnabled || snapshot.IdleTimeoutMs != time.Minute.Milliseconds() {
+		t.Fatalf("expected policy reflected in snapshot, got %+v", snapshot)
+	}
+	// Give Run a chance to pick up the new timer before the clock moves.
+	deadline := time.Now().Add(time.Second)
+	for {
+		clock.Advance(time.Minute)
+		select {
+		case <-suspendCh:
+		case <-time.After(10 * time.Millisecond):
+			if time.Now().Before(deadline) {
+				continue
+			}
+			t.Fatalf("suspend did not fire after policy enabled idle suspend")
+		}
+		break
+	}
+
+	off := 0
+	if err := m.ApplyPolicy(Policy{IdleTimeoutMin: &off}); err != nil {
+		t.Fatalf("apply policy: %v", err)
+	}
+	clock.Advance(time.Hour)
+	select {
+	case <-suspendCh:
+		t.Fatalf("suspend fired with idle suspend disabled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	<-doneCh
+}
+
+func TestPolicyValidate(t *testing.T) {
+	negative, tooLong := -1, 25*60
+	badWindow, clear := "nine-to-five", ""
+	for _, p := range []Policy{{IdleTimeoutMin: &negative}, {IdleTimeoutMin: &tooLong}, {DoNotDisturb: &badWindow}} {
+		if err := p.Validate(); err == nil {
+			t.Fatalf("expected %+v to be rejected", p)
+		}
+	}
+	m := &Manager{}
+	if err := m.ApplyPolicy(Policy{IdleTimeoutMin: &negative}); err == nil || m.IdleTimeout != 0 {
+		t.Fatalf("expected invalid policy not applied, got err %v timeout %v", err, m.IdleTimeout)
+	}
+	window := "22:00-06:00"
+	if err := m.ApplyPolicy(Policy{DoNotDisturb: &window}); err != nil || m.DoNotDisturb == nil {
+		t.Fatalf("expected window applied, got err %v", err)
+	}
+	if err := m.ApplyPolicy(Policy{DoNotDisturb: &clear}); err != nil || m.DoNotDisturb != nil {
+		t.Fatalf("expected empty window to clear it, got err %v", err)
+	}
+}
+
+func TestPolicySaveLoadMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "power-policy.json")
+	if p, err := LoadPolicy(path); err != nil || p != (Policy{}) {
+		t.Fatalf("expected empty policy for missing file, got %+v, %v", p, err)
+	}
+	minutes, enabled, window := 15, false, "08:00-18:00"
+	saved := Policy{IdleTimeoutMin: &minutes}.Merge(Policy{SuspendEnabled: &enabled, DoNotDisturb: &window})
+	if err := SavePolicy(path, saved); err != nil {
+		t.Fatalf("save policy: %v", err)
+	}
+	loaded, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("load policy: %v", err)
+	}
+	if *loaded.IdleTimeoutMin != 15 || *loaded.SuspendEnabled || *loaded.DoNotDisturb != window {
+		t.Fatalf("unexpected loaded policy %+v", loaded)
+	}
+}
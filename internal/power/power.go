@@ -49,44 +49,90 @@ func (t *systemTimer) Reset(d time.Duration) bool {
 	return t.timer.Reset(d)
 }
 
+// Manager suspends the device after IdleTimeout without activity.
 type Manager struct {
 	IdleTimeout    time.Duration
 	SuspendEnabled bool
 	OnSuspend      func()
 	OnResume       func()
+	// IdleWarning is how long before the idle timeout OnIdleWarning starts
+	// being called, once per second with the time remaining. Zero disables it.
+	IdleWarning          time.Duration
+	OnIdleWarning        func(remaining time.Duration)
+	OnIdleWarningCleared func()
+	// DoNotDisturb, when set, blocks suspend during that time of day.
+	DoNotDisturb *Window
 
 	clock        clock
 	suspendFunc  func() error
 	debounce     time.Duration
 	initOnce     sync.Once
 	idleMu       sync.Mutex
+	policyChange chan struct{}
 	idleTimer    timer
+	warnTimer    timer
+	idleDeadline time.Time
+	warning      bool
 	suspending   atomic.Bool
 	wifiBusy     atomic.Bool
 	commandBusy  atomic.Bool
+	renderBusy   atomic.Bool
 	lastWakeNano atomic.Int64
+
+	statsMu         sync.Mutex
+	suspendCycles   int64
+	suspendFailures int64
+	lastSleep       time.Duration
+	lastResume      time.Duration
+	totalResume     time.Duration
+	ipSamples       int64
+	totalTimeToIP   time.Duration
 }
 
 func (m *Manager) ResetIdle() {
 	m.init()
+	m.idleMu.Lock()
 	if !m.SuspendEnabled || m.IdleTimeout <= 0 {
+		m.idleMu.Unlock()
 		return
 	}
-	m.idleMu.Lock()
-	defer m.idleMu.Unlock()
-	if m.idleTimer == nil {
-		m.idleTimer = m.clock.NewTimer(m.IdleTimeout)
-		return
+	m.resetTimersLocked()
+	cleared := m.warning
+	m.warning = false
+	m.idleMu.Unlock()
+	if cleared && m.OnIdleWarningCleared != nil {
+		m.OnIdleWarningCleared()
+	}
+}
+
+func (m *Manager) resetTimersLocked() {
+	m.idleDeadline = m.clock.Now().Add(m.IdleTimeout)
+	m.idleTimer = resetTimer(m.clock, m.idleTimer, m.IdleTimeout)
+	if warnAt := m.IdleTimeout - m.IdleWarning; m.IdleWarning > 0 && warnAt > 0 {
+		m.warnTimer = resetTimer(m.clock, m.warnTimer, warnAt)
 	}
-	if !m.idleTimer.Stop() {
-		drainTimer(m.idleTimer)
+}
+
+func resetTimer(c clock, t timer, d time.Duration) timer {
+	if t == nil {
+		return c.NewTimer(d)
 	}
-	m.idleTimer.Reset(m.IdleTimeout)
+	if !t.Stop() {
+		drainTimer(t)
+	}
+	t.Reset(d)
+	return t
 }
 
-func (m *Manager) Suspend() error {
+// Suspend runs OnSuspend, puts the device to sleep, and runs OnResume once
+// it wakes. If ctx is cancelled along the way, e.g. by a shutdown signal,
+// the remaining steps are skipped so resume hooks do not race process exit.
+func (m *Manager) Suspend(ctx context.Context) error {
 	m.init()
-	if !m.SuspendEnabled {
+	m.idleMu.Lock()
+	enabled := m.SuspendEnabled
+	m.idleMu.Unlock()
+	if !enabled {
 		return nil
 	}
 	if !m.suspending.CompareAndSwap(false, true) {
@@ -96,43 +142,181 @@ func (m *Manager) Suspend() error {
 	if !m.canSuspend() {
 		return ErrSuspendBlocked
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if m.OnSuspend != nil {
 		m.OnSuspend()
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	asleep := m.clock.Now()
 	if err := m.suspendFunc(); err != nil {
+		m.statsMu.Lock()
+		m.suspendFailures++
+		m.statsMu.Unlock()
+		// OnSuspend already took WiFi down and drew the sleep screen.
+		if ctx.Err() == nil && m.OnResume != nil {
+			m.OnResume()
+		}
+		return err
+	}
+	woke := m.clock.Now()
+	m.lastWakeNano.Store(woke.UnixNano())
+	if err := ctx.Err(); err != nil {
+		// The kernel write cannot be interrupted, so shutdown was requested
+		// while asleep; leave WiFi down rather than bring it up to exit.
 		return err
 	}
-	m.lastWakeNano.Store(m.clock.Now().UnixNano())
 	if m.OnResume != nil {
 		m.OnResume()
 	}
+	m.recordCycle(woke.Sub(asleep), m.clock.Now().Sub(woke))
 	m.ResetIdle()
 	return nil
 }
 
+func (m *Manager) recordCycle(sleep, resume time.Duration) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	m.suspendCycles++
+	m.lastSleep = sleep
+	m.lastResume = resume
+	m.totalResume += resume
+}
+
+// RecordTimeToIP records how long the network took to come back after a
+// wake, for the average reported in Snapshot.
+func (m *Manager) RecordTimeToIP(d time.Duration) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	m.ipSamples++
+	m.totalTimeToIP += d
+}
+
+// Run suspends when the idle timer expires.
 func (m *Manager) Run(ctx context.Context) error {
 	m.init()
-	if !m.SuspendEnabled || m.IdleTimeout <= 0 {
-		<-ctx.Done()
-		return ctx.Err()
-	}
-	m.idleMu.Lock()
-	if m.idleTimer == nil {
-		m.idleTimer = m.clock.NewTimer(m.IdleTimeout)
-	}
-	timer := m.idleTimer
-	m.idleMu.Unlock()
 	for {
+		idleC, warnC := m.armTimers()
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-timer.C():
-			_ = m.Suspend()
+		case <-m.policyChange:
+		case <-warnC:
+			m.idleWarningTick()
+		case <-idleC:
+			if err := m.Suspend(ctx); err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			}
 			m.ResetIdle()
 		}
 	}
 }
 
+// armTimers returns the timer channels, or nil ones if idle suspend is off.
+func (m *Manager) armTimers() (idle, warn <-chan time.Time) {
+	m.idleMu.Lock()
+	defer m.idleMu.Unlock()
+	if !m.SuspendEnabled || m.IdleTimeout <= 0 {
+		return nil, nil
+	}
+	if m.idleTimer == nil {
+		m.resetTimersLocked()
+	}
+	if m.warnTimer != nil {
+		warn = m.warnTimer.C()
+	}
+	return m.idleTimer.C(), warn
+}
+
+func (m *Manager) idleWarningTick() {
+	blocked := !m.canSuspend()
+	m.idleMu.Lock()
+	remaining := m.idleDeadline.Sub(m.clock.Now())
+	if remaining <= 0 || remaining > m.IdleWarning {
+		// Already expired, or ResetIdle rearmed the timers after this tick fired.
+		m.idleMu.Unlock()
+		return
+	}
+	m.warnTimer.Reset(min(time.Second, remaining))
+	if blocked {
+		// The device will not sleep, so do not count down to it.
+		cleared := m.warning
+		m.warning = false
+		m.idleMu.Unlock()
+		if cleared && m.OnIdleWarningCleared != nil {
+			m.OnIdleWarningCleared()
+		}
+		return
+	}
+	m.warning = true
+	m.idleMu.Unlock()
+	if m.OnIdleWarning != nil {
+		m.OnIdleWarning(remaining)
+	}
+}
+
+// Snapshot describes the manager's current state so operators can see why a
+// device is or is not sleeping.
+type Snapshot struct {
+	SuspendEnabled    bool   `json:"suspendEnabled"`
+	IdleTimeoutMs     int64  `json:"idleTimeoutMs"`
+	IdleRemainingMs   int64  `json:"idleRemainingMs"`
+	LastWakeMs        int64  `json:"lastWakeMs,omitempty"`
+	WiFiConnecting    bool   `json:"wifiConnecting"`
+	CommandProcessing bool   `json:"commandProcessing"`
+	Rendering         bool   `json:"rendering"`
+	Suspending        bool   `json:"suspending"`
+	DoNotDisturb      string `json:"doNotDisturb,omitempty"`
+	SuspendCycles     int64  `json:"suspendCycles"`
+	SuspendFailures   int64  `json:"suspendFailures,omitempty"`
+	LastSleepMs       int64  `json:"lastSleepMs,omitempty"`
+	LastResumeMs      int64  `json:"lastResumeMs,omitempty"`
+	AvgResumeMs       int64  `json:"avgResumeMs,omitempty"`
+	AvgTimeToIPMs     int64  `json:"avgTimeToIpMs,omitempty"`
+}
+
+func (m *Manager) Snapshot() Snapshot {
+	m.init()
+	snapshot := Snapshot{
+		WiFiConnecting:    m.wifiBusy.Load(),
+		CommandProcessing: m.commandBusy.Load(),
+		Rendering:         m.renderBusy.Load(),
+		Suspending:        m.suspending.Load(),
+	}
+	if lastWakeNano := m.lastWakeNano.Load(); lastWakeNano != 0 {
+		snapshot.LastWakeMs = time.Unix(0, lastWakeNano).UnixMilli()
+	}
+	m.statsMu.Lock()
+	snapshot.SuspendCycles = m.suspendCycles
+	snapshot.SuspendFailures = m.suspendFailures
+	snapshot.LastSleepMs = m.lastSleep.Milliseconds()
+	snapshot.LastResumeMs = m.lastResume.Milliseconds()
+	if m.suspendCycles > 0 {
+		snapshot.AvgResumeMs = (m.totalResume / time.Duration(m.suspendCycles)).Milliseconds()
+	}
+	if m.ipSamples > 0 {
+		snapshot.AvgTimeToIPMs = (m.totalTimeToIP / time.Duration(m.ipSamples)).Milliseconds()
+	}
+	m.statsMu.Unlock()
+	m.idleMu.Lock()
+	snapshot.SuspendEnabled = m.SuspendEnabled
+	snapshot.IdleTimeoutMs = m.IdleTimeout.Milliseconds()
+	if m.DoNotDisturb != nil {
+		snapshot.DoNotDisturb = m.DoNotDisturb.String()
+	}
+	deadline := m.idleDeadline
+	m.idleMu.Unlock()
+	if !deadline.IsZero() {
+		if remaining := deadline.Sub(m.clock.Now()); remaining > 0 {
+			snapshot.IdleRemainingMs = remaining.Milliseconds()
+		}
+	}
+	return snapshot
+}
+
 func (m *Manager) SetWiFiConnecting(busy bool) {
 	m.wifiBusy.Store(busy)
 }
@@ -141,8 +325,20 @@ func (m *Manager) SetCommandProcessing(busy bool) {
 	m.commandBusy.Store(busy)
 }
 
+// SetRendering blocks suspend while a present writes the framebuffer, which
+// may also run outside any command, e.g. a deferred present.
+func (m *Manager) SetRendering(busy bool) {
+	m.renderBusy.Store(busy)
+}
+
 func (m *Manager) canSuspend() bool {
-	if m.wifiBusy.Load() || m.commandBusy.Load() {
+	if m.wifiBusy.Load() || m.commandBusy.Load() || m.renderBusy.Load() {
+		return false
+	}
+	m.idleMu.Lock()
+	dnd := m.DoNotDisturb
+	m.idleMu.Unlock()
+	if dnd != nil && dnd.Contains(m.clock.Now()) {
 		return false
 	}
 	lastWakeNano := m.lastWakeNano.Load()
@@ -166,6 +362,7 @@ func (m *Manager) init() {
 		if m.debounce == 0 {
 			m.debounce = 30 * time.Second
 		}
+		m.policyChange = make(chan struct{}, 1)
 	})
 }
 
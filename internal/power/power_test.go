@@ -5,6 +5,7 @@ import (
 	"errors"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -162,14 +163,26 @@ func TestManagerSuspendGuard(t *testing.T) {
 		suspendFunc:    func() error { return nil },
 	}
 	m.SetWiFiConnecting(true)
-	if err := m.Suspend(); !errors.Is(err, ErrSuspendBlocked) {
+	if err := m.Suspend(context.Background()); !errors.Is(err, ErrSuspendBlocked) {
 		t.Fatalf("expected suspend blocked, got %v", err)
 	}
 	m.SetWiFiConnecting(false)
 	m.SetCommandProcessing(true)
-	if err := m.Suspend(); !errors.Is(err, ErrSuspendBlocked) {
+	if err := m.Suspend(context.Background()); !errors.Is(err, ErrSuspendBlocked) {
 		t.Fatalf("expected suspend blocked for command, got %v", err)
 	}
+	m.SetCommandProcessing(false)
+	m.SetRendering(true)
+	if err := m.Suspend(context.Background()); !errors.Is(err, ErrSuspendBlocked) {
+		t.Fatalf("expected suspend blocked while rendering, got %v", err)
+	}
+	if !m.Snapshot().Rendering {
+		t.Fatalf("expected snapshot to report rendering")
+	}
+	m.SetRendering(false)
+	if err := m.Suspend(context.Background()); err != nil {
+		t.Fatalf("expected suspend allowed after rendering, got %v", err)
+	}
 }
 
 func TestManagerSuspendDebounce(t *testing.T) {
@@ -181,7 +194,7 @@ func TestManagerSuspendDebounce(t *testing.T) {
 		suspendFunc:    func() error { return nil },
 	}
 	m.lastWakeNano.Store(clock.Now().UnixNano())
-	if err := m.Suspend(); !errors.Is(err, ErrSuspendBlocked) {
+	if err := m.Suspend(context.Background()); !errors.Is(err, ErrSuspendBlocked) {
 		t.Fatalf("expected debounce suspend blocked, got %v", err)
 	}
 }
@@ -204,7 +217,7 @@ func TestManagerSuspendCallbacks(t *testing.T) {
 	m.OnResume = func() {
 		order = append(order, "onResume")
 	}
-	if err := m.Suspend(); err != nil {
+	if err := m.Suspend(context.Background()); err != nil {
 		t.Fatalf("expected suspend to succeed, got %v", err)
 	}
 	want := []string{"onSuspend", "suspend", "onResume"}
@@ -218,6 +231,30 @@ func TestManagerSuspendCallbacks(t *testing.T) {
 	}
 }
 
+func TestManagerFailedSuspendResumes(t *testing.T) {
+	var order []string
+	m := &Manager{
+		IdleTimeout:    time.Second,
+		SuspendEnabled: true,
+		clock:          newFakeClock(time.Unix(1, 0)),
+		suspendFunc: func() error {
+			order = append(order, "suspend")
+			return errors.New("device busy")
+		},
+	}
+	m.OnSuspend = func() { order = append(order, "onSuspend") }
+	m.OnResume = func() { order = append(order, "onResume") }
+	if err := m.Suspend(context.Background()); err == nil {
+		t.Fatalf("expected the suspend error returned")
+	}
+	if want := []string{"onSuspend", "suspend", "onResume"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected %v after a failed suspend, got %v", want, order)
+	}
+	if snapshot := m.Snapshot(); snapshot.SuspendFailures != 1 || snapshot.SuspendCycles != 0 {
+		t.Fatalf("expected a failure and no cycle recorded, got %+v", snapshot)
+	}
+}
+
 func TestManagerSuspendInProgress(t *testing.T) {
 	clock := newFakeClock(time.Unix(1, 0))
 	blockCh := make(chan struct{})
@@ -231,17 +268,69 @@ func TestManagerSuspendInProgress(t *testing.T) {
 		},
 	}
 	go func() {
-		_ = m.Suspend()
+		_ = m.Suspend(context.Background())
 	}()
 	if !waitForSuspendState(m, true, 500*time.Millisecond) {
 		t.Fatalf("suspend did not start")
 	}
-	if err := m.Suspend(); !errors.Is(err, ErrSuspendInProgress) {
+	if err := m.Suspend(context.Background()); !errors.Is(err, ErrSuspendInProgress) {
 		t.Fatalf("expected suspend in progress, got %v", err)
 	}
 	close(blockCh)
 }
 
+func TestManagerSuspendCancelledSkipsHooks(t *testing.T) {
+	clock := newFakeClock(time.Unix(1, 0))
+	asleep := make(chan struct{})
+	wake := make(chan struct{})
+	var resumed atomic.Bool
+	m := &Manager{
+		IdleTimeout:    time.Second,
+		SuspendEnabled: true,
+		clock:          clock,
+		suspendFunc: func() error {
+			close(asleep)
+			<-wake
+			return nil
+		},
+		OnResume: func() {
+			resumed.Store(true)
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.Suspend(ctx)
+	}()
+	<-asleep
+	cancel()
+	close(wake)
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled suspend, got %v", err)
+	}
+	if resumed.Load() {
+		t.Fatalf("resume hook ran after cancellation")
+	}
+	if cycles := m.Snapshot().SuspendCycles; cycles != 0 {
+		t.Fatalf("expected no completed cycle, got %d", cycles)
+	}
+
+	var suspended bool
+	m = &Manager{
+		SuspendEnabled: true,
+		clock:          clock,
+		suspendFunc: func() error {
+			suspended = true
+			return nil
+		},
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	m.OnSuspend = cancel
+	if err := m.Suspend(ctx); !errors.Is(err, context.Canceled) || suspended {
+		t.Fatalf("expected shutdown during OnSuspend to skip sleep, got %v suspended %v", err, suspended)
+	}
+}
+
 func TestManagerResumeReconnectSequence(t *testing.T) {
 	clock := newFakeClock(time.Unix(1, 0))
 	var order []string
@@ -255,7 +344,7 @@ func TestManagerResumeReconnectSequence(t *testing.T) {
 		// Gateway reconnect happens when the WS read loop exits and Run() retries.
 		order = append(order, "wifi-enable", "tailscale-up", "gateway-reconnect")
 	}
-	if err := m.Suspend(); err != nil {
+	if err := m.Suspend(context.Background()); err != nil {
 		t.Fatalf("expected suspend to succeed, got %v", err)
 	}
 	want := []string{"wifi-enable", "tailscale-up", "gateway-reconnect"}
@@ -274,3 +363,225 @@ func waitForSuspendState(m *Manager, want bool, timeout time.Duration) bool {
 	}
 	return false
 }
+
+func TestManagerIdleWarningCountdown(t *testing.T) {
+	clock := newFakeClock(time.Unix(1, 0))
+	warnings := make(chan time.Duration, 4)
+	cleared := make(chan struct{}, 1)
+	m := &Manager{
+		IdleTimeout:    10 * time.Second,
+		SuspendEnabled: true,
+		IdleWarning:    3 * time.Second,
+		clock:          clock,
+		suspendFunc:    func() error { return nil },
+		OnIdleWarning: func(remaining time.Duration) {
+			warnings <- remaining
+		},
+		OnIdleWarningCleared: func() {
+			cleared <- struct{}{}
+		},
+	}
+	m.ResetIdle()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- m.Run(ctx)
+	}()
+
+	clock.Advance(6 * time.Second)
+	select {
+	case got := <-warnings:
+		t.Fatalf("warning shown early with %v remaining", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case got := <-warnings:
+		if got != 3*time.Second {
+			t.Fatalf("expected 3s remaining at threshold, got %v", got)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("warning not shown at threshold")
+	}
+	clock.Advance(time.Second)
+	select {
+	case got := <-warnings:
+		if got != 2*time.Second {
+			t.Fatalf("expected countdown to 2s, got %v", got)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("countdown did not tick")
+	}
+
+	m.ResetIdle()
+	select {
+	case <-cleared:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("warning not cleared on ResetIdle")
+	}
+	clock.Advance(5 * time.Second)
+	select {
+	case got := <-warnings:
+		t.Fatalf("unexpected warning after reset with %v remaining", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	<-doneCh
+}
+
+func TestManagerIdleWarningSkippedWhileBlocked(t *testing.T) {
+	clock := newFakeClock(time.Unix(1, 0))
+	warnings := make(chan time.Duration, 4)
+	cleared := make(chan struct{}, 1)
+	m := &Manager{
+		IdleTimeout:    10 * time.Second,
+		SuspendEnabled: true,
+		IdleWarning:    3 * time.Second,
+		clock:          clock,
+		suspendFunc:    func() error { return nil },
+		OnIdleWarning: func(remaining time.Duration) {
+			warnings <- remaining
+		},
+		OnIdleWarningCleared: func() {
+			cleared <- struct{}{}
+		},
+	}
+	m.ResetIdle()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- m.Run(ctx)
+	}()
+
+	clock.Advance(7 * time.Second)
+	select {
+	case <-warnings:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("warning not shown at threshold")
+	}
+
+	// A command starts: the countdown is taken down and not shown again.
+	m.SetCommandProcessing(true)
+	clock.Advance(time.Second)
+	select {
+	case <-cleared:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("warning not cleared while suspend is blocked")
+	}
+	clock.Advance(time.Second)
+	select {
+	case got := <-warnings:
+		t.Fatalf("unexpected warning while blocked with %v remaining", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	<-doneCh
+}
+
+func TestManagerSnapshot(t *testing.T) {
+	clock := newFakeClock(time.Unix(100, 0))
+	m := &Manager{
+		IdleTimeout:    5 * time.Minute,
+		SuspendEnabled: true,
+		clock:          clock,
+		suspendFunc:    func() error { return nil },
+	}
+	if err := m.Suspend(context.Background()); err != nil {
+		t.Fatalf("suspend: %v", err)
+	}
+	clock.Advance(time.Minute)
+	m.SetCommandProcessing(true)
+
+	snapshot := m.Snapshot()
+	if !snapshot.SuspendEnabled || snapshot.IdleTimeoutMs != (5*time.Minute).Milliseconds() {
+		t.Fatalf("expected configured timeout in snapshot, got %+v", snapshot)
+	}
+	if snapshot.LastWakeMs != time.Unix(100, 0).UnixMilli() {
+		t.Fatalf("expected last wake at resume, got %d", snapshot.LastWakeMs)
+	}
+	if snapshot.IdleRemainingMs != (4 * time.Minute).Milliseconds() {
+		t.Fatalf("expected 4m idle remaining, got %dms", snapshot.IdleRemainingMs)
+	}
+	if !snapshot.CommandProcessing || snapshot.WiFiConnecting {
+		t.Fatalf("expected command blocker reported, got %+v", snapshot)
+	}
+}
+
+func TestManagerSuspendCycleStats(t *testing.T) {
+	clock := newFakeClock(time.Unix(100, 0))
+	fail := false
+	m := &Manager{
+		IdleTimeout:    5 * time.Minute,
+		SuspendEnabled: true,
+		clock:          clock,
+		debounce:       time.Nanosecond,
+		suspendFunc: func() error {
+			if fail {
+				return errors.New("suspend refused")
+			}
+			clock.Advance(10 * time.Minute)
+			return nil
+		},
+	}
+	m.OnResume = func() {
+		clock.Advance(2 * time.Second)
+		m.RecordTimeToIP(2 * time.Second)
+	}
+	if err := m.Suspend(context.Background()); err != nil {
+		t.Fatalf("suspend: %v", err)
+	}
+	snapshot := m.Snapshot()
+	if snapshot.SuspendCycles != 1 || snapshot.LastSleepMs != (10*time.Minute).Milliseconds() {
+		t.Fatalf("expected one 10m cycle, got %+v", snapshot)
+	}
+	if snapshot.LastResumeMs != 2000 || snapshot.AvgResumeMs != 2000 || snapshot.AvgTimeToIPMs != 2000 {
+		t.Fatalf("expected 2s resume and time to IP, got %+v", snapshot)
+	}
+
+	m.OnResume = func() {
+		clock.Advance(4 * time.Second)
+		m.RecordTimeToIP(4 * time.Second)
+	}
+	clock.Advance(time.Second)
+	if err := m.Suspend(context.Background()); err != nil {
+		t.Fatalf("suspend: %v", err)
+	}
+	snapshot = m.Snapshot()
+	if snapshot.SuspendCycles != 2 || snapshot.LastResumeMs != 4000 || snapshot.AvgResumeMs != 3000 || snapshot.AvgTimeToIPMs != 3000 {
+		t.Fatalf("expected averages over two cycles, got %+v", snapshot)
+	}
+
+	fail = true
+	clock.Advance(time.Second)
+	if err := m.Suspend(context.Background()); err == nil {
+		t.Fatalf("expected suspend error")
+	}
+	if snapshot = m.Snapshot(); snapshot.SuspendCycles != 2 || snapshot.SuspendFailures != 1 {
+		t.Fatalf("expected failure counted separately, got %+v", snapshot)
+	}
+}
+
+func TestManagerDoNotDisturbWindow(t *testing.T) {
+	window, err := ParseWindow("08:00-18:00")
+	if err != nil {
+		t.Fatalf("parse window: %v", err)
+	}
+	clock := newFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	m := &Manager{
+		IdleTimeout:    time.Second,
+		SuspendEnabled: true,
+		DoNotDisturb:   &window,
+		clock:          clock,
+		suspendFunc:    func() error { return nil },
+	}
+	if err := m.Suspend(context.Background()); !errors.Is(err, ErrSuspendBlocked) {
+		t.Fatalf("expected suspend blocked inside window, got %v", err)
+	}
+	clock.Advance(6 * time.Hour)
+	if err := m.Suspend(context.Background()); err != nil {
+		t.Fatalf("expected suspend allowed at 18:00, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package power
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily time-of-day range [Start, End), measured from local
+// midnight. A window whose End is before its Start wraps past midnight.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses a window such as "08:00-18:00" or "22:00-06:30".
+func ParseWindow(value string) (Window, error) {
+	start, end, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return Window{}, fmt.Errorf("power: invalid window %q, want HH:MM-HH:MM", value)
+	}
+	startOffset, err := parseClockTime(start)
+	if err != nil {
+		return Window{}, err
+	}
+	endOffset, err := parseClockTime(end)
+	if err != nil {
+		return Window{}, err
+	}
+	return Window{Start: startOffset, End: endOffset}, nil
+}
+
+func parseClockTime(value string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("power: invalid time of day %q: %w", value, err)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t's local time of day falls inside the window.
+func (w Window) Contains(t time.Time) bool {
+	year, month, day := t.Date()
+	offset := t.Sub(time.Date(year, month, day, 0, 0, 0, 0, t.Location()))
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(w.Start.Hours()), int(w.Start.Minutes())%60, int(w.End.Hours()), int(w.End.Minutes())%60)
+}
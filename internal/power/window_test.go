@@ -0,0 +1,33 @@
+package power
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowContainsAcrossMidnight(t *testing.T) {
+	window, err := ParseWindow("22:00-06:30")
+	if err != nil {
+		t.Fatalf("parse window: %v", err)
+	}
+	cases := []struct {
+		hour, minute int
+		want         bool
+	}{
+		{21, 59, false},
+		{22, 0, true},
+		{0, 0, true},
+		{6, 29, true},
+		{6, 30, false},
+		{12, 0, false},
+	}
+	for _, tc := range cases {
+		at := time.Date(2024, 5, 1, tc.hour, tc.minute, 0, 0, time.UTC)
+		if got := window.Contains(at); got != tc.want {
+			t.Fatalf("Contains(%02d:%02d) = %v, want %v", tc.hour, tc.minute, got, tc.want)
+		}
+	}
+	if _, err := ParseWindow("8am to 6pm"); err == nil {
+		t.Fatalf("expected invalid window error")
+	}
+}